@@ -1,55 +1,78 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"flag"
 	"fmt"
-	"io"
 	"os"
-	"os/exec"
-	"path"
-	"path/filepath"
-	"strconv"
+	"os/signal"
+	"runtime"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/cima-lexis/lexisdn/config"
+	"github.com/cima-lexis/lexisdn/conversion"
 	"github.com/cima-lexis/lexisdn/fetcher"
+	"github.com/cima-lexis/lexisdn/namelist"
+	"github.com/cima-lexis/lexisdn/obs"
+	"github.com/cima-lexis/lexisdn/profile"
+	"github.com/cima-lexis/lexisdn/qc"
+	"github.com/cima-lexis/lexisdn/regrid"
 	"github.com/cima-lexis/lexisdn/webdrops"
-	"github.com/meteocima/dewetra2wrf"
-	"github.com/meteocima/radar2wrf/radar"
+	"github.com/cima-lexis/lexisdn/wps"
 )
 
 func usage(errmsg string, args ...interface{}) {
 	fmt.Fprintf(os.Stderr, errmsg, args...)
 	fmt.Fprint(os.Stderr, "\n\n")
-	fmt.Fprintln(os.Stderr, `Usage: lexisdn STARTDATE [DOWNLOAD_TYPE ...]
+	fmt.Fprintf(os.Stderr, `Usage: lexisdn [OPTIONS] STARTDATE [DOWNLOAD_TYPE ...]
 	STARTDATE - Satrt date/time of the simulation, in format YYYYMMDDHH
-	DOWNLOAD_TYPE - types of data to download. One of "RISICO" | "CONTINUUM" | "ADMS" | "LIMAGRAIN" | "WRFIT" | "WRFITDPC" | "WRFFR"
-	`)
+	DOWNLOAD_TYPE - types of data to download. Name of a profile, built-in ones are %s
+
+Options:
+`, strings.Join(profile.Builtin().Names(), " | "))
+	flag.PrintDefaults()
 	os.Exit(1)
 }
 
-func checkArguments() {
-	if len(os.Args) < 2 {
+var timeout = flag.Duration("timeout", 0, "maximum duration of the whole run, e.g. 2h30m. Zero means no limit")
+var regridTmplDir = flag.String("templates", conversion.DefaultTemplatesDir, "directory containing the wrfinput_dXX.template files with the grid of each WRF domain")
+var jobs = flag.Int("j", 0, "maximum number of radar conversions running concurrently. Zero means the number of CPUs")
+var namelistInput = flag.String("namelist-input", "", "WRF namelist.input whose max_dom sets the domains radar data are converted for. By default, all domains with a template are used")
+var namelistWPS = flag.String("namelist-wps", "", "WPS namelist.wps defining the simulation domains. When set, WRFDA stations and boundary conditions are restricted to the outermost domain instead of the profile one, and radar data are cropped to each domain")
+var profilesFile = flag.String("profiles", "", "YAML file with additional download profiles, or overriding built-in ones")
+var boundary = flag.Bool("boundary", false, "download also the initial and boundary conditions (GFS or IFS) described by profiles")
+var ifsURL = flag.String("ifs-url", "", "URL template of IFS files, with {date}, {hour}, {step}, {north}, {west}, {south} and {east} placeholders, of a server returning files cut to the domain. Needed by IFS profiles with -boundary, unless -ifs-mirror is given")
+var ifsMirror = flag.String("ifs-mirror", "", "local directory containing IFS files cut to an area covering the domain, with the layout of the IFS output directory, used instead of -ifs-url")
+var stationsFormat = flag.String("format", conversion.FormatASCII, "format of converted stations observations, ascii (WRFDA ob.ascii) or little_r (OBSPROC input)")
+var radarDir = flag.String("radar-dir", "", "directory containing radar files of profiles with a local radar source, overriding the one in the profile")
+var stationsQC = flag.Bool("qc", true, "check the quality of stations observations before conversion, converting only accepted ones and listing rejected ones in WRFDA/qc.<DATE>.json")
+var orographyFile = flag.String("orography", conversion.DefaultOrography, "netcdf file with the orography used to calculate the height of stations. When empty, heights are written as missing")
+
+func checkArguments(profiles profile.Set) {
+	args := flag.Args()
+	if len(args) < 1 {
 		usage("Missing STARTDATE argument.")
 	}
 
-	if len(os.Args) < 3 {
+	if len(args) < 2 {
 		usage("DOWNLOAD_TYPE argument required.")
 	}
 
-	_, err := time.Parse("2006010215", os.Args[1])
+	_, err := time.Parse("2006010215", args[0])
 	if err != nil {
-		usage("Invalid STARTDATE argument `%s`.", os.Args[1])
+		usage("Invalid STARTDATE argument `%s`.", args[0])
+	}
+
+	if *stationsFormat != conversion.FormatASCII && *stationsFormat != conversion.FormatLittleR {
+		usage("Invalid -format option `%s`.", *stationsFormat)
 	}
 
-	for _, downloadType := range os.Args[2:] {
-		switch downloadType {
-		case "RISICO", "CONTINUUM", "ADMS", "LIMAGRAIN", "WRFIT", "WRFITDPC", "WRFFR":
-			continue
-		default:
-			usage("Invalid DOWNLOAD_TYPE argument `%s`.", downloadType)
+	for _, downloadType := range args[1:] {
+		if _, err := profiles.Get(downloadType); err != nil {
+			usage("Invalid DOWNLOAD_TYPE argument: %s.", err)
 		}
 	}
 }
@@ -61,237 +84,277 @@ func fatalIfError(err error, msgerr string) {
 	}
 }
 
-type domainDef struct{ coord string }
-
-var franceDomain = domainDef{"38,55,-10,12"}
-var italyDomain = domainDef{"24,64,-19,48"}
-var emptyDomain webdrops.Domain
-
-// ToStruct returns a new Domain pointer
-// accordingly to the given string, that must
-// contains  MinLat,MaxLat,MinLon,MaxLon values,
-// in that sequence, separated by commas and
-// represented as floats.
-func (d domainDef) ToStruct() (webdrops.Domain, error) {
-	if d.coord == "" {
-		return webdrops.Domain{
-			MinLat: -180,
-			MinLon: -90,
-			MaxLat: 90,
-			MaxLon: 180,
-		}, nil
-	}
-	coords := strings.Split(d.coord, ",")
+// wpsDomains are the domains read from the
+// namelist.wps given with -namelist-wps, if any.
+var wpsDomains []wps.Domain
 
-	MinLat, err := strconv.ParseFloat(coords[0], 64)
-	if err != nil {
-		return emptyDomain, err
-	}
+var orography struct {
+	once      sync.Once
+	elevation obs.Elevation
+}
 
-	MaxLat, err := strconv.ParseFloat(coords[1], 64)
-	if err != nil {
-		return emptyDomain, err
-	}
+// stationsElevation returns the elevation of stations read
+// from the file given with -orography, loaded on first use.
+func stationsElevation() obs.Elevation {
+	orography.once.Do(func() {
+		if *orographyFile == "" {
+			return
+		}
+		o, err := conversion.LoadOrography(*orographyFile)
+		fatalIfError(err, "Error reading orography: %w")
+		orography.elevation = o
+	})
+	return orography.elevation
+}
 
-	MinLon, err := strconv.ParseFloat(coords[2], 64)
-	if err != nil {
-		return emptyDomain, err
+func loadProfiles() profile.Set {
+	if *profilesFile == "" {
+		return profile.Builtin()
 	}
-
-	MaxLon, err := strconv.ParseFloat(coords[3], 64)
+	profiles, err := profile.Load(*profilesFile)
 	if err != nil {
-		return emptyDomain, err
+		usage("%s", err)
 	}
-
-	return webdrops.Domain{
-		MinLat: MinLat,
-		MinLon: MinLon,
-		MaxLat: MaxLat,
-		MaxLon: MaxLon,
-	}, nil
+	return profiles
 }
 
 func main() {
+	// radar conversions run radar2wrf in child processes
+	if len(os.Args) > 1 && os.Args[1] == conversion.RadarWorkerArg {
+		if err := conversion.RunRadarWorker(os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	flag.Usage = func() { usage("") }
+	flag.Parse()
 
 	config.Init()
 
-	checkArguments()
+	profiles := loadProfiles()
+	checkArguments(profiles)
+	if *boundary {
+		checkIFSOptions(profiles)
+	}
 
-	startDateWRF, err := time.Parse("2006010215", os.Args[1])
-	fatalIfError(err, "date not valid: %w")
+	if *namelistWPS != "" {
+		var err error
+		wpsDomains, err = wps.Load(*namelistWPS)
+		fatalIfError(err, "Error reading WPS namelist: %w")
+	}
 
-	fmt.Println(startDateWRF.Format("2006010215"))
+	// SIGINT and SIGTERM cancel all in-flight downloads and conversions
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	for _, downloadType := range os.Args[2:] {
-		switch downloadType {
-		case "RISICO":
-			err = fetcher.RisicoSensorsMaps(startDateWRF)
-			fatalIfError(err, "Error fetching wunderground observations maps for RISICO: %w")
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
 
-			getConvertStationsSync(startDateWRF, italyDomain, webdrops.GroupWunderground)
-			getConvertRadarSync(startDateWRF)
-			getConvertStationsSync(startDateWRF.Add(-24*time.Hour), italyDomain, webdrops.GroupWunderground)
-			getConvertRadarSync(startDateWRF.Add(-24 * time.Hour))
-			getConvertStationsSync(startDateWRF.Add(-48*time.Hour), italyDomain, webdrops.GroupWunderground)
-			getConvertRadarSync(startDateWRF.Add(-48 * time.Hour))
+	startDateWRF, err := time.Parse("2006010215", flag.Arg(0))
+	fatalIfError(err, "date not valid: %w")
 
-			os.RemoveAll("WRFDA/SENSORS")
-			os.RemoveAll("WRFDA/RADARS")
+	fmt.Fprintln(os.Stderr, startDateWRF.Format("2006010215"))
 
-		case "CONTINUUM":
-			d, err := italyDomain.ToStruct()
-			fatalIfError(err, "Error parsing domain: %w")
+	// a single session is shared by all fetchers
+	sess := webdrops.NewSession(webdrops.DefaultSessionOptions())
+	err = sess.Login(ctx)
+	fatalIfError(err, "Error during login: %w")
 
-			err = fetcher.ContinuumSensors(startDateWRF, d)
-			fatalIfError(err, "Error fetching wunderground observations for CONTINUUM: %w")
+	for _, downloadType := range flag.Args()[1:] {
+		p, err := profiles.Get(downloadType)
+		fatalIfError(err, "%w")
+		runProfile(ctx, sess, startDateWRF, downloadType, p, profiles)
+	}
+}
 
-			getConvertStationsSync(startDateWRF, italyDomain, webdrops.GroupWunderground)
-			getConvertRadarSync(startDateWRF)
+// checkIFSOptions exits when a selected profile
+// downloads IFS files, but -ifs-url and -ifs-mirror
+// can't restrict them to the domain.
+func checkIFSOptions(profiles profile.Set) {
+	for _, downloadType := range flag.Args()[1:] {
+		p, err := profiles.Get(downloadType)
+		fatalIfError(err, "%w")
+		if p.Boundary != nil && p.Boundary.Source == profile.SourceIFS {
+			err := ifsOptions(fetcher.BoundaryOptions{}).Validate()
+			fatalIfError(err, "Error in IFS options for "+downloadType+": %w")
+		}
+	}
+}
 
-			os.RemoveAll("WRFDA/SENSORS")
-			os.RemoveAll("WRFDA/RADARS")
+func ifsOptions(opts fetcher.BoundaryOptions) fetcher.IFSOptions {
+	return fetcher.IFSOptions{
+		BoundaryOptions: opts,
+		URL:             *ifsURL,
+		Mirror:          *ifsMirror,
+	}
+}
 
-		case "WRFIT":
-			getConvertStationsSync(startDateWRF, italyDomain, webdrops.GroupWunderground)
-			getConvertRadarSync(startDateWRF)
+// runProfile downloads and converts all data described by p.
+func runProfile(ctx context.Context, sess *webdrops.Session, startDateWRF time.Time, name string, p profile.Profile, profiles profile.Set) {
+	domain, err := profiles.Domain(p.Domain)
+	fatalIfError(err, "Error parsing domain: %w")
 
-			os.RemoveAll("WRFDA/SENSORS")
-			os.RemoveAll("WRFDA/RADARS")
-		case "WRFITDPC":
-			getConvertStationsSync(startDateWRF, italyDomain, webdrops.GroupDPC)
-			getConvertRadarSync(startDateWRF)
+	// data assimilated by WRF is restricted to the outermost
+	// domain of the simulation, when known, while maps and
+	// observations for other models keep the profile one.
+	wrfDomain := domain
+	if len(wpsDomains) > 0 && (p.WRFDA != nil || *boundary && p.Boundary != nil) {
+		wrfDomain = wpsDomains[0].Bounds
+		fmt.Fprintf(os.Stderr, "Profile %s: WRFDA and boundary data use the domain of %s instead of `%s`\n", name, *namelistWPS, p.Domain)
+	}
 
-			os.RemoveAll("WRFDA/SENSORS")
-			os.RemoveAll("WRFDA/RADARS")
-		case "ADMS", "LIMAGRAIN", "WRFFR":
-			// TODO: use france domain here
-			getConvertStationsSync(startDateWRF, franceDomain, webdrops.GroupWunderground)
-			// will be provided via DDI
-			//getRadars(err, sess, startDateWRF)
+	if p.Maps != nil {
+		opts, err := p.Maps.Options(fetcher.SensorsOptions{})
+		fatalIfError(err, "Error reading maps options: %w")
 
-			os.RemoveAll("WRFDA/SENSORS")
-		}
+		err = fetcher.SensorsMaps(ctx, sess, startDateWRF, opts)
+		fatalIfError(err, "Error fetching observations maps for "+name+": %w")
 	}
-}
 
-func getConvertRadarSync(dt time.Time) {
-	var err error
-	err = fetcher.WrfdaRadars(dt)
-	fatalIfError(err, "Error convertRadar for WRFDA: %w")
+	if p.Observations != nil {
+		opts, err := p.Observations.Options(fetcher.SensorsOptions{})
+		fatalIfError(err, "Error reading observations options: %w")
 
-	// TODO: move all this stuff to a conversion module
-	instants := []time.Time{
-		dt,
-		dt.Add(-3 * time.Hour),
-		dt.Add(-6 * time.Hour),
+		err = fetcher.Observations(ctx, sess, startDateWRF, domain, opts)
+		fatalIfError(err, "Error fetching observations for "+name+": %w")
 	}
 
-	//	allDatesConverted := sync.WaitGroup{}
-	for _, dt := range instants {
-		convertRadar(dt, 1, &err)
-		convertRadar(dt, 2, &err)
-		convertRadar(dt, 3, &err)
+	if p.WRFDA != nil {
+		opts, err := p.WRFDA.Options()
+		fatalIfError(err, "Error reading WRFDA options: %w")
 
+		for _, dt := range p.WRFDA.RunDates(startDateWRF) {
+			getConvertStationsSync(ctx, sess, dt, wrfDomain, opts, p.WRFDA.QC.Options())
+			if p.WRFDA.Radar {
+				getConvertRadarSync(ctx, dt, p.WRFDA.RadarOptions(sess, opts.Cycles), p.WRFDA.LowValueFilters())
+			}
+		}
 	}
-	fatalIfError(os.RemoveAll("./dom_01"), "Error removing temp directory for domain 1")
-	fatalIfError(os.RemoveAll("./dom_02"), "Error removing temp directory for domain 2")
-	fatalIfError(os.RemoveAll("./dom_03"), "Error removing temp directory for domain 3")
 
-	fatalIfError(err, "Error convertRadar for WRFDA: %w")
+	if *boundary && p.Boundary != nil {
+		opts, err := p.BoundaryOptions()
+		fatalIfError(err, "Error reading boundary options: %w")
 
-	//	allDatesConverted.Wait()
-}
+		err = fetchBoundary(ctx, startDateWRF, *p.Boundary, wrfDomain, opts)
+		fatalIfError(err, "Error fetching boundary conditions for "+name+": %w")
+	}
 
-func copyFile(src, target string) error {
-	r, err := os.Open(src)
-	if err != nil {
-		return err
+	for _, dir := range p.Cleanup {
+		os.RemoveAll(dir)
 	}
-	defer r.Close()
+}
 
-	w, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(0644))
-	if err != nil {
-		return err
+// fetchBoundary downloads initial and boundary
+// conditions from the source of b.
+func fetchBoundary(ctx context.Context, startDateWRF time.Time, b profile.Boundary, domain webdrops.Domain, opts fetcher.BoundaryOptions) error {
+	switch b.Source {
+	case profile.SourceGFS:
+		return fetcher.GFS(ctx, startDateWRF, b.Hours, domain, fetcher.GFSOptions{BoundaryOptions: opts})
+	case profile.SourceIFS:
+		return fetcher.IFS(ctx, startDateWRF, b.Hours, domain, ifsOptions(opts))
 	}
-	defer w.Close()
+	return fmt.Errorf("unknown boundary source `%s`", b.Source)
+}
 
-	bufSrc := bufio.NewReader(r)
-	bufTarget := bufio.NewWriter(w)
+func getConvertRadarSync(ctx context.Context, dt time.Time, radarOpts fetcher.RadarOptions, filters map[string]regrid.LowValueFilter) {
+	if local, ok := radarOpts.Source.(*fetcher.LocalRadar); ok {
+		if *radarDir != "" {
+			local.Dir = *radarDir
+		}
+		// radar data are staged by another system, that
+		// may have none: stations are converted anyway.
+		empty, err := local.Empty()
+		fatalIfError(err, "Error reading radar directory: %w")
+		if empty {
+			fmt.Fprintf(os.Stderr, "No radar files in `%s`, radar conversion skipped\n", local.Dir)
+			return
+		}
+	}
 
-	_, err = io.Copy(bufTarget, bufSrc)
-	return err
-}
+	var err error
+	err = fetcher.Radars(ctx, dt, radarOpts)
+	fatalIfError(err, "Error convertRadar for WRFDA: %w")
 
-func getConvertStationsSync(dt time.Time, domain domainDef, group webdrops.SensorGroup) {
-	d, err := domain.ToStruct()
-	fatalIfError(err, "Error parsing domain: %w")
+	instants := radarOpts.Cycles.Dates(dt)
 
-	var sess webdrops.Session
-	err = sess.Login()
-	if err != nil {
-		return
+	opts := conversion.RadarOptions{
+		Vars:         radarOpts.Vars,
+		TemplatesDir: *regridTmplDir,
+		Filters:      filters,
+		Bounds:       map[int]webdrops.Domain{},
+	}
+	for _, d := range wpsDomains {
+		opts.Bounds[d.ID] = d.Bounds
 	}
 
-	d, err = domain.ToStruct()
-	fatalIfError(err, "Error parsing domain")
+	n := *jobs
+	if n == 0 {
+		n = runtime.NumCPU()
+	}
+	tasks := conversion.RadarTasks(instants, radarDomains())
+	err = conversion.ConvertRadars(ctx, tasks, n, opts)
+	fatalIfError(err, "Error convertRadar for WRFDA: %w")
+}
 
-	f := fetcher.WrfdaSensorsSession{
-		Sess:   sess,
-		Domain: d,
+// radarDomains returns the WRF domains radar data are converted
+// for: 1 to max_dom of the namelist.input given with -namelist-input,
+// all domains of the namelist.wps given with -namelist-wps, or all
+// domains with a template in the templates directory.
+func radarDomains() []int {
+	if *namelistInput != "" {
+		nl, err := namelist.Load(*namelistInput)
+		fatalIfError(err, "Error reading WRF namelist: %w")
+		domains, err := conversion.NamelistDomains(nl)
+		fatalIfError(err, "Error reading domains from WRF namelist: %w")
+		return domains
+	}
+
+	if len(wpsDomains) > 0 {
+		domains := make([]int, len(wpsDomains))
+		for i, d := range wpsDomains {
+			domains[i] = d.ID
+		}
+		return domains
 	}
-	f.FetchSensorIDs("TERMOMETRO", dt, d, group)
 
-	err = fetcher.WrfdaSensors(dt, d, group)
-	fatalIfError(err, "Error fetching wunderground observations for WRFDA: %w")
+	domains, err := conversion.TemplateDomains(*regridTmplDir)
+	fatalIfError(err, "Error reading domains templates: %w")
+	return domains
+}
+
+func getConvertStationsSync(ctx context.Context, sess *webdrops.Session, dt time.Time, domain webdrops.Domain, opts fetcher.SensorsOptions, qcOpts qc.Options) {
+	err := fetcher.WrfdaObservations(ctx, sess, dt, domain, opts)
+	fatalIfError(err, "Error fetching observations for WRFDA: %w")
+
+	instants := opts.Cycles.Dates(dt)
 
 	// qui, ricopiare il file del registry su tutte le altre date
 	// scaricate
+	err = conversion.CopyRegistries(opts.OutputDir, opts.Classes, instants)
+	fatalIfError(err, "%w")
 
-	registrySrc := "WRFDA/SENSORS/TERMOMETRO-registry.json"
-
-	dtCycle3 := dt
-	dtCycle2 := dt.Add(-3 * time.Hour)
-	dtCycle1 := dt.Add(-6 * time.Hour)
-
-	registry1 := filepath.Join(
-		"WRFDA/SENSORS",
-		dtCycle1.Format("2006010215"),
-		fmt.Sprintf("%s-registry.json", "TERMOMETRO"),
-	)
-
-	registry2 := filepath.Join(
-		"WRFDA/SENSORS",
-		dtCycle2.Format("2006010215"),
-		fmt.Sprintf("%s-registry.json", "TERMOMETRO"),
-	)
-
-	registry3 := filepath.Join(
-		"WRFDA/SENSORS",
-		dtCycle3.Format("2006010215"),
-		fmt.Sprintf("%s-registry.json", "TERMOMETRO"),
-	)
-
-	fatalIfError(copyFile(registrySrc, registry1), "unable to copy registry for cycle 1: %w")
-	fatalIfError(copyFile(registrySrc, registry2), "unable to copy registry for cycle 2: %w")
-	fatalIfError(copyFile(registrySrc, registry3), "unable to copy registry for cycle 3: %w")
-
-	instants := []time.Time{
-		dt,
-		dt.Add(-3 * time.Hour),
-		dt.Add(-6 * time.Hour),
+	stationsOpts := conversion.StationsOptions{
+		Elevation: stationsElevation(),
+		MaxOffset: opts.Window,
+		Format:    *stationsFormat,
+		Source:    opts.Group.Name(),
+	}
+	if *stationsQC {
+		stationsOpts.QC = &qcOpts
 	}
 
 	allDatesConverted := sync.WaitGroup{}
 	for _, dt := range instants {
 		allDatesConverted.Add(1)
 		go func(dt time.Time) {
-			var err error
-			convertStations(dt, domain, &err)
-			if err != nil {
-				msg := fmt.Sprintf("Error converting wunderground observations of date %s: %%w", dt.Format("200601021504"))
-				fatalIfError(err, msg)
-			}
-			allDatesConverted.Done()
+			defer allDatesConverted.Done()
+			err := conversion.ConvertStations(ctx, dt, domain, stationsOpts)
+			fatalIfError(err, "Error converting wunderground observations: %w")
 		}(dt)
 	}
 
@@ -309,151 +372,3 @@ func getConvertObs(startDateWRF time.Time, getConvertFn getConvertFnT) {
 	//fatalIfError(err, "cannot convert radar data: %w")
 }
 */
-
-func filenameForVar(dirname, varname, dt string) string {
-
-	pt := fmt.Sprintf("%s/%s-%s.nc", dirname, dt, varname)
-	return pt
-}
-
-const regridTmplDir = "~/regrid-tmpl"
-
-func remapBilinear(dir string, radarTime time.Time, varname string, domain int) error {
-	// regrid radar netcdf file
-	sourceFile := filenameForVar(dir, varname, radarTime.Format("2006010215"))
-	targetFile := fmt.Sprintf("%s_dom%02d.remapped", sourceFile, domain)
-	operator := fmt.Sprintf("remapbil,%s/wrfinput_d%02d.template", regridTmplDir, domain)
-
-	cmd := exec.Command("cdo", operator, sourceFile, targetFile)
-
-	err := cmd.Run()
-	if err != nil {
-		return fmt.Errorf(
-			"Cannot apply bilinear remapping for variable %s of radar %s:\n"+
-				"CMD: cdo %s %s %s\n"+
-				"ERR: %w\n",
-			varname, radarTime,
-			operator, sourceFile, targetFile, err,
-		)
-	}
-	return nil
-}
-
-func filterOutLowValues(dir string, radarTime time.Time, varname string, domain int) error {
-	operator := fmt.Sprintf("where(%s < 10) %s=-9999", varname, varname)
-
-	file := filenameForVar(dir, varname, radarTime.Format("2006010215"))
-	sourceFile := fmt.Sprintf("%s_dom%02d.remapped", file, domain)
-	targetFile := fmt.Sprintf("%s_dom%02d.filtered", file, domain)
-
-	cmd := exec.Command("ncap2", "-s", operator, sourceFile, targetFile)
-
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf(
-			"Cannot filter low values for variable %s of radar %s:\n"+
-				"CMD: ncap2 -s %s %s %s\n"+
-				"ERR: %w\n",
-			varname, radarTime,
-			operator, sourceFile, targetFile, err,
-		)
-	}
-
-	operator = "time=int(time)"
-	sourceFile = fmt.Sprintf("%s_dom%02d.filtered", file, domain)
-	targetFile = fmt.Sprintf("%s_dom%02d.timefixed", file, domain)
-
-	cmd = exec.Command("ncap2", "-s", operator, sourceFile, targetFile)
-
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf(
-			"Cannot change type for variable %s of radar %s to `int`:\n"+
-				"CMD: ncap2 -s %s %s %s\n"+
-				"ERR: %w\n",
-			varname, radarTime,
-			operator, sourceFile, targetFile, err,
-		)
-	}
-
-	if err := os.Remove(fmt.Sprintf("%s_dom%02d.remapped", file, domain)); err != nil {
-		return err
-	}
-
-	if err := os.Remove(fmt.Sprintf("%s_dom%02d.filtered", file, domain)); err != nil {
-		return err
-	}
-
-	domainDir := fmt.Sprintf("./dom_%02d", domain)
-	if err := os.MkdirAll(path.Dir(path.Join(domainDir, file)), 0755); err != nil {
-		return err
-	}
-
-	targetFile = path.Join(domainDir, file)
-	if err := os.Rename(fmt.Sprintf("%s_dom%02d.timefixed", file, domain), targetFile); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-var varnames = []string{"CAPPI2", "CAPPI3", "CAPPI4", "CAPPI5"}
-
-// TODO: move all this stuff to a conversion module
-func convertRadar(date time.Time, domain int, err *error) {
-	if *err != nil {
-		return
-	}
-
-	dtS := date.Format("2006010215")
-	fmt.Printf("Converting radar %s domain %d\n", dtS, domain)
-	dir := "WRFDA/RADARS/" + dtS
-
-	for _, varname := range varnames {
-		if e := remapBilinear(dir, date, varname, domain); e != nil {
-			*err = e
-			return
-		}
-		if e := filterOutLowValues(dir, date, varname, domain); e != nil {
-			*err = e
-			return
-		}
-	}
-
-	reader, e := radar.Convert(fmt.Sprintf("./dom_%02d/%s", domain, dir), "", dtS)
-	if e != nil {
-		*err = e
-		return
-	}
-	radarOutFilePath := fmt.Sprintf("WRFDA/ob.radar.%s_dom%02d", dtS, domain)
-	outfile, e := os.OpenFile(radarOutFilePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(0644))
-	if e != nil {
-		*err = e
-		return
-	}
-	defer outfile.Close()
-	outfileBuff := bufio.NewWriter(outfile)
-
-	_, *err = io.Copy(outfileBuff, reader)
-	if *err == nil {
-		outfileBuff.Flush()
-	}
-
-}
-
-// TODO: move all this stuff to a conversion module
-func convertStations(date time.Time, domain domainDef, err *error) {
-	if *err != nil {
-		return
-	}
-
-	dtS := date.Format("2006010215")
-	fmt.Printf("Converting stations %s\n", dtS)
-
-	*err = dewetra2wrf.Convert(
-		dewetra2wrf.DewetraFormat,
-		"WRFDA/SENSORS/"+dtS,
-		domain.coord,
-		date,
-		"WRFDA/ob.ascii."+dtS,
-	)
-
-}
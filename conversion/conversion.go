@@ -0,0 +1,69 @@
+// Package conversion converts radar and stations datasets
+// downloaded by the fetcher package to the ASCII formats
+// read by WRFDA (ob.radar and ob.ascii files).
+//
+// All functions return errors instead of terminating the
+// process, so the pipeline can be embedded by other tools.
+package conversion
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultDir is the directory, under cwd, where
+// fetchers save WRFDA datasets and where converted
+// files are written.
+const DefaultDir = "WRFDA"
+
+// CopyFile copies the content of src to target,
+// creating or truncating it.
+func CopyFile(src, target string) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(0644))
+	if err != nil {
+		return err
+	}
+
+	bufSrc := bufio.NewReader(r)
+	bufTarget := bufio.NewWriter(w)
+
+	_, err = io.Copy(bufTarget, bufSrc)
+	if err == nil {
+		err = bufTarget.Flush()
+	}
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// CopyRegistries copies the registry of each sensor class,
+// saved by fetcher.WrfdaObservations in dir, to the directory
+// of each cycle, where ConvertStations expects it.
+func CopyRegistries(dir string, classes []string, cycles []time.Time) error {
+	for _, class := range classes {
+		registrySrc := filepath.Join(dir, fmt.Sprintf("%s-registry.json", class))
+
+		for n, cycle := range cycles {
+			registry := filepath.Join(
+				dir,
+				cycle.Format("2006010215"),
+				fmt.Sprintf("%s-registry.json", class),
+			)
+			if err := CopyFile(registrySrc, registry); err != nil {
+				return fmt.Errorf("unable to copy %s registry for cycle %d: %w", class, n+1, err)
+			}
+		}
+	}
+	return nil
+}
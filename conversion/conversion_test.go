@@ -0,0 +1,73 @@
+package conversion
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cima-lexis/lexisdn/namelist"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopyRegistries(t *testing.T) {
+	dir := t.TempDir()
+	cycles := []time.Time{
+		time.Date(2020, 6, 9, 18, 0, 0, 0, time.UTC),
+		time.Date(2020, 6, 9, 21, 0, 0, 0, time.UTC),
+	}
+	for _, cycle := range cycles {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, cycle.Format("2006010215")), 0755))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "TERMOMETRO-registry.json"), []byte(`[]`), 0644))
+
+	err := CopyRegistries(dir, []string{"TERMOMETRO"}, cycles)
+	require.NoError(t, err)
+
+	for _, cycle := range cycles {
+		content, err := os.ReadFile(filepath.Join(dir, cycle.Format("2006010215"), "TERMOMETRO-registry.json"))
+		require.NoError(t, err)
+		assert.Equal(t, `[]`, string(content))
+	}
+
+	err = CopyRegistries(dir, []string{"IGROMETRO"}, cycles)
+	assert.Error(t, err)
+}
+
+func TestDomainTemplate(t *testing.T) {
+	home, err := os.UserHomeDir()
+	require.NoError(t, err)
+
+	path, err := DomainTemplate("~/regrid-tmpl", 2)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(home, "regrid-tmpl", "wrfinput_d02.template"), path)
+
+	path, err = DomainTemplate("/data/tmpl", 1)
+	require.NoError(t, err)
+	assert.Equal(t, "/data/tmpl/wrfinput_d01.template", path)
+}
+
+func TestTemplateDomains(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"wrfinput_d01.template", "wrfinput_d02.template", "wrfinput_d04.template", "wrfinput_d0x.template", "geo_em.d01.nc"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0644))
+	}
+
+	domains, err := TemplateDomains(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 4}, domains)
+
+	_, err = TemplateDomains(t.TempDir())
+	assert.Error(t, err)
+}
+
+func TestNamelistDomains(t *testing.T) {
+	nl, err := namelist.Parse(strings.NewReader("&domains\n max_dom = 4,\n/\n"))
+	require.NoError(t, err)
+
+	domains, err := NamelistDomains(nl)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3, 4}, domains)
+}
@@ -0,0 +1,248 @@
+package conversion
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/cima-lexis/lexisdn/regrid"
+	"github.com/fhs/go-netcdf/netcdf"
+)
+
+// netcdfMu serializes the calls to the netCDF and HDF5 libraries,
+// that are not thread-safe builds, while go-netcdf has no locking
+// of its own. It's held by every function opening a netCDF file,
+// until the file is closed. radar2wrf runs in its own processes.
+var netcdfMu sync.Mutex
+
+// radarField is a CAPPI variable read
+// from a radar netcdf file.
+type radarField struct {
+	Grid   regrid.Grid
+	Values []float32
+	Time   float64
+}
+
+// readDomainPoints reads the mass points coordinates of a WRF
+// domain from a wrfinput file (XLAT and XLONG variables) or a
+// geo_em file (XLAT_M and XLONG_M variables).
+func readDomainPoints(path string) (regrid.Points, error) {
+	netcdfMu.Lock()
+	defer netcdfMu.Unlock()
+
+	ds, err := netcdf.OpenFile(path, netcdf.NOWRITE)
+	if err != nil {
+		return regrid.Points{}, fmt.Errorf("error opening domain file `%s`: %w", path, err)
+	}
+	defer ds.Close()
+
+	lats, latDims, err := readFirstVar(ds, "XLAT", "XLAT_M")
+	if err != nil {
+		return regrid.Points{}, fmt.Errorf("error reading latitudes from `%s`: %w", path, err)
+	}
+	lons, _, err := readFirstVar(ds, "XLONG", "XLONG_M")
+	if err != nil {
+		return regrid.Points{}, fmt.Errorf("error reading longitudes from `%s`: %w", path, err)
+	}
+
+	if len(latDims) < 2 {
+		return regrid.Points{}, fmt.Errorf("latitudes in `%s` are not a 2D grid", path)
+	}
+	rows := int(latDims[len(latDims)-2])
+	cols := int(latDims[len(latDims)-1])
+
+	// only the first time step is used
+	return regrid.Points{
+		Lats: lats[:rows*cols],
+		Lons: lons[:rows*cols],
+		Rows: rows,
+		Cols: cols,
+	}, nil
+}
+
+// readRadar reads variable varname, together with
+// its grid and time, from the radar file at path.
+func readRadar(path, varname string) (radarField, error) {
+	netcdfMu.Lock()
+	defer netcdfMu.Unlock()
+
+	ds, err := netcdf.OpenFile(path, netcdf.NOWRITE)
+	if err != nil {
+		return radarField{}, fmt.Errorf("error opening radar file `%s`: %w", path, err)
+	}
+	defer ds.Close()
+
+	var field radarField
+	if field.Grid.Lats, _, err = readFirstVar(ds, "lat", "latitude"); err != nil {
+		return radarField{}, fmt.Errorf("error reading latitudes from `%s`: %w", path, err)
+	}
+	if field.Grid.Lons, _, err = readFirstVar(ds, "lon", "longitude"); err != nil {
+		return radarField{}, fmt.Errorf("error reading longitudes from `%s`: %w", path, err)
+	}
+
+	times, _, err := readFirstVar(ds, "time")
+	if err != nil {
+		return radarField{}, fmt.Errorf("error reading time from `%s`: %w", path, err)
+	}
+	field.Time = times[0]
+
+	values, _, err := readFirstVar(ds, varname)
+	if err != nil {
+		return radarField{}, fmt.Errorf("error reading variable %s from `%s`: %w", varname, path, err)
+	}
+	field.Values = make([]float32, len(values))
+	for i, v := range values {
+		field.Values[i] = float32(v)
+	}
+
+	// only the first time step is used
+	size := len(field.Grid.Lats) * len(field.Grid.Lons)
+	if len(field.Values) < size {
+		return radarField{}, fmt.Errorf("variable %s in `%s` has %d values, expected %d", varname, path, len(field.Values), size)
+	}
+	field.Values = field.Values[:size]
+
+	return field, nil
+}
+
+// writeRadar writes variable varname, defined on the
+// points of a WRF domain, to a new netcdf file at path.
+// The file has the same layout produced by `cdo remapbil`
+// when the target grid is a wrfinput file, with time
+// stored as an int as expected by radar2wrf.
+func writeRadar(path, varname string, points regrid.Points, time int32, values []float32, missing float32) error {
+	netcdfMu.Lock()
+	defer netcdfMu.Unlock()
+
+	ds, err := netcdf.CreateFile(path, netcdf.CLOBBER|netcdf.NETCDF4)
+	if err != nil {
+		return fmt.Errorf("error creating `%s`: %w", path, err)
+	}
+
+	err = writeRadarVars(ds, varname, points, time, values, missing)
+	if closeErr := ds.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("error writing `%s`: %w", path, err)
+	}
+	return nil
+}
+
+func writeRadarVars(ds netcdf.Dataset, varname string, points regrid.Points, time int32, values []float32, missing float32) error {
+	timeDim, err := ds.AddDim("time", 1)
+	if err != nil {
+		return err
+	}
+	rowsDim, err := ds.AddDim("south_north", uint64(points.Rows))
+	if err != nil {
+		return err
+	}
+	colsDim, err := ds.AddDim("west_east", uint64(points.Cols))
+	if err != nil {
+		return err
+	}
+
+	timeVar, err := ds.AddVar("time", netcdf.INT, []netcdf.Dim{timeDim})
+	if err != nil {
+		return err
+	}
+	latVar, err := ds.AddVar("XLAT", netcdf.DOUBLE, []netcdf.Dim{rowsDim, colsDim})
+	if err != nil {
+		return err
+	}
+	lonVar, err := ds.AddVar("XLONG", netcdf.DOUBLE, []netcdf.Dim{rowsDim, colsDim})
+	if err != nil {
+		return err
+	}
+	valuesVar, err := ds.AddVar(varname, netcdf.FLOAT, []netcdf.Dim{timeDim, rowsDim, colsDim})
+	if err != nil {
+		return err
+	}
+	if err := valuesVar.Attr("_FillValue").WriteFloat32s([]float32{missing}); err != nil {
+		return err
+	}
+	if err := valuesVar.Attr("coordinates").WriteBytes([]byte("XLONG XLAT")); err != nil {
+		return err
+	}
+
+	if err := ds.EndDef(); err != nil {
+		return err
+	}
+
+	if err := timeVar.WriteInt32s([]int32{time}); err != nil {
+		return err
+	}
+	if err := latVar.WriteFloat64s(points.Lats); err != nil {
+		return err
+	}
+	if err := lonVar.WriteFloat64s(points.Lons); err != nil {
+		return err
+	}
+	return valuesVar.WriteFloat32s(values)
+}
+
+// readFirstVar reads the first variable found among
+// names, returning its values and dimensions lengths.
+func readFirstVar(ds netcdf.Dataset, names ...string) ([]float64, []uint64, error) {
+	var v netcdf.Var
+	var err error
+	for _, name := range names {
+		if v, err = ds.Var(name); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dims, err := v.Dims()
+	if err != nil {
+		return nil, nil, err
+	}
+	lens := make([]uint64, len(dims))
+	for i, dim := range dims {
+		if lens[i], err = dim.Len(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	values, err := readValues(v)
+	if err != nil {
+		return nil, nil, err
+	}
+	return values, lens, nil
+}
+
+// readValues reads all values of a FLOAT,
+// DOUBLE or INT variable as float64.
+func readValues(v netcdf.Var) ([]float64, error) {
+	n, err := v.Len()
+	if err != nil {
+		return nil, err
+	}
+	t, err := v.Type()
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]float64, n)
+	switch t {
+	case netcdf.DOUBLE:
+		err = v.ReadFloat64s(values)
+	case netcdf.FLOAT:
+		data := make([]float32, n)
+		err = v.ReadFloat32s(data)
+		for i, d := range data {
+			values[i] = float64(d)
+		}
+	case netcdf.INT:
+		data := make([]int32, n)
+		err = v.ReadInt32s(data)
+		for i, d := range data {
+			values[i] = float64(d)
+		}
+	default:
+		err = fmt.Errorf("unsupported variable type %d", t)
+	}
+	return values, err
+}
@@ -0,0 +1,81 @@
+package conversion
+
+import (
+	"fmt"
+
+	"github.com/cima-lexis/lexisdn/regrid"
+	"github.com/fhs/go-netcdf/netcdf"
+)
+
+// DefaultOrography is the default path of the
+// orography file used to calculate stations heights.
+const DefaultOrography = "~/.dewetra2wrf/orog.nc"
+
+// orographyMissing marks points of the
+// orography without a valid height.
+const orographyMissing = -9999
+
+// Orography is an obs.Elevation that bilinearly interpolates
+// terrain heights defined on a latitude/longitude grid.
+type Orography struct {
+	heights *regrid.Sampler
+}
+
+// NewOrography returns an Orography with heights, in
+// meters, defined on grid and stored row-major.
+func NewOrography(grid regrid.Grid, heights []float32) (*Orography, error) {
+	s, err := regrid.NewSampler(grid, heights, orographyMissing)
+	if err != nil {
+		return nil, err
+	}
+	return &Orography{heights: s}, nil
+}
+
+// LoadOrography reads an orography from the netcdf file at
+// path, with lat/latitude and lon/longitude coordinates and
+// heights in the first variable found among orog, z and HGT.
+// A leading ~ in path is expanded to the user home directory.
+func LoadOrography(path string) (*Orography, error) {
+	path, err := expandHome(path)
+	if err != nil {
+		return nil, err
+	}
+
+	netcdfMu.Lock()
+	defer netcdfMu.Unlock()
+
+	ds, err := netcdf.OpenFile(path, netcdf.NOWRITE)
+	if err != nil {
+		return nil, fmt.Errorf("error opening orography file `%s`: %w", path, err)
+	}
+	defer ds.Close()
+
+	var grid regrid.Grid
+	if grid.Lats, _, err = readFirstVar(ds, "lat", "latitude"); err != nil {
+		return nil, fmt.Errorf("error reading latitudes from `%s`: %w", path, err)
+	}
+	if grid.Lons, _, err = readFirstVar(ds, "lon", "longitude"); err != nil {
+		return nil, fmt.Errorf("error reading longitudes from `%s`: %w", path, err)
+	}
+	values, _, err := readFirstVar(ds, "orog", "z", "HGT")
+	if err != nil {
+		return nil, fmt.Errorf("error reading heights from `%s`: %w", path, err)
+	}
+
+	heights := make([]float32, len(values))
+	for i, v := range values {
+		heights[i] = float32(v)
+	}
+	o, err := NewOrography(grid, heights)
+	if err != nil {
+		return nil, fmt.Errorf("invalid orography file `%s`: %w", path, err)
+	}
+	return o, nil
+}
+
+// Elevation implements obs.Elevation. Heights of points
+// outside of the orography grid are unknown.
+func (o *Orography) Elevation(lat, lon float64) (float64, bool) {
+	h, ok := o.heights.At(lat, lon)
+	return float64(h), ok
+}
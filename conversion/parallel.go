@@ -0,0 +1,93 @@
+package conversion
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RadarTask is the conversion of the
+// radar of a cycle for a WRF domain.
+type RadarTask struct {
+	Cycle  time.Time
+	Domain int
+}
+
+func (task RadarTask) String() string {
+	return fmt.Sprintf("radar %s domain %d", task.Cycle.Format("2006010215"), task.Domain)
+}
+
+// RadarTasks returns a task for every
+// combination of cycles and domains.
+func RadarTasks(cycles []time.Time, domains []int) []RadarTask {
+	tasks := make([]RadarTask, 0, len(cycles)*len(domains))
+	for _, cycle := range cycles {
+		for _, domain := range domains {
+			tasks = append(tasks, RadarTask{Cycle: cycle, Domain: domain})
+		}
+	}
+	return tasks
+}
+
+// ConvertRadars runs ConvertRadar for all tasks, with at
+// most jobs conversions running concurrently. The first
+// error cancels all other conversions and is returned.
+func ConvertRadars(ctx context.Context, tasks []RadarTask, jobs int, opts RadarOptions) error {
+	return runTasks(ctx, tasks, jobs, func(ctx context.Context, task RadarTask) error {
+		return ConvertRadar(ctx, task.Cycle, task.Domain, opts)
+	})
+}
+
+// runTasks calls convert for every task using
+// a pool of jobs workers. The first error cancels
+// the context passed to all other calls.
+func runTasks(ctx context.Context, tasks []RadarTask, jobs int, convert func(context.Context, RadarTask) error) error {
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	// the first error cancels all other conversions
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	queue := make(chan RadarTask)
+	errs := make(chan error, len(tasks))
+	workers := sync.WaitGroup{}
+
+	for i := 0; i < jobs; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for task := range queue {
+				if ctx.Err() != nil {
+					// already canceled, skip remaining tasks
+					continue
+				}
+				if err := convert(ctx, task); err != nil {
+					errs <- fmt.Errorf("error converting %s: %w", task, err)
+					cancel()
+				}
+			}
+		}()
+	}
+
+enqueue:
+	for _, task := range tasks {
+		select {
+		case queue <- task:
+		case <-ctx.Done():
+			break enqueue
+		}
+	}
+	close(queue)
+	workers.Wait()
+
+	var err error
+	select {
+	case err = <-errs:
+	default:
+		err = ctx.Err()
+	}
+	return err
+}
@@ -0,0 +1,197 @@
+package conversion
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cima-lexis/lexisdn/regrid"
+	"github.com/cima-lexis/lexisdn/webdrops"
+	"github.com/fhs/go-netcdf/netcdf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var start = time.Date(2020, 6, 10, 0, 0, 0, 0, time.UTC)
+
+func TestRadarTasks(t *testing.T) {
+	tasks := RadarTasks([]time.Time{start, start.Add(-3 * time.Hour)}, []int{1, 2})
+	assert.Equal(t, []RadarTask{
+		{Cycle: start, Domain: 1},
+		{Cycle: start, Domain: 2},
+		{Cycle: start.Add(-3 * time.Hour), Domain: 1},
+		{Cycle: start.Add(-3 * time.Hour), Domain: 2},
+	}, tasks)
+}
+
+func TestRunTasksBoundsConcurrency(t *testing.T) {
+	tasks := RadarTasks([]time.Time{start, start.Add(-3 * time.Hour), start.Add(-6 * time.Hour)}, []int{1, 2, 3})
+
+	var running, maxRunning int32
+	done := map[RadarTask]bool{}
+	var mu sync.Mutex
+
+	err := runTasks(context.Background(), tasks, 2, func(ctx context.Context, task RadarTask) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		done[task] = true
+		mu.Unlock()
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.Len(t, done, 9)
+	assert.Equal(t, int32(2), maxRunning)
+}
+
+func TestRunTasksFirstErrorCancels(t *testing.T) {
+	tasks := RadarTasks([]time.Time{start, start.Add(-3 * time.Hour), start.Add(-6 * time.Hour)}, []int{1, 2, 3})
+	failure := errors.New("no template")
+
+	var started int32
+	err := runTasks(context.Background(), tasks, 3, func(ctx context.Context, task RadarTask) error {
+		atomic.AddInt32(&started, 1)
+		if task.Domain == 2 {
+			return failure
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	assert.ErrorIs(t, err, failure)
+	assert.Contains(t, err.Error(), "domain 2")
+	assert.Less(t, int(started), len(tasks))
+}
+
+// writeRadarFixture writes a radar file with variable
+// varname defined on grid, as downloaded from webdrops.
+func writeRadarFixture(t *testing.T, path, varname string, grid regrid.Grid, values []float32) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	ds, err := netcdf.CreateFile(path, netcdf.CLOBBER|netcdf.NETCDF4)
+	require.NoError(t, err)
+	defer ds.Close()
+
+	timeDim, err := ds.AddDim("time", 1)
+	require.NoError(t, err)
+	latDim, err := ds.AddDim("lat", uint64(len(grid.Lats)))
+	require.NoError(t, err)
+	lonDim, err := ds.AddDim("lon", uint64(len(grid.Lons)))
+	require.NoError(t, err)
+	timeVar, err := ds.AddVar("time", netcdf.DOUBLE, []netcdf.Dim{timeDim})
+	require.NoError(t, err)
+	latVar, err := ds.AddVar("lat", netcdf.DOUBLE, []netcdf.Dim{latDim})
+	require.NoError(t, err)
+	lonVar, err := ds.AddVar("lon", netcdf.DOUBLE, []netcdf.Dim{lonDim})
+	require.NoError(t, err)
+	valuesVar, err := ds.AddVar(varname, netcdf.FLOAT, []netcdf.Dim{timeDim, latDim, lonDim})
+	require.NoError(t, err)
+	require.NoError(t, ds.EndDef())
+
+	require.NoError(t, timeVar.WriteFloat64s([]float64{float64(start.Unix())}))
+	require.NoError(t, latVar.WriteFloat64s(grid.Lats))
+	require.NoError(t, lonVar.WriteFloat64s(grid.Lons))
+	require.NoError(t, valuesVar.WriteFloat32s(values))
+}
+
+// TestConvertRadarsConcurrently runs many conversions at once,
+// to be run with -race: radar2wrf conversions must overlap.
+func TestConvertRadarsConcurrently(t *testing.T) {
+	dir := t.TempDir()
+	templates := t.TempDir()
+	vars := []string{"CAPPI2", "CAPPI3"}
+
+	grid := regrid.Grid{Lats: []float64{43, 44, 45, 46}, Lons: []float64{7, 8, 9, 10}}
+	values := make([]float32, 16)
+	for i := range values {
+		values[i] = float32(10 + i)
+	}
+	cycles := []time.Time{start, start.Add(-3 * time.Hour), start.Add(-6 * time.Hour)}
+	for i, cycle := range cycles {
+		dtS := cycle.Format("2006010215")
+		instants := webdrops.RadarInstants{}
+		for j, varname := range vars {
+			// CAPPI3 of the first cycle is missing,
+			// and must be converted as missing
+			if i == 0 && j == 1 {
+				continue
+			}
+			writeRadarFixture(t, filepath.Join(dir, "RADARS", dtS, dtS+"-"+varname+".nc"), varname, grid, values)
+			instants[varname] = cycle
+		}
+		manifest := webdrops.NewRadarManifest(cycle, vars, webdrops.RadarSelection{}.OrDefault(), instants)
+		require.NoError(t, webdrops.WriteRadarManifest(dir, manifest))
+	}
+	for domain := 1; domain <= 3; domain++ {
+		points := regrid.Points{
+			Rows: 2, Cols: 2,
+			Lats: []float64{44, 44, 44.5, 44.5},
+			Lons: []float64{8, 8.5, 8, 8.5},
+		}
+		path := filepath.Join(templates, fmt.Sprintf("wrfinput_d%02d.template", domain))
+		require.NoError(t, writeRadar(path, "HGT", points, 0, make([]float32, 4), -9999))
+	}
+
+	var converting, overlapped int32
+	convert := radarConvert
+	radarConvert = func(ctx context.Context, worker []string, dir, date string, w io.Writer) error {
+		n := atomic.AddInt32(&converting, 1)
+		defer atomic.AddInt32(&converting, -1)
+		// the first conversions wait for another one to start
+		deadline := time.Now().Add(5 * time.Second)
+		for ; n < 2 && atomic.LoadInt32(&overlapped) == 0 && time.Now().Before(deadline); n = atomic.LoadInt32(&converting) {
+			time.Sleep(time.Millisecond)
+		}
+		if n > 1 {
+			atomic.StoreInt32(&overlapped, 1)
+		}
+
+		files, err := filepath.Glob(filepath.Join(dir, "*.nc"))
+		if err != nil {
+			return err
+		}
+		names := []string{}
+		for _, file := range files {
+			names = append(names, filepath.Base(file))
+		}
+		sort.Strings(names)
+		_, err = io.WriteString(w, strings.Join(names, " "))
+		return err
+	}
+	defer func() { radarConvert = convert }()
+
+	tasks := RadarTasks(cycles, []int{1, 2, 3})
+	err := ConvertRadars(context.Background(), tasks, 4, RadarOptions{
+		Dir:          dir,
+		TemplatesDir: templates,
+		WorkDir:      t.TempDir(),
+		Vars:         vars,
+	})
+	require.NoError(t, err)
+
+	for _, task := range tasks {
+		dtS := task.Cycle.Format("2006010215")
+		content, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("ob.radar.%s_dom%02d", dtS, task.Domain)))
+		require.NoError(t, err)
+		// levels not converted are written as missing
+		assert.Equal(t, dtS+"-CAPPI2.nc "+dtS+"-CAPPI3.nc "+dtS+"-CAPPI4.nc "+dtS+"-CAPPI5.nc", string(content))
+	}
+	assert.EqualValues(t, 1, overlapped, "radar2wrf conversions never overlapped")
+}
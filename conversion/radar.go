@@ -0,0 +1,329 @@
+package conversion
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cima-lexis/lexisdn/namelist"
+	"github.com/cima-lexis/lexisdn/regrid"
+	"github.com/cima-lexis/lexisdn/webdrops"
+)
+
+// DefaultRadarVars are the CAPPI levels converted by
+// default, and all the ones radar2wrf reads.
+var DefaultRadarVars = []string{"CAPPI2", "CAPPI3", "CAPPI4", "CAPPI5"}
+
+// DefaultTemplatesDir is the default directory
+// containing the wrfinput_dXX.template files.
+const DefaultTemplatesDir = "~/regrid-tmpl"
+
+// RadarOptions configures ConvertRadar.
+type RadarOptions struct {
+	// Dir is the directory containing the RADARS
+	// directory, and where ob.radar files are written.
+	// When empty, DefaultDir is used.
+	Dir string
+	// TemplatesDir is the directory containing the
+	// wrfinput_dXX.template files with the grid of each
+	// domain. A leading ~ is expanded to the user home
+	// directory. When empty, DefaultTemplatesDir is used.
+	TemplatesDir string
+	// WorkDir is the directory where every conversion
+	// creates its own temporary directory, to save the
+	// regridded files before converting them. Temporary
+	// directories are removed when conversions end.
+	// When empty, cwd is used.
+	WorkDir string
+	// Vars are the CAPPI levels to convert, a subset of
+	// DefaultRadarVars. When empty, DefaultRadarVars are used.
+	Vars []string
+	// Filters are the low value filters applied to each
+	// CAPPI level. Levels not listed use regrid.DefaultLowValueFilter.
+	Filters map[string]regrid.LowValueFilter
+	// Bounds are the lat/lon bounding boxes of WRF domains,
+	// indexed by domain number, as calculated by wps.Load.
+	// When set, radar data are cropped to the bounds of the
+	// domain before being regridded.
+	Bounds map[int]webdrops.Domain
+	// Worker is the command running radar2wrf on the regridded
+	// files of a conversion, with their directory and date
+	// appended, that writes the ob.radar content to stdout.
+	// When empty, the current executable is run with
+	// RadarWorkerArg.
+	Worker []string
+}
+
+func (opts RadarOptions) withDefaults() RadarOptions {
+	if opts.Dir == "" {
+		opts.Dir = DefaultDir
+	}
+	if opts.TemplatesDir == "" {
+		opts.TemplatesDir = DefaultTemplatesDir
+	}
+	if opts.WorkDir == "" {
+		opts.WorkDir = "."
+	}
+	if len(opts.Vars) == 0 {
+		opts.Vars = DefaultRadarVars
+	}
+	return opts
+}
+
+// filter returns the low value filter of varname.
+func (opts RadarOptions) filter(varname string) regrid.LowValueFilter {
+	if filter, ok := opts.Filters[varname]; ok {
+		return filter
+	}
+	return regrid.DefaultLowValueFilter
+}
+
+// ConvertRadar regrids the radar CAPPI of cycle, read from
+// <Dir>/RADARS/<CYCLE>, onto the grid of domain, and converts
+// them to the WRFDA ob.radar file <Dir>/ob.radar.<CYCLE>_domXX.
+// The levels available are read from the manifest of the cycle,
+// <Dir>/ob.radar.<CYCLE>.manifest.json: the ones missing, or not
+// in opts.Vars, are converted with all values missing.
+func ConvertRadar(ctx context.Context, cycle time.Time, domain int, opts RadarOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	opts = opts.withDefaults()
+
+	dtS := cycle.Format("2006010215")
+	fmt.Fprintf(os.Stderr, "Converting radar %s domain %d\n", dtS, domain)
+	dir := filepath.Join(opts.Dir, "RADARS", dtS)
+
+	// every conversion uses its own directory,
+	// so that many of them can run concurrently.
+	domainDir, err := os.MkdirTemp(opts.WorkDir, fmt.Sprintf(".radar-%s-dom%02d-", dtS, domain))
+	if err != nil {
+		return fmt.Errorf("cannot create work directory: %w", err)
+	}
+	defer os.RemoveAll(domainDir)
+
+	manifest, err := webdrops.ReadRadarManifest(opts.Dir, cycle)
+	if err != nil {
+		return err
+	}
+	for _, varname := range opts.Vars {
+		if !contains(DefaultRadarVars, varname) {
+			return fmt.Errorf("unknown radar variable `%s`", varname)
+		}
+	}
+	var available, missing []string
+	for _, varname := range DefaultRadarVars {
+		if contains(opts.Vars, varname) && manifest.Has(varname) {
+			available = append(available, varname)
+		} else {
+			missing = append(missing, varname)
+		}
+	}
+	if len(available) == 0 {
+		return fmt.Errorf("radar %s has none of the variables %s", dtS, strings.Join(opts.Vars, ", "))
+	}
+
+	var radarTime int32
+	for _, varname := range available {
+		if radarTime, err = regridRadar(ctx, dir, domainDir, cycle, varname, domain, opts); err != nil {
+			return err
+		}
+	}
+	// radar2wrf reads all levels: the ones not converted, or left
+	// out by radar selection policies accepting partial sets, are
+	// written with all values missing, as the points masked by
+	// low value filters.
+	for _, varname := range missing {
+		fmt.Fprintf(os.Stderr, "Radar %s has no variable %s, written as missing\n", dtS, varname)
+		if err := missingRadar(dir, domainDir, cycle, varname, domain, radarTime, opts); err != nil {
+			return err
+		}
+	}
+
+	radarOutFilePath := filepath.Join(opts.Dir, fmt.Sprintf("ob.radar.%s_dom%02d", dtS, domain))
+	outfile, err := os.OpenFile(radarOutFilePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(0644))
+	if err != nil {
+		return err
+	}
+	defer outfile.Close()
+	outfileBuff := bufio.NewWriter(outfile)
+
+	if err := radarConvert(ctx, opts.Worker, filepath.Join(domainDir, dir), dtS, outfileBuff); err != nil {
+		return fmt.Errorf("cannot convert radar %s for domain %d: %w", dtS, domain, err)
+	}
+	if err := outfileBuff.Flush(); err != nil {
+		return fmt.Errorf("cannot write `%s`: %w", radarOutFilePath, err)
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func filenameForVar(dirname, varname, dt string) string {
+	return filepath.Join(dirname, fmt.Sprintf("%s-%s.nc", dt, varname))
+}
+
+// DomainTemplate returns the path of the wrfinput (or geo_em)
+// file in dir containing the grid of domain, expanding a
+// leading ~ in dir to the user home directory.
+func DomainTemplate(dir string, domain int) (string, error) {
+	dir, err := expandHome(dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, fmt.Sprintf("wrfinput_d%02d.template", domain)), nil
+}
+
+// TemplateDomains returns, sorted, the numbers of all
+// domains having a wrfinput_dXX.template file in dir.
+func TemplateDomains(dir string) ([]int, error) {
+	dir, err := expandHome(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "wrfinput_d*.template"))
+	if err != nil {
+		return nil, err
+	}
+
+	var domains []int
+	for _, file := range files {
+		var domain int
+		name := filepath.Base(file)
+		if _, err := fmt.Sscanf(name, "wrfinput_d%d.template", &domain); err != nil || domain < 1 {
+			continue
+		}
+		domains = append(domains, domain)
+	}
+	if len(domains) == 0 {
+		return nil, fmt.Errorf("no wrfinput_dXX.template files found in `%s`", dir)
+	}
+
+	sort.Ints(domains)
+	return domains, nil
+}
+
+// NamelistDomains returns the numbers of all domains
+// of a WRF namelist.input, from 1 to max_dom.
+func NamelistDomains(nl namelist.Namelist) ([]int, error) {
+	maxDom, err := nl.Int("domains", "max_dom")
+	if err != nil {
+		return nil, err
+	}
+	if maxDom < 1 {
+		return nil, fmt.Errorf("invalid max_dom %d", maxDom)
+	}
+
+	domains := make([]int, maxDom)
+	for i := range domains {
+		domains[i] = i + 1
+	}
+	return domains, nil
+}
+
+func expandHome(dir string) (string, error) {
+	if dir == "~" || strings.HasPrefix(dir, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("cannot expand `%s`: %w", dir, err)
+		}
+		dir = filepath.Join(home, dir[1:])
+	}
+	return dir, nil
+}
+
+// regridRadar interpolates variable varname of the radar in dir on
+// the grid of domain, masks values lower than the filter threshold,
+// and saves the result under <domainDir>/<dir>, with time cast to int.
+// It returns the time saved.
+func regridRadar(ctx context.Context, dir, domainDir string, radarTime time.Time, varname string, domain int, opts RadarOptions) (int32, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	filter := opts.filter(varname)
+
+	sourceFile := filenameForVar(dir, varname, radarTime.Format("2006010215"))
+	targetFile := filepath.Join(domainDir, sourceFile)
+
+	template, err := DomainTemplate(opts.TemplatesDir, domain)
+	if err != nil {
+		return 0, err
+	}
+
+	points, err := readDomainPoints(template)
+	if err != nil {
+		return 0, fmt.Errorf("cannot read grid of domain %d: %w", domain, err)
+	}
+
+	field, err := readRadar(sourceFile, varname)
+	if err != nil {
+		return 0, fmt.Errorf("cannot read variable %s of radar %s: %w", varname, radarTime, err)
+	}
+
+	if bounds, ok := opts.Bounds[domain]; ok {
+		field.Grid, field.Values, err = field.Grid.Crop(field.Values, bounds.MinLat, bounds.MaxLat, bounds.MinLon, bounds.MaxLon)
+		if err != nil {
+			return 0, fmt.Errorf("cannot crop variable %s of radar %s: %w", varname, radarTime, err)
+		}
+	}
+
+	remap, err := regrid.NewBilinear(field.Grid, points)
+	if err != nil {
+		return 0, fmt.Errorf("cannot apply bilinear remapping for variable %s of radar %s: %w", varname, radarTime, err)
+	}
+
+	values, err := remap.Apply(field.Values, filter.Missing)
+	if err != nil {
+		return 0, fmt.Errorf("cannot apply bilinear remapping for variable %s of radar %s: %w", varname, radarTime, err)
+	}
+
+	filter.Apply(values)
+
+	if err := os.MkdirAll(filepath.Dir(targetFile), 0755); err != nil {
+		return 0, err
+	}
+
+	return int32(field.Time), writeRadar(targetFile, varname, points, int32(field.Time), values, filter.Missing)
+}
+
+// missingRadar saves under <domainDir>/<dir> variable varname
+// on the grid of domain, with all values missing.
+func missingRadar(dir, domainDir string, radarTime time.Time, varname string, domain int, fieldTime int32, opts RadarOptions) error {
+	filter := opts.filter(varname)
+	targetFile := filepath.Join(domainDir, filenameForVar(dir, varname, radarTime.Format("2006010215")))
+
+	template, err := DomainTemplate(opts.TemplatesDir, domain)
+	if err != nil {
+		return err
+	}
+
+	points, err := readDomainPoints(template)
+	if err != nil {
+		return fmt.Errorf("cannot read grid of domain %d: %w", domain, err)
+	}
+
+	values := make([]float32, len(points.Lats))
+	for i := range values {
+		values[i] = filter.Missing
+	}
+
+	if err := os.MkdirAll(filepath.Dir(targetFile), 0755); err != nil {
+		return err
+	}
+
+	return writeRadar(targetFile, varname, points, fieldTime, values, filter.Missing)
+}
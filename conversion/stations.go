@@ -0,0 +1,154 @@
+package conversion
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cima-lexis/lexisdn/obs"
+	"github.com/cima-lexis/lexisdn/qc"
+	"github.com/cima-lexis/lexisdn/webdrops"
+)
+
+// DefaultMaxOffset is the default maximum distance
+// from a cycle of observations converted for it.
+const DefaultMaxOffset = 5 * time.Minute
+
+// Output formats of ConvertStations.
+const (
+	// FormatASCII is the WRFDA ASCII format produced by
+	// OBSPROC, written to <Dir>/ob.ascii.<CYCLE>.
+	FormatASCII = "ascii"
+	// FormatLittleR is the LITTLE_R format read by
+	// OBSPROC, written to <Dir>/little_r.<CYCLE>.
+	FormatLittleR = "little_r"
+)
+
+// StationsOptions configures ConvertStations.
+type StationsOptions struct {
+	// Dir is the directory containing the SENSORS
+	// directory, and where ob.ascii files are written.
+	// When empty, DefaultDir is used.
+	Dir string
+	// Elevation is used to calculate the height of
+	// stations. When nil, heights are written as missing.
+	Elevation obs.Elevation
+	// MaxOffset is the maximum distance from the cycle of
+	// the observations converted. The observation nearest
+	// to the cycle is used. When zero, DefaultMaxOffset is used.
+	MaxOffset time.Duration
+	// Format is the output format, either FormatASCII
+	// or FormatLittleR. When empty, FormatASCII is used.
+	Format string
+	// Source is the source of observations written
+	// in LITTLE_R reports. When empty, obs.DefaultSource
+	// is used.
+	Source string
+	// QC, when set, are the options of the quality control
+	// of observations: only the accepted ones are converted,
+	// and the rejected ones are listed in <Dir>/qc.<CYCLE>.json.
+	QC *qc.Options
+}
+
+// ConvertStations converts the observations of stations inside
+// domain for cycle, read from <Dir>/SENSORS/<CYCLE>, to the
+// WRFDA ob.ascii file <Dir>/ob.ascii.<CYCLE>, or to the LITTLE_R
+// file <Dir>/little_r.<CYCLE> when opts.Format is FormatLittleR.
+// Observations of all the sensor classes found in the directory
+// are read with obs.LoadClasses, checked with qc.Run when opts.QC
+// is set, and combined per station with obs.Combine.
+func ConvertStations(ctx context.Context, cycle time.Time, domain webdrops.Domain, opts StationsOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	dir := opts.Dir
+	if dir == "" {
+		dir = DefaultDir
+	}
+	maxOffset := opts.MaxOffset
+	if maxOffset == 0 {
+		maxOffset = DefaultMaxOffset
+	}
+
+	var write func(w io.Writer, stations []obs.Surface) error
+	var name string
+	switch opts.Format {
+	case "", FormatASCII:
+		name = "ob.ascii."
+		write = func(w io.Writer, stations []obs.Surface) error {
+			return obs.WriteASCII(w, stations, obs.ASCIIOptions{Elevation: opts.Elevation})
+		}
+	case FormatLittleR:
+		name = "little_r."
+		write = func(w io.Writer, stations []obs.Surface) error {
+			return obs.WriteLittleR(w, stations, obs.LittleROptions{Elevation: opts.Elevation, Source: opts.Source})
+		}
+	default:
+		return fmt.Errorf("unknown stations format `%s`", opts.Format)
+	}
+
+	dtS := cycle.Format("2006010215")
+	fmt.Fprintf(os.Stderr, "Converting stations %s\n", dtS)
+
+	data, err := obs.LoadClasses(filepath.Join(dir, "SENSORS", dtS))
+	if err != nil {
+		return fmt.Errorf("error reading observations of date %s: %w", cycle.Format("200601021504"), err)
+	}
+
+	if opts.QC != nil {
+		var report qc.Report
+		data, report = qc.Run(cycle, maxOffset, data, *opts.QC)
+		fmt.Fprintf(os.Stderr, "Quality control of stations %s rejected %d observations\n", dtS, report.Rejected())
+		if err := writeReport(filepath.Join(dir, "qc."+dtS+".json"), report); err != nil {
+			return fmt.Errorf("error writing quality control report of date %s: %w", cycle.Format("200601021504"), err)
+		}
+	}
+
+	stations := obs.Combine(cycle, maxOffset, data)
+
+	inDomain := make([]obs.Surface, 0, len(stations))
+	for _, s := range stations {
+		if domain.Contains(s.Lat, s.Lon) {
+			inDomain = append(inDomain, s)
+		}
+	}
+
+	err = writeStations(filepath.Join(dir, name+dtS), inDomain, write)
+	if err != nil {
+		return fmt.Errorf("error converting observations of date %s: %w", cycle.Format("200601021504"), err)
+	}
+	return nil
+}
+
+// writeStations writes stations to a new file at path.
+func writeStations(path string, stations []obs.Surface, write func(w io.Writer, stations []obs.Surface) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	err = write(w, stations)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// writeReport writes report, in JSON format, to path.
+func writeReport(path string, report qc.Report) error {
+	content, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, os.FileMode(0644))
+}
@@ -0,0 +1,145 @@
+package conversion
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cima-lexis/lexisdn/obs"
+	"github.com/cima-lexis/lexisdn/qc"
+	"github.com/cima-lexis/lexisdn/regrid"
+	"github.com/cima-lexis/lexisdn/webdrops"
+	"github.com/cima-lexis/lexisdn/webdrops/webdropstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// copyFixtures copies registries and observations of
+// all obs.Classes from the fixtures to sensorsDir.
+func copyFixtures(t *testing.T, sensorsDir string) {
+	require.NoError(t, os.MkdirAll(sensorsDir, 0755))
+	for _, class := range obs.Classes {
+		for src, target := range map[string]string{
+			"sensors/list/" + class + ".json": class + "-registry.json",
+			"sensors/data/" + class + ".json": class + ".json",
+		} {
+			content, err := fs.ReadFile(webdropstest.Fixtures(), src)
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(filepath.Join(sensorsDir, target), content, 0644))
+		}
+	}
+}
+
+func TestConvertStations(t *testing.T) {
+	dir := t.TempDir()
+	cycle := time.Date(2020, 6, 9, 21, 0, 0, 0, time.UTC)
+	copyFixtures(t, filepath.Join(dir, "SENSORS", "2020060921"))
+
+	italy := webdrops.Domain{MinLat: 24, MaxLat: 64, MinLon: -19, MaxLon: 48}
+	err := ConvertStations(context.Background(), cycle, italy, StationsOptions{
+		Dir:       dir,
+		Elevation: obs.ConstantElevation(100),
+	})
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(filepath.Join(dir, "ob.ascii.2020060921"))
+	require.NoError(t, err)
+	ascii := string(content)
+	assert.Contains(t, ascii, "TOTAL =      3,")
+	assert.Contains(t, ascii, "Nice Cimiez")
+	// outside of the domain
+	assert.NotContains(t, ascii, "Brooklyn Heights")
+	assert.Equal(t, 3, strings.Count(ascii, "     100.000   0   7.00"))
+}
+
+func TestConvertStationsQC(t *testing.T) {
+	dir := t.TempDir()
+	cycle := time.Date(2020, 6, 9, 21, 0, 0, 0, time.UTC)
+	copyFixtures(t, filepath.Join(dir, "SENSORS", "2020060921"))
+
+	italy := webdrops.Domain{MinLat: 24, MaxLat: 64, MinLon: -19, MaxLon: 48}
+	// temperatures at Nice Cimiez fall out of range
+	limits := qc.DefaultLimits[obs.ClassTemperature]
+	limits.Max = 19.5
+	err := ConvertStations(context.Background(), cycle, italy, StationsOptions{
+		Dir:       dir,
+		Elevation: obs.ConstantElevation(100),
+		QC:        &qc.Options{Limits: map[string]qc.Limits{obs.ClassTemperature: limits}},
+	})
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(filepath.Join(dir, "ob.ascii.2020060921"))
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "Nice Cimiez")
+	assert.NotContains(t, string(content), "292.850")
+
+	content, err = os.ReadFile(filepath.Join(dir, "qc.2020060921.json"))
+	require.NoError(t, err)
+	var report qc.Report
+	require.NoError(t, json.Unmarshal(content, &report))
+	// observations of other cycles in the fixtures are flagged too
+	var flags []qc.Flag
+	for _, station := range report.Stations {
+		if station.Name == "Nice Cimiez" {
+			flags = station.Flags
+		}
+	}
+	assert.Contains(t, flags, qc.Flag{
+		Check:    qc.CheckRange,
+		Class:    obs.ClassTemperature,
+		SensorID: "7272_2",
+		Time:     cycle,
+		Value:    19.7,
+	})
+}
+
+func TestConvertStationsLittleR(t *testing.T) {
+	dir := t.TempDir()
+	cycle := time.Date(2020, 6, 9, 21, 0, 0, 0, time.UTC)
+	copyFixtures(t, filepath.Join(dir, "SENSORS", "2020060921"))
+
+	italy := webdrops.Domain{MinLat: 24, MaxLat: 64, MinLon: -19, MaxLon: 48}
+	err := ConvertStations(context.Background(), cycle, italy, StationsOptions{
+		Dir:    dir,
+		Format: FormatLittleR,
+		Source: "DPC",
+	})
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(filepath.Join(dir, "little_r.2020060921"))
+	require.NoError(t, err)
+	// 4 records for each of the 3 stations in domain
+	assert.Equal(t, 12, strings.Count(string(content), "\n"))
+	assert.Contains(t, string(content), "DPC")
+	assert.NoFileExists(t, filepath.Join(dir, "ob.ascii.2020060921"))
+
+	err = ConvertStations(context.Background(), cycle, italy, StationsOptions{Dir: dir, Format: "bufr"})
+	assert.Error(t, err)
+}
+
+func TestOrography(t *testing.T) {
+	grid := regrid.Grid{Lats: []float64{44, 45}, Lons: []float64{8, 9, 10}}
+	o, err := NewOrography(grid, []float32{
+		0, 100, 200,
+		100, 200, orographyMissing,
+	})
+	require.NoError(t, err)
+
+	h, ok := o.Elevation(44.5, 8.5)
+	assert.True(t, ok)
+	assert.InDelta(t, 100, h, 1e-4)
+
+	_, ok = o.Elevation(44.5, 9.5)
+	assert.False(t, ok)
+
+	_, ok = o.Elevation(46, 8.5)
+	assert.False(t, ok)
+
+	_, err = NewOrography(grid, []float32{1, 2})
+	assert.Error(t, err)
+}
@@ -0,0 +1,66 @@
+package conversion
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/meteocima/radar2wrf/radar"
+)
+
+// RadarWorkerArg is the first argument of the processes
+// started by ConvertRadar to run radar2wrf: programs
+// calling ConvertRadar without RadarOptions.Worker must
+// call RunRadarWorker when started with it.
+const RadarWorkerArg = "-radar2wrf-worker"
+
+// RunRadarWorker converts with radar2wrf the regridded CAPPI files
+// in dir, taken at date, writing the ob.radar content to w. args
+// are the arguments following RadarWorkerArg: dir and date.
+func RunRadarWorker(args []string, w io.Writer) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: %s DIR DATE", RadarWorkerArg)
+	}
+	reader, err := radar.Convert(args[0], "", args[1])
+	if err != nil {
+		return err
+	}
+	out := bufio.NewWriter(w)
+	if _, err := io.Copy(out, reader); err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+// radarConvert converts the regridded CAPPI files in dir, taken
+// at date, writing the ob.radar content to w. radar2wrf reads them
+// through the netCDF libraries, that are not thread-safe, so that
+// every conversion runs it in its own process. It's a variable so
+// that tests can replace it.
+var radarConvert = func(ctx context.Context, worker []string, dir, date string, w io.Writer) error {
+	if len(worker) == 0 {
+		exe, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		worker = []string{exe, RadarWorkerArg}
+	}
+
+	args := append(append([]string{}, worker[1:]...), dir, date)
+	cmd := exec.CommandContext(ctx, worker[0], args...)
+	cmd.Stdout = w
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package conversion
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRadarConvertWorker(t *testing.T) {
+	var out bytes.Buffer
+	worker := []string{"sh", "-c", `printf "%s %s" "$1" "$2"`, "worker"}
+	err := radarConvert(context.Background(), worker, "dir", "2020061000", &out)
+	require.NoError(t, err)
+	assert.Equal(t, "dir 2020061000", out.String())
+
+	worker = []string{"sh", "-c", `echo "no CAPPI found" >&2; exit 1`, "worker"}
+	err = radarConvert(context.Background(), worker, "dir", "2020061000", &out)
+	assert.EqualError(t, err, "exit status 1: no CAPPI found")
+
+	err = RunRadarWorker([]string{"dir"}, &out)
+	assert.EqualError(t, err, "usage: -radar2wrf-worker DIR DATE")
+}
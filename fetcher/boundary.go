@@ -0,0 +1,334 @@
+package fetcher
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cima-lexis/lexisdn/schedule"
+	"github.com/cima-lexis/lexisdn/webdrops"
+)
+
+// BoundaryFile is a file of a forecast model run,
+// used as initial or boundary conditions by WPS.
+type BoundaryFile struct {
+	// Run is the reference time of the model run.
+	Run time.Time
+	// Step is the forecast hour of the file, from Run.
+	Step int
+}
+
+// Time returns the instant the file is valid at.
+func (f BoundaryFile) Time() time.Time {
+	return f.Run.Add(time.Duration(f.Step) * time.Hour)
+}
+
+func (f BoundaryFile) String() string {
+	return fmt.Sprintf("%s+%03d", f.Run.Format("2006010215"), f.Step)
+}
+
+// BoundarySource is a forecast model providing initial
+// and boundary conditions, as GFS or IFS.
+type BoundarySource interface {
+	// Name is the name of the model.
+	Name() string
+	// RunInterval is the time between two runs of the model.
+	RunInterval() time.Duration
+	// StepInterval is the time between two
+	// consecutive forecast steps of a run.
+	StepInterval() time.Duration
+	// Path returns the path, relative to the output
+	// directory, where file is saved.
+	Path(file BoundaryFile) string
+	// Download saves file, restricted to domain, to path.
+	// The download of a partial file left by a previous
+	// call should be resumed, when possible.
+	Download(ctx context.Context, file BoundaryFile, domain webdrops.Domain, path string) error
+}
+
+// BoundaryOptions describes which files of
+// a BoundarySource are needed by a run.
+type BoundaryOptions struct {
+	// Interval is the time between two boundary conditions,
+	// a multiple of the step interval of the source. When
+	// zero, the step interval of the source is used.
+	Interval time.Duration
+	// Cycles, when set, are the WRFDA assimilation cycles
+	// of the run, that also need initial conditions.
+	Cycles schedule.Cycles
+	// WarmupDays is the number of 24 hours runs, ending
+	// at the start of the simulation, that are needed to
+	// warm up a model, as Risico.
+	WarmupDays int
+	// OutputDir is the directory, under cwd, where files
+	// are saved. When empty, the name of the source is used.
+	OutputDir string
+}
+
+// BoundaryFiles returns the files of src needed by a simulation
+// of hours starting at start, as described by opts. All files
+// valid from the oldest assimilation cycle to the end of the
+// simulation are taken from the latest model run available at
+// that cycle: being D the start of the run, with the default
+// cycles these are D-6H, D-3H and D to D+hours. Files for warm
+// up runs, each 24 hours long, are taken from distinct model runs
+// starting at D-24H*WarmupDays, and follow the main ones.
+func BoundaryFiles(src BoundarySource, start time.Time, hours int, opts BoundaryOptions) ([]BoundaryFile, error) {
+	step := src.StepInterval()
+	interval := opts.Interval
+	if interval == 0 {
+		interval = step
+	}
+	if interval < step || interval%step != 0 {
+		return nil, fmt.Errorf("invalid interval %s, must be a multiple of %s", interval, step)
+	}
+	if start.Sub(start.Truncate(step)) != 0 {
+		return nil, fmt.Errorf("start date %s is not a %s forecast step", start.Format("2006010215"), src.Name())
+	}
+	if hours < 0 {
+		return nil, fmt.Errorf("invalid simulation length %d hours", hours)
+	}
+
+	runOf := func(t time.Time) time.Time {
+		return t.Truncate(src.RunInterval())
+	}
+
+	times := []time.Time{}
+	if opts.Cycles != (schedule.Cycles{}) {
+		if err := opts.Cycles.Validate(); err != nil {
+			return nil, err
+		}
+		for _, cycle := range opts.Cycles.Dates(start) {
+			if cycle.Before(start) {
+				times = append(times, cycle)
+			}
+		}
+	}
+	end := start.Add(time.Duration(hours) * time.Hour)
+	for t := start; !t.After(end); t = t.Add(interval) {
+		times = append(times, t)
+	}
+
+	run := runOf(times[0])
+	files := make([]BoundaryFile, 0, len(times))
+	for _, t := range times {
+		if t.Sub(run)%step != 0 {
+			return nil, fmt.Errorf("%s has no forecast step at %s", src.Name(), t.Format("2006010215"))
+		}
+		files = append(files, BoundaryFile{Run: run, Step: int(t.Sub(run) / time.Hour)})
+	}
+
+	for day := opts.WarmupDays; day > 0; day-- {
+		warmupStart := start.Add(-time.Duration(day) * 24 * time.Hour)
+		warmupRun := runOf(warmupStart)
+		for t := warmupStart; !t.After(warmupStart.Add(24 * time.Hour)); t = t.Add(interval) {
+			files = append(files, BoundaryFile{Run: warmupRun, Step: int(t.Sub(warmupRun) / time.Hour)})
+		}
+	}
+
+	return files, nil
+}
+
+// Boundary downloads from src all files needed by a simulation of
+// hours starting at start, as returned by BoundaryFiles, saving them
+// under opts.OutputDir. Files already downloaded and valid are not
+// downloaded again, and every downloaded file is checked to be a
+// complete GRIB file.
+func Boundary(ctx context.Context, src BoundarySource, start time.Time, hours int, domain webdrops.Domain, opts BoundaryOptions) error {
+	files, err := BoundaryFiles(src, start, hours, opts)
+	if err != nil {
+		return err
+	}
+
+	outputDir := opts.OutputDir
+	if outputDir == "" {
+		outputDir = src.Name()
+	}
+
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		path := filepath.Join(outputDir, src.Path(file))
+		if verifyGRIB(path) == nil {
+			fmt.Fprintf(os.Stderr, "%s %s already downloaded\n", src.Name(), file)
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), os.FileMode(0755)); err != nil {
+			return fmt.Errorf("error creating directory for %s %s: %w", src.Name(), file, err)
+		}
+
+		fmt.Fprintf(os.Stderr, "Downloading %s %s\n", src.Name(), file)
+		if err := src.Download(ctx, file, domain, path); err != nil {
+			return fmt.Errorf("error downloading %s %s: %w", src.Name(), file, err)
+		}
+		if err := verifyGRIB(path); err != nil {
+			os.Remove(path)
+			return fmt.Errorf("error downloading %s %s: %w", src.Name(), file, err)
+		}
+		fmt.Fprintf(os.Stderr, "Saved %s %s to %s\n", src.Name(), file, path)
+	}
+
+	return nil
+}
+
+// verifyGRIB checks that the file at path contains
+// complete GRIB messages: it must start with `GRIB`
+// and end with `7777`.
+func verifyGRIB(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < 8 {
+		return fmt.Errorf("`%s` is not a GRIB file: too short", path)
+	}
+
+	head := make([]byte, 4)
+	tail := make([]byte, 4)
+	if _, err := f.ReadAt(head, 0); err != nil {
+		return err
+	}
+	if _, err := f.ReadAt(tail, info.Size()-4); err != nil {
+		return err
+	}
+	if !bytes.Equal(head, []byte("GRIB")) {
+		return fmt.Errorf("`%s` is not a GRIB file", path)
+	}
+	if !bytes.Equal(tail, []byte("7777")) {
+		return fmt.Errorf("`%s` is truncated", path)
+	}
+	return nil
+}
+
+// resumableDownload downloads url to path using client. Content
+// is written to <path>.part, and the download restarts from its
+// end using a Range request, both when left there by a previous
+// call and after a failed attempt. The file is renamed to path
+// once complete. Up to attempts are made, wait apart.
+func resumableDownload(ctx context.Context, client *http.Client, url, path string, attempts int, wait time.Duration) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	partPath := path + ".part"
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			fmt.Fprintf(os.Stderr, "Attempt %d failed: %s. Resuming download of %s\n", attempt-1, err, url)
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		var retry bool
+		retry, err = downloadPart(ctx, client, url, partPath)
+		if err == nil {
+			return os.Rename(partPath, path)
+		}
+		if !retry || ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// downloadPart appends to the file at partPath the content
+// of url following the bytes already saved, and checks that the
+// size of the result matches the one declared by the server.
+// retry is true if the error is temporary.
+func downloadPart(ctx context.Context, client *http.Client, url, partPath string) (retry bool, err error) {
+	var offset int64
+	if info, err := os.Stat(partPath); err == nil {
+		offset = info.Size()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return false, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer res.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	total := int64(-1)
+	switch res.StatusCode {
+	case http.StatusOK:
+		// the server ignored the range: restart from scratch
+		flags |= os.O_TRUNC
+		offset = 0
+		total = res.ContentLength
+	case http.StatusPartialContent:
+		flags |= os.O_APPEND
+		total = contentRangeTotal(res.Header.Get("Content-Range"))
+	case http.StatusRequestedRangeNotSatisfiable:
+		// the part is already complete, or corrupted
+		if total = contentRangeTotal(res.Header.Get("Content-Range")); total == offset {
+			return false, nil
+		}
+		os.Remove(partPath)
+		return true, fmt.Errorf("partial file is larger than the remote one")
+	default:
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		temporary := res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests
+		return temporary, fmt.Errorf("HTTP status: %s\nResponse Body:\n%s", res.Status, body)
+	}
+
+	f, err := os.OpenFile(partPath, flags, os.FileMode(0644))
+	if err != nil {
+		return false, err
+	}
+	n, err := io.Copy(f, res.Body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return true, fmt.Errorf("download interrupted after %d bytes: %w", offset+n, err)
+	}
+
+	if total >= 0 && offset+n != total {
+		return true, fmt.Errorf("downloaded %d bytes, expected %d", offset+n, total)
+	}
+	return false, nil
+}
+
+// contentRangeTotal returns the total size declared by
+// a Content-Range header, or -1 if it's unknown.
+func contentRangeTotal(header string) int64 {
+	slash := strings.LastIndex(header, "/")
+	if slash < 0 {
+		return -1
+	}
+	total, err := strconv.ParseInt(header[slash+1:], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return total
+}
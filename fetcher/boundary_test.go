@@ -0,0 +1,311 @@
+package fetcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cima-lexis/lexisdn/schedule"
+	"github.com/cima-lexis/lexisdn/webdrops"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gribServer is a stand-in for the NOMADS filter script,
+// serving a fake GRIB file for every request.
+type gribServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []*http.Request
+	// interrupt, when true, makes the first
+	// response stop in the middle of the file.
+	interrupt bool
+	// content, when set, is served instead of the GRIB file.
+	content []byte
+}
+
+var fakeGRIB = append(append([]byte("GRIB"), bytes.Repeat([]byte{42}, 1000)...), []byte("7777")...)
+
+func newGribServer(t *testing.T) *gribServer {
+	srv := &gribServer{}
+	srv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		srv.mu.Lock()
+		srv.requests = append(srv.requests, r)
+		interrupt := srv.interrupt
+		srv.interrupt = false
+		content := srv.content
+		srv.mu.Unlock()
+
+		if content != nil {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write(content)
+			return
+		}
+		if interrupt {
+			// the declared length is not reached,
+			// so the connection is closed.
+			w.Header().Set("Content-Length", "1008")
+			w.Write(fakeGRIB[:300])
+			return
+		}
+		http.ServeContent(w, r, "grib", time.Time{}, bytes.NewReader(fakeGRIB))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func (srv *gribServer) Requests() []*http.Request {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return append([]*http.Request{}, srv.requests...)
+}
+
+func (srv *gribServer) Options() GFSOptions {
+	return GFSOptions{
+		URL:       srv.URL + "/cgi-bin/filter_gfs_0p25.pl",
+		Client:    srv.Client(),
+		RetryWait: time.Millisecond,
+	}
+}
+
+func chdirTemp(t *testing.T) {
+	oldWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	t.Cleanup(func() { os.Chdir(oldWd) })
+}
+
+func fileNames(files []BoundaryFile) []string {
+	names := make([]string, len(files))
+	for i, f := range files {
+		names[i] = f.String()
+	}
+	return names
+}
+
+func TestBoundaryFiles(t *testing.T) {
+	gfs := NewGFSSource(GFSOptions{})
+
+	files, err := BoundaryFiles(gfs, simulStartDate, 6, BoundaryOptions{Interval: 3 * time.Hour})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2020061000+000", "2020061000+003", "2020061000+006"}, fileNames(files))
+
+	// with assimilation, the run at D-6H is used
+	files, err = BoundaryFiles(gfs, simulStartDate, 24, BoundaryOptions{Cycles: schedule.DefaultCycles})
+	require.NoError(t, err)
+	require.Len(t, files, 27)
+	assert.Equal(t, []string{"2020060918+000", "2020060918+003", "2020060918+006", "2020060918+007"}, fileNames(files[:4]))
+	assert.Equal(t, "2020060918+030", files[26].String())
+	assert.Equal(t, simulStartDate.Add(24*time.Hour), files[26].Time())
+
+	// risico warm up needs two more 24 hours runs
+	start := time.Date(2020, 7, 15, 0, 0, 0, 0, time.UTC)
+	files, err = BoundaryFiles(gfs, start, 48, BoundaryOptions{WarmupDays: 2, Interval: 24 * time.Hour})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"2020071500+000", "2020071500+024", "2020071500+048",
+		"2020071300+000", "2020071300+024",
+		"2020071400+000", "2020071400+024",
+	}, fileNames(files))
+
+	_, err = BoundaryFiles(gfs, start, 48, BoundaryOptions{Interval: 90 * time.Minute})
+	assert.Error(t, err)
+}
+
+func TestGFS(t *testing.T) {
+	chdirTemp(t)
+	srv := newGribServer(t)
+
+	opts := srv.Options()
+	opts.Interval = 3 * time.Hour
+	opts.Cycles = schedule.DefaultCycles
+	err := GFS(context.Background(), simulStartDate, 6, italyDomain, opts)
+	require.NoError(t, err)
+
+	for _, step := range []string{"000", "003", "006", "009", "012"} {
+		path := filepath.Join("GFS/2020060918", "gfs.t18z.pgrb2.0p25.f"+step)
+		assertFileEqual(t, fakeGRIB, path)
+		assert.NoFileExists(t, path+".part")
+	}
+
+	requests := srv.Requests()
+	require.Len(t, requests, 5)
+	query := requests[1].URL.Query()
+	assert.Equal(t, "/cgi-bin/filter_gfs_0p25.pl", requests[1].URL.Path)
+	assert.Equal(t, "gfs.t18z.pgrb2.0p25.f003", query.Get("file"))
+	assert.Equal(t, "/gfs.20200609/18", query.Get("dir"))
+	assert.Equal(t, "on", query.Get("all_lev"))
+	assert.Equal(t, "on", query.Get("all_var"))
+	assert.Equal(t, "-19", query.Get("leftlon"))
+	assert.Equal(t, "48", query.Get("rightlon"))
+	assert.Equal(t, "64", query.Get("toplat"))
+	assert.Equal(t, "24", query.Get("bottomlat"))
+
+	// files already downloaded are skipped
+	err = GFS(context.Background(), simulStartDate, 6, italyDomain, opts)
+	require.NoError(t, err)
+	assert.Len(t, srv.Requests(), 5)
+}
+
+func TestGFSResume(t *testing.T) {
+	chdirTemp(t)
+	srv := newGribServer(t)
+	srv.interrupt = true
+
+	err := GFS(context.Background(), simulStartDate, 0, italyDomain, srv.Options())
+	require.NoError(t, err)
+	assertFileEqual(t, fakeGRIB, "GFS/2020061000/gfs.t00z.pgrb2.0p25.f000")
+
+	requests := srv.Requests()
+	require.Len(t, requests, 2)
+	assert.Equal(t, "", requests[0].Header.Get("Range"))
+	assert.Equal(t, "bytes=300-", requests[1].Header.Get("Range"))
+}
+
+func TestGFSResumesPartialFiles(t *testing.T) {
+	chdirTemp(t)
+	srv := newGribServer(t)
+
+	require.NoError(t, os.MkdirAll("GFS/2020061000", 0755))
+	require.NoError(t, os.WriteFile("GFS/2020061000/gfs.t00z.pgrb2.0p25.f000.part", fakeGRIB[:500], 0644))
+
+	err := GFS(context.Background(), simulStartDate, 0, italyDomain, srv.Options())
+	require.NoError(t, err)
+	assertFileEqual(t, fakeGRIB, "GFS/2020061000/gfs.t00z.pgrb2.0p25.f000")
+	assert.Equal(t, "bytes=500-", srv.Requests()[0].Header.Get("Range"))
+}
+
+func TestGFSVerification(t *testing.T) {
+	chdirTemp(t)
+	srv := newGribServer(t)
+	srv.content = []byte("<html>data not available</html>")
+
+	err := GFS(context.Background(), simulStartDate, 0, italyDomain, srv.Options())
+	assert.Error(t, err)
+	assert.NoFileExists(t, "GFS/2020061000/gfs.t00z.pgrb2.0p25.f000")
+}
+
+var franceDomain = webdrops.Domain{MinLat: 38, MaxLat: 55, MinLon: -10, MaxLon: 12}
+
+func TestIFSFiles(t *testing.T) {
+	ifs := NewIFSSource(IFSOptions{})
+
+	// the D-6H and D-3H cycles come from the run at 12 UTC
+	files, err := BoundaryFiles(ifs, simulStartDate, 6, BoundaryOptions{Cycles: schedule.DefaultCycles})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2020060912+006", "2020060912+009", "2020060912+012", "2020060912+015", "2020060912+018"}, fileNames(files))
+
+	_, err = BoundaryFiles(ifs, simulStartDate, 6, BoundaryOptions{Interval: time.Hour})
+	assert.Error(t, err)
+
+	hourly := schedule.Cycles{Count: 3, Interval: time.Hour}
+	_, err = BoundaryFiles(ifs, simulStartDate, 6, BoundaryOptions{Cycles: hourly})
+	assert.Error(t, err)
+
+	_, err = BoundaryFiles(ifs, simulStartDate.Add(time.Hour), 6, BoundaryOptions{})
+	assert.Error(t, err)
+}
+
+func TestIFS(t *testing.T) {
+	chdirTemp(t)
+	srv := newGribServer(t)
+
+	err := IFS(context.Background(), simulStartDate, 3, franceDomain, IFSOptions{
+		URL:    srv.URL + "/ifs/{date}/{hour}/{step}?area={north}/{west}/{south}/{east}",
+		Client: srv.Client(),
+	})
+	require.NoError(t, err)
+
+	assertFileEqual(t, fakeGRIB, "IFS/2020061000/ifs.t00z.0p25.f000.grib2")
+	assertFileEqual(t, fakeGRIB, "IFS/2020061000/ifs.t00z.0p25.f003.grib2")
+
+	requests := srv.Requests()
+	require.Len(t, requests, 2)
+	assert.Equal(t, "/ifs/20200610/00/3", requests[1].URL.Path)
+	assert.Equal(t, "55/-10/38/12", requests[1].URL.Query().Get("area"))
+}
+
+// gribArea returns a GRIB2 message with a regular lat/lon
+// grid from north-west to south-east, in degrees.
+func gribArea(north, west, south, east float64) []byte {
+	microdegrees := func(v float64) uint32 {
+		if v < 0 {
+			return uint32(math.Round(-v*1e6)) | 0x80000000
+		}
+		return uint32(math.Round(v * 1e6))
+	}
+
+	section3 := make([]byte, 72)
+	binary.BigEndian.PutUint32(section3, 72)
+	section3[4] = 3
+	binary.BigEndian.PutUint32(section3[46:], microdegrees(north))
+	binary.BigEndian.PutUint32(section3[50:], microdegrees(west))
+	binary.BigEndian.PutUint32(section3[55:], microdegrees(south))
+	binary.BigEndian.PutUint32(section3[59:], microdegrees(east))
+
+	message := make([]byte, 16)
+	copy(message, "GRIB\x00\x00\x00\x02")
+	binary.BigEndian.PutUint64(message[8:], uint64(16+len(section3)+4))
+	message = append(message, section3...)
+	return append(message, "7777"...)
+}
+
+func TestIFSMirror(t *testing.T) {
+	mirror := t.TempDir()
+	chdirTemp(t)
+
+	france := gribArea(56, 350, 37, 13)
+	require.NoError(t, os.MkdirAll(filepath.Join(mirror, "2020061000"), 0755))
+	for _, step := range []string{"000", "003"} {
+		require.NoError(t, os.WriteFile(filepath.Join(mirror, "2020061000", "ifs.t00z.0p25.f"+step+".grib2"), france, 0644))
+	}
+
+	opts := IFSOptions{Mirror: mirror}
+	opts.OutputDir = "BOUNDARY"
+	err := IFS(context.Background(), simulStartDate, 3, franceDomain, opts)
+	require.NoError(t, err)
+	assertFileEqual(t, france, "BOUNDARY/2020061000/ifs.t00z.0p25.f003.grib2")
+
+	// a file missing from the mirror
+	err = IFS(context.Background(), simulStartDate, 6, franceDomain, opts)
+	assert.Error(t, err)
+	assert.NoFileExists(t, "BOUNDARY/2020061000/ifs.t00z.0p25.f006.grib2")
+
+	for name, content := range map[string][]byte{
+		"global":      gribArea(90, 0, -90, 359.75),
+		"not covered": gribArea(56, 0, 37, 13),
+		"no grid":     fakeGRIB,
+	} {
+		path := filepath.Join(mirror, "2020061000", "ifs.t00z.0p25.f006.grib2")
+		require.NoError(t, os.WriteFile(path, content, 0644))
+		err = IFS(context.Background(), simulStartDate, 6, franceDomain, opts)
+		assert.Error(t, err, name)
+		assert.NoFileExists(t, "BOUNDARY/2020061000/ifs.t00z.0p25.f006.grib2", name)
+	}
+}
+
+func TestIFSURL(t *testing.T) {
+	for url, valid := range map[string]bool{
+		"":                                 false,
+		"https://ifs/{date}{hour}-{step}h": false,
+		"https://ifs/{step}?area={north}/{west}/{south}":        false,
+		"https://ifs/{step}?area={north}/{west}/{south}/{east}": true,
+	} {
+		err := IFSOptions{URL: url}.Validate()
+		assert.Equal(t, valid, err == nil, url)
+	}
+	assert.NoError(t, IFSOptions{Mirror: "mirror"}.Validate())
+
+	chdirTemp(t)
+	err := IFS(context.Background(), simulStartDate, 3, franceDomain, IFSOptions{URL: "https://ifs/{step}"})
+	assert.Error(t, err)
+	assert.NoDirExists(t, "IFS")
+}
@@ -1,6 +1,7 @@
 package fetcher
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -24,35 +25,42 @@ import (
 // observations are all that from time D-60H to D. Observations are
 // aggregated on a hourly basis.
 //
+// Only observations of sensors inside domain are downloaded.
+//
 // Observations are saved, under cwd, on directory CONTINUUM/SENSORS/
 // with name <SENSORCLASS>.json
-func ContinuumSensors(simulStartDate time.Time, domain webdrops.Domain) error {
-	sess := webdrops.Session{}
-	err := sess.Login()
-	if err != nil {
-		return nil
-	}
+func ContinuumSensors(ctx context.Context, sess *webdrops.Session, simulStartDate time.Time, domain webdrops.Domain) error {
+	return Observations(ctx, sess, simulStartDate, domain, ContinuumOptions)
+}
+
+// Observations retrieves observations of all sensors of
+// opts.Classes inside domain, from opts.Window before
+// simulStartDate up to it, and saves them, together with
+// the sensors registries, under opts.OutputDir.
+func Observations(ctx context.Context, sess *webdrops.Session, simulStartDate time.Time, domain webdrops.Domain, opts SensorsOptions) error {
 	fetcher := continuumSession{
+		ctx:    ctx,
 		sess:   sess,
 		domain: domain,
+		opts:   opts,
 	}
 
-	from := simulStartDate.Add(-60 * time.Hour)
+	from := simulStartDate.Add(-opts.Window)
 	to := simulStartDate
 
-	fetcher.fetchSensor("RADIOMETRO", from, to, false)
-	fetcher.fetchSensor("IGROMETRO", from, to, false)
-	fetcher.fetchSensor("TERMOMETRO", from, to, false)
-	fetcher.fetchSensor("ANEMOMETRO", from, to, false)
-	fetcher.fetchSensor("PLUVIOMETRO", from, to, false)
+	for _, class := range opts.Classes {
+		fetcher.fetchSensor(class, from, to, false)
+	}
 
 	return fetcher.sessError
 }
 
 type continuumSession struct {
+	ctx       context.Context
 	sessError error
-	sess      webdrops.Session
+	sess      *webdrops.Session
 	domain    webdrops.Domain
+	opts      SensorsOptions
 }
 
 func (fetcher *continuumSession) fetchSensor(class string, from, to time.Time, log bool) {
@@ -60,7 +68,7 @@ func (fetcher *continuumSession) fetchSensor(class string, from, to time.Time, l
 		return
 	}
 	fmt.Fprintf(os.Stderr, "Downloading sensors registry for %s\n", class)
-	sensorRegistry, err := fetcher.sess.SensorsList(class, webdrops.GroupDPC)
+	sensorRegistry, err := fetcher.sess.SensorsList(fetcher.ctx, class, fetcher.opts.Group)
 	if err != nil {
 		fetcher.sessError = fmt.Errorf("error fetching sensors list: %w", err)
 		return
@@ -74,34 +82,28 @@ func (fetcher *continuumSession) fetchSensor(class string, from, to time.Time, l
 	fmt.Fprintf(os.Stderr, "Found %d sensors\n", len(ids))
 
 	if len(ids) > 0 {
-		fmt.Fprintf(os.Stderr, "Downloading observations for %s from %s to %s\n", class, from.Format("02/01/2006 15"), to.Format("02/01/2006 15"))
-		observations, err := fetcher.sess.SensorsData(class, from, to, 3600, webdrops.GroupDPC)
-		if err != nil {
-			fetcher.sessError = fmt.Errorf("error fetching sensors data: %w", err)
-			return
-		}
-
 		jsonFilePath := filepath.Join(
-			"CONTINUUM/SENSORS",
+			fetcher.opts.OutputDir,
 			fmt.Sprintf("%s.json", class),
 		)
 
-		err = os.MkdirAll(filepath.Dir(jsonFilePath), os.FileMode(0755))
+		fmt.Fprintf(os.Stderr, "Downloading observations for %s from %s to %s\n", class, from.Format("02/01/2006 15"), to.Format("02/01/2006 15"))
+		download, err := fetcher.sess.SensorsData(fetcher.ctx, class, ids, from, to, fetcher.opts.Aggregation, fetcher.opts.Group, jsonFilePath)
 		if err != nil {
-			fetcher.sessError = fmt.Errorf("error creating directory `%s`: %w", filepath.Dir(jsonFilePath), err)
+			fetcher.sessError = fmt.Errorf("error fetching sensors data: %w", err)
 			return
 		}
-
-		fmt.Fprintf(os.Stderr, "Saving observations to %s\n", jsonFilePath)
-		err = ioutil.WriteFile(jsonFilePath, observations, os.FileMode(0644))
-		if err != nil {
-			fetcher.sessError = fmt.Errorf("error saving sensors data to `%s`: %w", jsonFilePath, err)
-		}
+		fmt.Fprintf(os.Stderr, "Saved observations to %s\n", download)
 	}
 	jsonAnagFilePath := filepath.Join(
-		"CONTINUUM/SENSORS",
+		fetcher.opts.OutputDir,
 		fmt.Sprintf("%s-registry.json", class),
 	)
+	err = os.MkdirAll(filepath.Dir(jsonAnagFilePath), os.FileMode(0755))
+	if err != nil {
+		fetcher.sessError = fmt.Errorf("error creating directory `%s`: %w", filepath.Dir(jsonAnagFilePath), err)
+		return
+	}
 	err = ioutil.WriteFile(jsonAnagFilePath, sensorRegistry, os.FileMode(0644))
 	if err != nil {
 		fetcher.sessError = fmt.Errorf("error saving sensors registry data to `%s`: %w", jsonAnagFilePath, err)
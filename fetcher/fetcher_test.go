@@ -0,0 +1,309 @@
+package fetcher
+
+import (
+	"context"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cima-lexis/lexisdn/schedule"
+	"github.com/cima-lexis/lexisdn/webdrops"
+	"github.com/cima-lexis/lexisdn/webdrops/webdropstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var simulStartDate = time.Date(2020, 6, 10, 0, 0, 0, 0, time.UTC)
+
+var italyDomain = webdrops.Domain{
+	MinLat: 24,
+	MaxLat: 64,
+	MinLon: -19,
+	MaxLon: 48,
+}
+
+// setup starts a fake webdrops server, logs in a
+// session on it and changes the current directory
+// to a new temporary one.
+func setup(t *testing.T) (*webdropstest.Server, *webdrops.Session) {
+	srv := webdropstest.NewServer(nil)
+	sess := webdrops.NewSession(srv.SessionOptions())
+	require.NoError(t, sess.Login(context.Background()))
+
+	oldWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(t.TempDir()))
+
+	t.Cleanup(func() {
+		os.Chdir(oldWd)
+		srv.Close()
+	})
+
+	return srv, sess
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	content, err := fs.ReadFile(webdropstest.Fixtures(), name)
+	require.NoError(t, err)
+	return content
+}
+
+func assertFileEqual(t *testing.T, expected []byte, path string) {
+	actual, err := ioutil.ReadFile(path)
+	if assert.NoError(t, err) {
+		assert.Equal(t, string(expected), string(actual), path)
+	}
+}
+
+// sensorsInItaly are the IDs of fixture sensors inside italyDomain
+var sensorsInItaly = []string{"-1937152789_2", "-1937157087_2", "-1937156901_2", "7272_2"}
+
+// assertObservationsOf checks that the observations
+// file at path contains data of expected sensors only.
+func assertObservationsOf(t *testing.T, expected []string, path string) {
+	series, err := webdrops.LoadObservations(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	actual := []string{}
+	for _, s := range series {
+		actual = append(actual, s.SensorID)
+	}
+	assert.Equal(t, expected, actual, path)
+}
+
+func TestWrfdaSensors(t *testing.T) {
+	srv, sess := setup(t)
+
+	err := WrfdaSensors(context.Background(), sess, simulStartDate, italyDomain, webdrops.GroupWunderground)
+	require.NoError(t, err)
+	assert.Equal(t, 1, srv.PasswordLogins())
+
+	for _, class := range []string{"DIREZIONEVENTO", "IGROMETRO", "TERMOMETRO", "ANEMOMETRO", "PLUVIOMETRO", "BAROMETRO"} {
+		for _, dir := range []string{"2020061000", "2020060921", "2020060918"} {
+			assertObservationsOf(t, sensorsInItaly, filepath.Join("WRFDA/SENSORS", dir, class+".json"))
+		}
+		assertFileEqual(t, readFixture(t, "sensors/list/"+class+".json"), filepath.Join("WRFDA/SENSORS", class+"-registry.json"))
+	}
+}
+
+func TestWrfdaSensorsInBatches(t *testing.T) {
+	srv := webdropstest.NewServer(nil)
+	defer srv.Close()
+	srv.MaxSensorsPerRequest = 3
+
+	opts := srv.SessionOptions()
+	opts.SensorsBatchSize = 3
+	sess := webdrops.NewSession(opts)
+	require.NoError(t, sess.Login(context.Background()))
+
+	oldWd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	defer os.Chdir(oldWd)
+
+	err = WrfdaSensors(context.Background(), sess, simulStartDate, italyDomain, webdrops.GroupDPC)
+	require.NoError(t, err)
+
+	dataRequests := 0
+	for _, path := range srv.Requests() {
+		if path == "/sensors/data/TERMOMETRO/" {
+			dataRequests++
+		}
+	}
+	// 4 sensors in batches of 3, for 3 dates
+	assert.Equal(t, 6, dataRequests)
+
+	for _, dir := range []string{"2020061000", "2020060921", "2020060918"} {
+		assertObservationsOf(t, sensorsInItaly, filepath.Join("WRFDA/SENSORS", dir, "TERMOMETRO.json"))
+	}
+}
+
+func TestWrfdaRadars(t *testing.T) {
+	expected := readFixture(t, "coverages/data.nc")
+	srv, sess := setup(t)
+
+	err := WrfdaRadars(context.Background(), sess, simulStartDate, schedule.DefaultCycles)
+	require.NoError(t, err)
+	assert.Equal(t, 1, srv.PasswordLogins())
+
+	for _, dir := range []string{"2020061000", "2020060921", "2020060918"} {
+		for _, varName := range []string{"CAPPI2", "CAPPI3", "CAPPI4", "CAPPI5"} {
+			assertFileEqual(t, expected, filepath.Join("WRFDA/RADARS", dir, dir+"-"+varName+".nc"))
+		}
+		assert.FileExists(t, filepath.Join("WRFDA", "ob.radar."+dir+".manifest.json"))
+	}
+
+	assert.Contains(t, srv.Requests(), "/coverages/RADAR_DPC_HDF5_CAPPI2/202006100005/CAPPI2/-/all")
+	assert.Contains(t, srv.Requests(), "/coverages/RADAR_DPC_HDF5_CAPPI3/202006092100/CAPPI3/-/all")
+	assert.Contains(t, srv.Requests(), "/coverages/RADAR_DPC_HDF5_CAPPI5/202006091755/CAPPI5/-/all")
+}
+
+func TestWrfdaHourlyCycles(t *testing.T) {
+	_, sess := setup(t)
+	hourly := schedule.Cycles{Count: 2, Interval: time.Hour}
+
+	err := WrfdaRadars(context.Background(), sess, simulStartDate, hourly)
+	require.NoError(t, err)
+
+	opts := WrfdaOptions(webdrops.GroupWunderground)
+	opts.Cycles = hourly
+	err = WrfdaObservations(context.Background(), sess, simulStartDate, italyDomain, opts)
+	require.NoError(t, err)
+
+	for _, dir := range []string{"2020061000", "2020060923"} {
+		assert.FileExists(t, filepath.Join("WRFDA/RADARS", dir, dir+"-CAPPI2.nc"))
+		assert.FileExists(t, filepath.Join("WRFDA/SENSORS", dir, "TERMOMETRO.json"))
+	}
+	assert.NoDirExists(t, "WRFDA/RADARS/2020060921")
+	assert.NoDirExists(t, "WRFDA/SENSORS/2020060922")
+}
+
+func TestRadarsDataset(t *testing.T) {
+	srv, sess := setup(t)
+
+	err := Radars(context.Background(), simulStartDate, RadarOptions{
+		Source: &WebdropsRadar{Sess: sess, Dataset: "RADAR_FR_{var}"},
+		Vars:   []string{"CAPPI2", "CAPPI3"},
+		Cycles: schedule.Cycles{Count: 1},
+	})
+	require.NoError(t, err)
+
+	assert.FileExists(t, "WRFDA/RADARS/2020061000/2020061000-CAPPI2.nc")
+	assert.FileExists(t, "WRFDA/RADARS/2020061000/2020061000-CAPPI3.nc")
+	assert.NoFileExists(t, "WRFDA/RADARS/2020061000/2020061000-CAPPI4.nc")
+	assert.Contains(t, srv.Requests(), "/coverages/RADAR_FR_CAPPI3/202006100005/CAPPI3/-/all")
+}
+
+func TestLocalRadars(t *testing.T) {
+	dir := t.TempDir()
+	chdirTemp(t)
+
+	files := map[string]string{
+		"202006100010-CAPPI2.nc": "a",
+		"202006100010-CAPPI3.nc": "b",
+		// only one variable is available at 00:00
+		"202006100000-CAPPI2.nc": "c",
+		// too far from the cycle
+		"202006092300-CAPPI2.nc": "d",
+		"202006092300-CAPPI3.nc": "e",
+	}
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	opts := RadarOptions{
+		Source: &LocalRadar{Dir: dir},
+		Vars:   []string{"CAPPI2", "CAPPI3"},
+		Cycles: schedule.Cycles{Count: 1},
+	}
+	err := Radars(context.Background(), simulStartDate, opts)
+	require.NoError(t, err)
+	assertFileEqual(t, []byte("a"), "WRFDA/RADARS/2020061000/2020061000-CAPPI2.nc")
+	assertFileEqual(t, []byte("b"), "WRFDA/RADARS/2020061000/2020061000-CAPPI3.nc")
+
+	opts.Cycles = schedule.Cycles{Count: 2, Interval: 3 * time.Hour}
+	err = Radars(context.Background(), simulStartDate, opts)
+	assert.Error(t, err)
+}
+
+func TestLocalRadarEmpty(t *testing.T) {
+	dir := t.TempDir()
+
+	for name, expected := range map[string]bool{
+		filepath.Join(dir, "missing"): true,
+		dir:                           true,
+	} {
+		empty, err := (&LocalRadar{Dir: name}).Empty()
+		require.NoError(t, err)
+		assert.Equal(t, expected, empty, name)
+	}
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "202006100010-CAPPI2.nc"), []byte("a"), 0644))
+	empty, err := (&LocalRadar{Dir: dir}).Empty()
+	require.NoError(t, err)
+	assert.False(t, empty)
+}
+
+func TestRadarsSelection(t *testing.T) {
+	dir := t.TempDir()
+	chdirTemp(t)
+
+	for _, name := range []string{
+		"202006100010-CAPPI2.nc",
+		"202006100010-CAPPI3.nc",
+		"202006100000-CAPPI2.nc",
+		"202006092340-CAPPI4.nc",
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(name), 0644))
+	}
+
+	opts := RadarOptions{
+		Source: &LocalRadar{Dir: dir},
+		Vars:   []string{"CAPPI2", "CAPPI3", "CAPPI4"},
+		Cycles: schedule.Cycles{Count: 1},
+	}
+	err := Radars(context.Background(), simulStartDate, opts)
+	assert.EqualError(t, err, "error downloading radars timeline: no radar found for 202006100000 within 30m0s")
+
+	opts.Selection = webdrops.RadarSelection{Policy: webdrops.RadarPerVariable}
+	require.NoError(t, Radars(context.Background(), simulStartDate, opts))
+	assertFileEqual(t, []byte("202006100000-CAPPI2.nc"), "WRFDA/RADARS/2020061000/2020061000-CAPPI2.nc")
+	assertFileEqual(t, []byte("202006092340-CAPPI4.nc"), "WRFDA/RADARS/2020061000/2020061000-CAPPI4.nc")
+
+	opts.Selection = webdrops.RadarSelection{Policy: webdrops.RadarPartial, MaxOffset: 15 * time.Minute}
+	require.NoError(t, Radars(context.Background(), simulStartDate, opts))
+	assertFileEqual(t, []byte("202006100010-CAPPI2.nc"), "WRFDA/RADARS/2020061000/2020061000-CAPPI2.nc")
+	assertFileEqual(t, []byte("202006100010-CAPPI3.nc"), "WRFDA/RADARS/2020061000/2020061000-CAPPI3.nc")
+	// the CAPPI4 of the previous run is removed
+	assert.NoFileExists(t, "WRFDA/RADARS/2020061000/2020061000-CAPPI4.nc")
+	assertFileEqual(t, []byte(`{
+  "cycle": "2020-06-10T00:00:00Z",
+  "policy": "partial",
+  "maxOffset": "15m0s",
+  "vars": [
+    {
+      "var": "CAPPI2",
+      "instant": "2020-06-10T00:10:00Z",
+      "offset": "10m0s"
+    },
+    {
+      "var": "CAPPI3",
+      "instant": "2020-06-10T00:10:00Z",
+      "offset": "10m0s"
+    }
+  ],
+  "missing": [
+    "CAPPI4"
+  ]
+}`), "WRFDA/ob.radar.2020061000.manifest.json")
+
+}
+
+func TestContinuumSensors(t *testing.T) {
+	_, sess := setup(t)
+
+	err := ContinuumSensors(context.Background(), sess, simulStartDate, italyDomain)
+	require.NoError(t, err)
+
+	for _, class := range []string{"RADIOMETRO", "IGROMETRO", "TERMOMETRO", "ANEMOMETRO", "PLUVIOMETRO"} {
+		assertObservationsOf(t, sensorsInItaly, filepath.Join("CONTINUUM/SENSORS", class+".json"))
+		assertFileEqual(t, readFixture(t, "sensors/list/"+class+".json"), filepath.Join("CONTINUUM/SENSORS", class+"-registry.json"))
+	}
+}
+
+func TestRisicoSensorsMaps(t *testing.T) {
+	_, sess := setup(t)
+
+	err := RisicoSensorsMaps(context.Background(), sess, simulStartDate)
+	require.NoError(t, err)
+
+	for _, dir := range []string{"2020060700", "2020060712", "2020060800", "2020060812", "2020060900", "2020060912"} {
+		for _, class := range []string{"PLUVIOMETRO", "IGROMETRO", "TERMOMETRO"} {
+			assertFileEqual(t, readFixture(t, "sensors/map/"+class+".nc"), filepath.Join("RISICO/SENSORS", dir, class+".nc"))
+		}
+	}
+}
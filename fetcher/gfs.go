@@ -0,0 +1,112 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/cima-lexis/lexisdn/webdrops"
+)
+
+// DefaultGFSURL is the URL of the NOMADS
+// filter script for GFS at 0.25 degrees.
+const DefaultGFSURL = "https://nomads.ncep.noaa.gov/cgi-bin/filter_gfs_0p25.pl"
+
+// GFSOptions are the options used by GFS.
+type GFSOptions struct {
+	BoundaryOptions
+	// URL is the URL of the NOMADS filter script.
+	// When empty, DefaultGFSURL is used.
+	URL string
+	// Client is the client used for all requests.
+	// When nil, http.DefaultClient is used.
+	Client *http.Client
+	// Attempts is the maximum number of attempts to download
+	// each file, resuming after every failure. When zero, 5
+	// attempts are made.
+	Attempts int
+	// RetryWait is the time waited between two attempts.
+	// When zero, 10 seconds are waited.
+	RetryWait time.Duration
+}
+
+// GFS retrieves the GFS files needed by a simulation of hours
+// starting at start, as described by opts, restricted to domain.
+// Being D the start of the simulation, files are downloaded from
+// the GFS run at D, or at D-6H when assimilating the default
+// WRFDA cycles, and from the runs at D-48H and D-24H when 2
+// warm up days are needed, as for Risico.
+//
+// Files are saved, under cwd, on directory GFS/<RUN>/ with name
+// gfs.t<HH>z.pgrb2.0p25.f<STEP>.
+func GFS(ctx context.Context, start time.Time, hours int, domain webdrops.Domain, opts GFSOptions) error {
+	return Boundary(ctx, NewGFSSource(opts), start, hours, domain, opts.BoundaryOptions)
+}
+
+// GFSSource is a BoundarySource downloading
+// GFS files from the NOMADS filter script.
+type GFSSource struct {
+	opts GFSOptions
+}
+
+// NewGFSSource returns a GFSSource configured by opts.
+func NewGFSSource(opts GFSOptions) *GFSSource {
+	if opts.URL == "" {
+		opts.URL = DefaultGFSURL
+	}
+	if opts.Attempts == 0 {
+		opts.Attempts = 5
+	}
+	if opts.RetryWait == 0 {
+		opts.RetryWait = 10 * time.Second
+	}
+	return &GFSSource{opts: opts}
+}
+
+// Name implements BoundarySource.
+func (src *GFSSource) Name() string {
+	return "GFS"
+}
+
+// RunInterval implements BoundarySource.
+// GFS runs at 00, 06, 12 and 18 UTC.
+func (src *GFSSource) RunInterval() time.Duration {
+	return 6 * time.Hour
+}
+
+// StepInterval implements BoundarySource.
+func (src *GFSSource) StepInterval() time.Duration {
+	return time.Hour
+}
+
+func gfsFileName(file BoundaryFile) string {
+	return fmt.Sprintf("gfs.t%sz.pgrb2.0p25.f%03d", file.Run.Format("15"), file.Step)
+}
+
+// Path implements BoundarySource.
+func (src *GFSSource) Path(file BoundaryFile) string {
+	return path.Join(file.Run.Format("2006010215"), gfsFileName(file))
+}
+
+// URL returns the URL of file, restricted to domain.
+func (src *GFSSource) URL(file BoundaryFile, domain webdrops.Domain) string {
+	query := url.Values{}
+	query.Set("file", gfsFileName(file))
+	query.Set("all_lev", "on")
+	query.Set("all_var", "on")
+	query.Set("subregion", "")
+	query.Set("leftlon", fmt.Sprintf("%g", domain.MinLon))
+	query.Set("rightlon", fmt.Sprintf("%g", domain.MaxLon))
+	query.Set("toplat", fmt.Sprintf("%g", domain.MaxLat))
+	query.Set("bottomlat", fmt.Sprintf("%g", domain.MinLat))
+	query.Set("dir", fmt.Sprintf("/gfs.%s/%s", file.Run.Format("20060102"), file.Run.Format("15")))
+	return src.opts.URL + "?" + query.Encode()
+}
+
+// Download implements BoundarySource.
+func (src *GFSSource) Download(ctx context.Context, file BoundaryFile, domain webdrops.Domain, path string) error {
+	return resumableDownload(ctx, src.opts.Client, src.URL(file, domain), path, src.opts.Attempts, src.opts.RetryWait)
+}
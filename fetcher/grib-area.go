@@ -0,0 +1,144 @@
+package fetcher
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/cima-lexis/lexisdn/webdrops"
+)
+
+// gribGrid is the area of a regular lat/lon GRIB2 grid,
+// in degrees. Longitudes go east from West to East.
+type gribGrid struct {
+	North, South, West, East float64
+}
+
+// global returns whether the grid spans all longitudes.
+func (grid gribGrid) global() bool {
+	return lonSpan(grid.West, grid.East) >= 359
+}
+
+// covers returns whether the grid contains domain.
+func (grid gribGrid) covers(domain webdrops.Domain) bool {
+	if domain.MinLat < grid.South || domain.MaxLat > grid.North {
+		return false
+	}
+	if grid.global() {
+		return true
+	}
+	span := lonSpan(grid.West, grid.East)
+	return lonSpan(grid.West, domain.MinLon)+lonSpan(domain.MinLon, domain.MaxLon) <= span
+}
+
+// lonSpan returns the degrees going east from west to east.
+func lonSpan(west, east float64) float64 {
+	return math.Mod(math.Mod(east-west, 360)+360, 360)
+}
+
+// checkGRIBArea checks that the grids of all the GRIB2
+// messages in the file at path cover domain, and are
+// not global.
+func checkGRIBArea(path string, domain webdrops.Domain) error {
+	grids, err := readGRIBGrids(path)
+	if err != nil {
+		return fmt.Errorf("cannot read grid of `%s`: %w", path, err)
+	}
+	for _, grid := range grids {
+		if grid.global() {
+			return fmt.Errorf("`%s` has a global grid, not cut to the domain", path)
+		}
+		if !grid.covers(domain) {
+			return fmt.Errorf("grid of `%s` (%g,%g,%g,%g) doesn't cover the domain", path, grid.South, grid.North, grid.West, grid.East)
+		}
+	}
+	return nil
+}
+
+// readGRIBGrids returns the grids of the GRIB2 messages
+// in the file at path, that must be regular lat/lon grids
+// (grid definition template 3.0).
+func readGRIBGrids(path string) ([]gribGrid, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var grids []gribGrid
+	var offset int64
+	for {
+		indicator := make([]byte, 16)
+		if _, err := f.ReadAt(indicator, offset); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if string(indicator[:4]) != "GRIB" || indicator[7] != 2 {
+			return nil, fmt.Errorf("no GRIB2 message at offset %d", offset)
+		}
+		end := offset + int64(binary.BigEndian.Uint64(indicator[8:]))
+
+		// sections follow, up to the 7777 end section
+		for pos := offset + 16; pos < end-4; {
+			header := make([]byte, 5)
+			if _, err := f.ReadAt(header, pos); err != nil {
+				return nil, err
+			}
+			length := int64(binary.BigEndian.Uint32(header))
+			if length < 5 {
+				return nil, fmt.Errorf("invalid section length at offset %d", pos)
+			}
+			if header[4] == 3 {
+				section := make([]byte, length)
+				if _, err := f.ReadAt(section, pos); err != nil {
+					return nil, err
+				}
+				grid, err := parseLatLonGrid(section)
+				if err != nil {
+					return nil, err
+				}
+				grids = append(grids, grid)
+			}
+			pos += length
+		}
+		offset = end
+	}
+	if len(grids) == 0 {
+		return nil, fmt.Errorf("no grid definition found")
+	}
+	return grids, nil
+}
+
+// parseLatLonGrid parses a grid definition section
+// using template 3.0, with coordinates in microdegrees.
+func parseLatLonGrid(section []byte) (gribGrid, error) {
+	if len(section) < 72 {
+		return gribGrid{}, fmt.Errorf("grid definition section too short")
+	}
+	if template := binary.BigEndian.Uint16(section[12:]); template != 0 {
+		return gribGrid{}, fmt.Errorf("unsupported grid definition template %d", template)
+	}
+	// octets are numbered from 1 in the GRIB2 specification
+	degrees := func(octet int) float64 {
+		v := binary.BigEndian.Uint32(section[octet-1:])
+		value := float64(v&0x7fffffff) / 1e6
+		if v&0x80000000 != 0 {
+			value = -value
+		}
+		return value
+	}
+	la1, lo1, la2, lo2 := degrees(47), degrees(51), degrees(56), degrees(60)
+	// with scanning mode bit 1 set, points go west
+	if section[71]&0x80 != 0 {
+		lo1, lo2 = lo2, lo1
+	}
+	return gribGrid{
+		North: math.Max(la1, la2),
+		South: math.Min(la1, la2),
+		West:  lo1,
+		East:  lo2,
+	}, nil
+}
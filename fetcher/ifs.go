@@ -0,0 +1,175 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cima-lexis/lexisdn/webdrops"
+)
+
+// ifsAreaPlaceholders are the placeholders of the bounds
+// of the domain, that IFS URL templates must contain.
+var ifsAreaPlaceholders = []string{"{north}", "{west}", "{south}", "{east}"}
+
+// IFSOptions are the options used by IFS.
+type IFSOptions struct {
+	BoundaryOptions
+	// URL is the template of the URL of every file, where
+	// {date} (YYYYMMDD) and {hour} (HH) are replaced by the
+	// reference time of the run, {step} by the forecast hour,
+	// and {north}, {west}, {south} and {east} by the bounds
+	// of the domain: the server must return the files cut to
+	// them, as MARS area requests, and templates without all
+	// of them are rejected, since global files would be saved.
+	URL string
+	// Mirror, when set, is a local directory containing
+	// the files, cut to an area covering the domain, with
+	// the same layout of the output directory. Files are
+	// copied from it instead of being downloaded, and
+	// the ones whose grid is global or doesn't cover the
+	// domain are rejected.
+	Mirror string
+	// Client is the client used for all requests.
+	// When nil, http.DefaultClient is used.
+	Client *http.Client
+	// Attempts is the maximum number of attempts to download
+	// each file, resuming after every failure. When zero, 5
+	// attempts are made.
+	Attempts int
+	// RetryWait is the time waited between two attempts.
+	// When zero, 10 seconds are waited.
+	RetryWait time.Duration
+}
+
+// IFS retrieves the IFS files needed by a simulation of hours
+// starting at start, as described by opts, restricted to domain.
+// Files are taken from the IFS runs at 00 and 12 UTC, every 3
+// hours by default, and opts.Interval must be a multiple of 3
+// hours. Either opts.URL, with the domain placeholders, or
+// opts.Mirror must be set.
+//
+// Files are saved, under cwd, on directory IFS/<RUN>/ with name
+// ifs.t<HH>z.0p25.f<STEP>.grib2, one GRIB file for every step,
+// ready to be linked by the link_grib.csh script of WPS.
+func IFS(ctx context.Context, start time.Time, hours int, domain webdrops.Domain, opts IFSOptions) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	return Boundary(ctx, NewIFSSource(opts), start, hours, domain, opts.BoundaryOptions)
+}
+
+// Validate returns an error if opts has neither a mirror
+// nor a URL with all the placeholders of the domain bounds.
+func (opts IFSOptions) Validate() error {
+	if opts.Mirror != "" {
+		return nil
+	}
+	if opts.URL == "" {
+		return fmt.Errorf("no IFS URL or mirror specified")
+	}
+	for _, placeholder := range ifsAreaPlaceholders {
+		if !strings.Contains(opts.URL, placeholder) {
+			return fmt.Errorf("IFS URL `%s` has no %s placeholder: files would not be cut to the domain", opts.URL, placeholder)
+		}
+	}
+	return nil
+}
+
+// IFSSource is a BoundarySource downloading IFS files
+// from an HTTP server, or copying them from a mirror.
+type IFSSource struct {
+	opts IFSOptions
+}
+
+// NewIFSSource returns an IFSSource configured by opts.
+func NewIFSSource(opts IFSOptions) *IFSSource {
+	if opts.Attempts == 0 {
+		opts.Attempts = 5
+	}
+	if opts.RetryWait == 0 {
+		opts.RetryWait = 10 * time.Second
+	}
+	return &IFSSource{opts: opts}
+}
+
+// Name implements BoundarySource.
+func (src *IFSSource) Name() string {
+	return "IFS"
+}
+
+// RunInterval implements BoundarySource.
+// Long IFS runs start at 00 and 12 UTC.
+func (src *IFSSource) RunInterval() time.Duration {
+	return 12 * time.Hour
+}
+
+// StepInterval implements BoundarySource.
+func (src *IFSSource) StepInterval() time.Duration {
+	return 3 * time.Hour
+}
+
+// Path implements BoundarySource.
+func (src *IFSSource) Path(file BoundaryFile) string {
+	name := fmt.Sprintf("ifs.t%sz.0p25.f%03d.grib2", file.Run.Format("15"), file.Step)
+	return path.Join(file.Run.Format("2006010215"), name)
+}
+
+// URL returns the URL of file, restricted to domain.
+func (src *IFSSource) URL(file BoundaryFile, domain webdrops.Domain) string {
+	return strings.NewReplacer(
+		"{date}", file.Run.Format("20060102"),
+		"{hour}", file.Run.Format("15"),
+		"{step}", fmt.Sprint(file.Step),
+		"{north}", fmt.Sprintf("%g", domain.MaxLat),
+		"{west}", fmt.Sprintf("%g", domain.MinLon),
+		"{south}", fmt.Sprintf("%g", domain.MinLat),
+		"{east}", fmt.Sprintf("%g", domain.MaxLon),
+	).Replace(src.opts.URL)
+}
+
+// Download implements BoundarySource.
+func (src *IFSSource) Download(ctx context.Context, file BoundaryFile, domain webdrops.Domain, path string) error {
+	if src.opts.Mirror != "" {
+		if err := copyMirrored(filepath.Join(src.opts.Mirror, src.Path(file)), path); err != nil {
+			return err
+		}
+		if err := checkGRIBArea(path, domain); err != nil {
+			os.Remove(path)
+			return err
+		}
+		return nil
+	}
+	return resumableDownload(ctx, src.opts.Client, src.URL(file, domain), path, src.opts.Attempts, src.opts.RetryWait)
+}
+
+// copyMirrored copies the file at src to a temporary
+// file, renamed to target once complete.
+func copyMirrored(src, target string) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	tmpPath := target + ".part"
+	w, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, target)
+}
@@ -0,0 +1,91 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultLocalRadarPattern is the default
+// name of files read by LocalRadar.
+const DefaultLocalRadarPattern = "{date}-{var}.nc"
+
+// LocalRadar is a RadarSource reading radar files from
+// a local directory, as a staged copy of radar data
+// provided by another system.
+type LocalRadar struct {
+	// Dir is the directory containing the radar files.
+	Dir string
+	// Pattern is the name of files, relative to Dir, where
+	// {date} is replaced by the instant of the radar, in
+	// format YYYYMMDDhhmm, and {var} by the variable name.
+	// When empty, DefaultLocalRadarPattern is used.
+	Pattern string
+}
+
+func (src *LocalRadar) pattern() string {
+	if src.Pattern == "" {
+		return DefaultLocalRadarPattern
+	}
+	return src.Pattern
+}
+
+func (src *LocalRadar) path(instant string, varName string) string {
+	return filepath.Join(src.Dir, strings.NewReplacer("{date}", instant, "{var}", varName).Replace(src.pattern()))
+}
+
+// Empty returns whether Dir is missing or contains
+// no files, as when radar data were not staged.
+func (src *LocalRadar) Empty() (bool, error) {
+	entries, err := os.ReadDir(src.Dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return len(entries) == 0, nil
+}
+
+// Timeline implements RadarSource, listing the files of varName in Dir.
+func (src *LocalRadar) Timeline(ctx context.Context, cycle time.Time, varName string, maxOffset time.Duration) ([]time.Time, error) {
+	glob := src.path("????????????", varName)
+	matches, err := filepath.Glob(glob)
+	if err != nil {
+		return nil, fmt.Errorf("invalid radar files pattern `%s`: %w", src.pattern(), err)
+	}
+
+	prefix := strings.Index(glob, "????????????")
+	timeline := []time.Time{}
+	for _, path := range matches {
+		instant, err := time.Parse("200601021504", path[prefix:prefix+12])
+		if err != nil {
+			continue
+		}
+		offset := instant.Sub(cycle)
+		if offset >= -maxOffset && offset <= maxOffset {
+			timeline = append(timeline, instant)
+		}
+	}
+	sort.Slice(timeline, func(i, j int) bool {
+		return timeline[i].Before(timeline[j])
+	})
+	return timeline, nil
+}
+
+// Fetch implements RadarSource, copying the file of varName at instant to path.
+func (src *LocalRadar) Fetch(ctx context.Context, instant time.Time, varName string, path string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.FileMode(0755)); err != nil {
+		return fmt.Errorf("error creating directory `%s`: %w", filepath.Dir(path), err)
+	}
+	return copyMirrored(src.path(instant.Format("200601021504"), varName), path)
+}
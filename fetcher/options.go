@@ -0,0 +1,75 @@
+package fetcher
+
+import (
+	"time"
+
+	"github.com/cima-lexis/lexisdn/schedule"
+	"github.com/cima-lexis/lexisdn/webdrops"
+)
+
+// SensorsOptions describes which sensors observations
+// a fetcher downloads and where it saves them.
+type SensorsOptions struct {
+	// Classes are the dewetra sensor classes to download.
+	Classes []string
+	// Group is the station group sensors belong to.
+	Group webdrops.SensorGroup
+	// Aggregation is the aggregation period of observations, in seconds.
+	Aggregation int
+	// Window is how long before the simulation start
+	// observations are downloaded from. For WRFDA
+	// observations, it's instead the half-width of the
+	// interval downloaded around every cycle.
+	Window time.Duration
+	// History, when longer than Window, is how long before
+	// every cycle WRFDA observations are downloaded from,
+	// so that quality control can check longer series
+	// for stuck sensors.
+	History time.Duration
+	// Step is the time span of each map. Used only for sensors maps.
+	Step time.Duration
+	// OutputDir is the directory, under cwd, where files are saved.
+	OutputDir string
+	// Cycles are the assimilation cycles observations are
+	// downloaded for. Used only for WRFDA observations.
+	Cycles schedule.Cycles
+}
+
+// ContinuumOptions are the options used by ContinuumSensors.
+var ContinuumOptions = SensorsOptions{
+	Classes:     []string{"RADIOMETRO", "IGROMETRO", "TERMOMETRO", "ANEMOMETRO", "PLUVIOMETRO"},
+	Group:       webdrops.GroupDPC,
+	Aggregation: 3600,
+	Window:      60 * time.Hour,
+	OutputDir:   "CONTINUUM/SENSORS",
+}
+
+// RisicoOptions are the options used by RisicoSensorsMaps.
+var RisicoOptions = SensorsOptions{
+	Classes:   []string{"PLUVIOMETRO", "IGROMETRO", "TERMOMETRO"},
+	Group:     webdrops.GroupDPC,
+	Window:    72 * time.Hour,
+	Step:      12 * time.Hour,
+	OutputDir: "RISICO/SENSORS",
+}
+
+// WrfdaOptions returns the options used by WrfdaSensors
+// to download observations of sensors in group.
+func WrfdaOptions(group webdrops.SensorGroup) SensorsOptions {
+	return SensorsOptions{
+		Classes: []string{
+			"DIREZIONEVENTO",
+			"IGROMETRO",
+			"TERMOMETRO",
+			"ANEMOMETRO",
+			"PLUVIOMETRO",
+			"BAROMETRO",
+		},
+		Group:       group,
+		Aggregation: 60,
+		Window:      5 * time.Minute,
+		History:     6 * time.Hour,
+		OutputDir:   "WRFDA/SENSORS",
+		Cycles:      schedule.DefaultCycles,
+	}
+}
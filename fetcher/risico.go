@@ -1,8 +1,8 @@
 package fetcher
 
 import (
+	"context"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"time"
@@ -26,24 +26,32 @@ import (
 //
 // Observations are saved, under cwd, on directory RISICO/SENSORS/<STEP START DATE>
 // with name <SENSORCLASS>.nc
-func RisicoSensorsMaps(simulStartDate time.Time) error {
-	sess := webdrops.Session{}
-	err := sess.Login()
-	if err != nil {
-		return nil
+func RisicoSensorsMaps(ctx context.Context, sess *webdrops.Session, simulStartDate time.Time) error {
+	return SensorsMaps(ctx, sess, simulStartDate, RisicoOptions)
+}
+
+// SensorsMaps retrieves maps of all sensors of opts.Classes,
+// from opts.Window before simulStartDate up to it, one set of
+// maps for each opts.Step, and saves them under
+// opts.OutputDir/<STEP START DATE>/<SENSORCLASS>.nc
+func SensorsMaps(ctx context.Context, sess *webdrops.Session, simulStartDate time.Time, opts SensorsOptions) error {
+	if opts.Step <= 0 {
+		return fmt.Errorf("invalid maps step %s", opts.Step)
 	}
 
 	fetcher := risicoSession{
+		ctx:  ctx,
 		sess: sess,
+		opts: opts,
 	}
 
-	for step := 6; step >= 1; step-- {
-		from := simulStartDate.Add(-12 * time.Duration(step) * time.Hour)
-		to := from.Add(12 * time.Hour)
+	for step := int(opts.Window / opts.Step); step >= 1; step-- {
+		from := simulStartDate.Add(-time.Duration(step) * opts.Step)
+		to := from.Add(opts.Step)
 
-		fetcher.fetchSensorMap("PLUVIOMETRO", from, to)
-		fetcher.fetchSensorMap("IGROMETRO", from, to)
-		fetcher.fetchSensorMap("TERMOMETRO", from, to)
+		for _, class := range opts.Classes {
+			fetcher.fetchSensorMap(class, from, to)
+		}
 
 		if fetcher.sessError != nil {
 			break
@@ -54,8 +62,10 @@ func RisicoSensorsMaps(simulStartDate time.Time) error {
 }
 
 type risicoSession struct {
+	ctx       context.Context
 	sessError error
-	sess      webdrops.Session
+	sess      *webdrops.Session
+	opts      SensorsOptions
 }
 
 func (fetcher *risicoSession) fetchSensorMap(class string, from, to time.Time) {
@@ -63,29 +73,19 @@ func (fetcher *risicoSession) fetchSensorMap(class string, from, to time.Time) {
 		return
 	}
 
-	fmt.Fprintf(os.Stderr, "Downloading observations map for %s from %s to %s\n", class, from.Format("02/01/2006 15"), to.Format("02/01/2006 15"))
-	sensorsMap, err := fetcher.sess.SensorsMap(class, from, to, webdrops.GroupDPC)
-	if err != nil {
-		fetcher.sessError = fmt.Errorf("Error fetching observations map: %w", err)
-		return
-	}
-
 	mapFilePath := filepath.Join(
-		"RISICO/SENSORS",
+		fetcher.opts.OutputDir,
 		from.Format("2006010215"),
 		fmt.Sprintf("%s.nc", class),
 	)
 
-	err = os.MkdirAll(filepath.Dir(mapFilePath), os.FileMode(0755))
+	fmt.Fprintf(os.Stderr, "Downloading observations map for %s from %s to %s\n", class, from.Format("02/01/2006 15"), to.Format("02/01/2006 15"))
+	download, err := fetcher.sess.SensorsMap(fetcher.ctx, class, from, to, fetcher.opts.Group, mapFilePath)
 	if err != nil {
-		fetcher.sessError = fmt.Errorf("Error creating directory `%s`: %w", filepath.Dir(mapFilePath), err)
+		fetcher.sessError = fmt.Errorf("Error fetching observations map: %w", err)
 		return
 	}
 
-	fmt.Fprintf(os.Stderr, "Saving observations map to %s\n", mapFilePath)
-	err = ioutil.WriteFile(mapFilePath, sensorsMap, os.FileMode(0644))
-	if err != nil {
-		fetcher.sessError = fmt.Errorf("Error saving observations map to `%s`: %w", mapFilePath, err)
-	}
+	fmt.Fprintf(os.Stderr, "Saved observations map to %s\n", download)
 
 }
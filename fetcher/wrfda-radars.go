@@ -1,48 +1,125 @@
 package fetcher
 
 import (
+	"context"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sync"
 	"time"
 
+	"github.com/cima-lexis/lexisdn/schedule"
 	"github.com/cima-lexis/lexisdn/webdrops"
 )
 
-// WrfdaRadars retrieves
-func WrfdaRadars(simulStartDate time.Time) error {
+// RadarSource provides the radar variables
+// assimilated by WRFDA.
+type RadarSource interface {
+	// Timeline returns, sorted, the instants at most maxOffset
+	// far from cycle at which variable varName is available.
+	Timeline(ctx context.Context, cycle time.Time, varName string, maxOffset time.Duration) ([]time.Time, error)
+	// Fetch saves variable varName of the radar at instant to path.
+	Fetch(ctx context.Context, instant time.Time, varName string, path string) error
+}
+
+// RadarOptions are the options used by Radars.
+type RadarOptions struct {
+	// Source is where radar data are taken from.
+	Source RadarSource
+	// Vars are the radar variables to retrieve.
+	// When empty, webdrops.DefaultRadarVars are used.
+	Vars []string
+	// Selection tells how the instants of radar variables
+	// are chosen for each cycle. When zero, every cycle uses
+	// the nearest instant, at most 30 minutes far from it, at
+	// which all variables are available.
+	Selection webdrops.RadarSelection
+	// Cycles are the assimilation cycles radar data are
+	// retrieved for. When zero, schedule.DefaultCycles are used.
+	Cycles schedule.Cycles
+	// OutputDir is the directory, under cwd, where files
+	// are saved. When empty, WRFDA/RADARS is used. The
+	// manifests are saved in its parent directory.
+	OutputDir string
+}
+
+// WrfdaRadars retrieves radar CAPPI for every assimilation cycle
+// of a WRFDA run starting at simulStartDate, and saves them under
+// WRFDA/RADARS/<CYCLE>/<CYCLE>-<VAR>.nc, together with the
+// WRFDA/ob.radar.<CYCLE>.manifest.json webdrops.RadarManifest.
+func WrfdaRadars(ctx context.Context, sess *webdrops.Session, simulStartDate time.Time, cycles schedule.Cycles) error {
+	return Radars(ctx, simulStartDate, RadarOptions{
+		Source: &WebdropsRadar{Sess: sess},
+		Cycles: cycles,
+	})
+}
+
+// Radars works as WrfdaRadars, retrieving
+// radar data from opts.Source.
+func Radars(ctx context.Context, simulStartDate time.Time, opts RadarOptions) error {
+	if opts.Source == nil {
+		return fmt.Errorf("no radar source specified")
+	}
+	vars := opts.Vars
+	if len(vars) == 0 {
+		vars = webdrops.DefaultRadarVars
+	}
+	outputDir := opts.OutputDir
+	if outputDir == "" {
+		outputDir = "WRFDA/RADARS"
+	}
+	if err := opts.Selection.Validate(); err != nil {
+		return err
+	}
+	sel := opts.Selection.OrDefault()
+
+	// the first error cancels all other downloads
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
+	dates := opts.Cycles.OrDefault().Dates(simulStartDate)
 	allDatesFetched := sync.WaitGroup{}
-	errs := make(chan error, 3)
+	errs := make(chan error, len(dates))
 	fetchDate := func(date time.Time) {
 		allDatesFetched.Add(1)
 		go func() {
 			defer allDatesFetched.Done()
-			var sess webdrops.Session
-			err := sess.Login()
-			if err != nil {
-				errs <- err
-				return
-			}
 			fetcher := wrfdaRadarsSession{
-				sess: sess,
+				ctx:       ctx,
+				source:    opts.Source,
+				outputDir: outputDir,
 			}
-			bestInstant /*timeline*/, err := fetcher.sess.RadarTimeline(date, false)
+			// files left by previous runs must not
+			// be taken for variables not available now
+			cycleDir := filepath.Join(outputDir, date.Format("2006010215"))
+			if err := os.RemoveAll(cycleDir); err != nil {
+				errs <- fmt.Errorf("error removing `%s`: %w", cycleDir, err)
+				cancel()
+				return
+			}
+			instants, err := selectRadarInstants(ctx, opts.Source, vars, date, sel)
 			if err != nil {
 				errs <- fmt.Errorf("error downloading radars timeline: %w", err)
+				cancel()
 				return
 			}
-			fetcher.fetchRadar(bestInstant, "CAPPI2", date)
-			fetcher.fetchRadar(bestInstant, "CAPPI3", date)
-			fetcher.fetchRadar(bestInstant, "CAPPI4", date)
-			fetcher.fetchRadar(bestInstant, "CAPPI5", date)
+			for _, varName := range vars {
+				if instant, ok := instants[varName]; ok {
+					fetcher.fetchRadar(instant, varName, date)
+				}
+			}
+			if fetcher.sessError == nil {
+				fetcher.sessError = webdrops.WriteRadarManifest(filepath.Dir(outputDir), webdrops.NewRadarManifest(date, vars, sel, instants))
+			}
+			if fetcher.sessError != nil {
+				errs <- fetcher.sessError
+				cancel()
+			}
 		}()
 	}
-	fetchDate(simulStartDate)
-	fetchDate(simulStartDate.Add(-3 * time.Hour))
-	fetchDate(simulStartDate.Add(-6 * time.Hour))
+	for _, date := range dates {
+		fetchDate(date)
+	}
 
 	allDatesFetched.Wait()
 	var err error
@@ -55,10 +132,25 @@ func WrfdaRadars(simulStartDate time.Time) error {
 	return err
 }
 
+// selectRadarInstants returns the instants of vars
+// in src chosen by sel for the cycle at date.
+func selectRadarInstants(ctx context.Context, src RadarSource, vars []string, date time.Time, sel webdrops.RadarSelection) (webdrops.RadarInstants, error) {
+	timelines := map[string][]time.Time{}
+	for _, varName := range vars {
+		timeline, err := src.Timeline(ctx, date, varName, sel.MaxOffset)
+		if err != nil {
+			return nil, err
+		}
+		timelines[varName] = timeline
+	}
+	return webdrops.SelectRadarInstants(timelines, vars, date, sel)
+}
+
 type wrfdaRadarsSession struct {
+	ctx       context.Context
 	sessError error
-	sess      webdrops.Session
-	//domain    webdrops.Domain
+	source    RadarSource
+	outputDir string
 }
 
 func (fetcher *wrfdaRadarsSession) fetchRadar(date time.Time, varName string, dateRequested time.Time) {
@@ -66,26 +158,48 @@ func (fetcher *wrfdaRadarsSession) fetchRadar(date time.Time, varName string, da
 		return
 	}
 
+	dtReq := dateRequested.Format("2006010215")
+	radarFilePath := filepath.Join(fetcher.outputDir, dtReq, fmt.Sprintf("%s-%s.nc", dtReq, varName))
+
 	fmt.Fprintf(os.Stderr, "Downloading radars for %s\n", date.Format("02/01/2006 15"))
-	fileContent, err := fetcher.sess.RadarData(date, varName)
+	err := fetcher.source.Fetch(fetcher.ctx, date, varName, radarFilePath)
 	if err != nil {
 		fetcher.sessError = fmt.Errorf("error downloading radars: %w", err)
 		return
 	}
 
-	dtReq := dateRequested.Format("2006010215")
-	radarFilePath := fmt.Sprintf("WRFDA/RADARS/%s/%s-%s.nc", dtReq, dtReq, varName)
+	fmt.Fprintf(os.Stderr, "Saved radars to %s\n", radarFilePath)
 
-	err = os.MkdirAll(filepath.Dir(radarFilePath), os.FileMode(0755))
-	if err != nil {
-		fetcher.sessError = fmt.Errorf("error creating directory `%s`: %w", filepath.Dir(radarFilePath), err)
-		return
+}
+
+// WebdropsRadar is a RadarSource downloading
+// radar data from webdrops coverages.
+type WebdropsRadar struct {
+	Sess *webdrops.Session
+	// Dataset is the name of the datasets of radar variables,
+	// where {var} is replaced by the variable name. When empty,
+	// webdrops.DefaultRadarDataset is used.
+	Dataset string
+}
+
+func (src *WebdropsRadar) dataset() string {
+	if src.Dataset == "" {
+		return webdrops.DefaultRadarDataset
 	}
+	return src.Dataset
+}
+
+// Timeline implements RadarSource.
+func (src *WebdropsRadar) Timeline(ctx context.Context, cycle time.Time, varName string, maxOffset time.Duration) ([]time.Time, error) {
+	return src.Sess.RadarVarTimeline(ctx, src.dataset(), varName, cycle, maxOffset)
+}
 
-	fmt.Fprintf(os.Stderr, "Saving radars to %s\n", radarFilePath)
-	err = ioutil.WriteFile(radarFilePath, fileContent, os.FileMode(0644))
+// Fetch implements RadarSource.
+func (src *WebdropsRadar) Fetch(ctx context.Context, instant time.Time, varName string, path string) error {
+	download, err := src.Sess.RadarDatasetData(ctx, src.dataset(), instant, varName, path)
 	if err != nil {
-		fetcher.sessError = fmt.Errorf("error saving radars to `%s`: %w", radarFilePath, err)
+		return err
 	}
-
+	fmt.Fprintf(os.Stderr, "Downloaded %s\n", download)
+	return nil
 }
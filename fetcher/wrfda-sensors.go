@@ -1,6 +1,7 @@
 package fetcher
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -24,55 +25,73 @@ import (
 //  * BAROMETRO
 //
 // Being D the start date and time of WRF simulation, needed dates
-// of observations are at time D-6H, D-3H and D. For each hour this function downloads
-// all observations from 30 minutes before to 30 minutes after. The
-// observation that will be assimilated for each sensors is the one
-// near the exact hour.
+// of observations are the assimilation cycles, by default at time D-6H,
+// D-3H and D. For each cycle this function downloads all observations
+// from 5 minutes before to 5 minutes after. The observation that will
+// be assimilated for each sensors is the one near the exact hour.
+//
+// Only observations of sensors inside domain are downloaded.
 //
 // Observations are saved, under cwd, on directory WRFDA/SENSORS/<DATE>
 // with name <SENSORCLASS>.json
-func WrfdaSensors(simulStartDate time.Time, domain webdrops.Domain, group webdrops.SensorGroup) error {
+func WrfdaSensors(ctx context.Context, sess *webdrops.Session, simulStartDate time.Time, domain webdrops.Domain, group webdrops.SensorGroup) error {
+	return WrfdaObservations(ctx, sess, simulStartDate, domain, WrfdaOptions(group))
+}
 
-	sensorClasses := []string{
-		//"DIREZIONEVENTO",
-		//"IGROMETRO",
-		"TERMOMETRO",
-		//"ANEMOMETRO",
-		//"PLUVIOMETRO",
-		//"BAROMETRO",
+// WrfdaObservations works as WrfdaSensors, downloading
+// observations of opts.Classes, from opts.Window (or opts.History,
+// when longer) before to opts.Window after each of opts.Cycles,
+// with opts.Aggregation.
+func WrfdaObservations(ctx context.Context, sess *webdrops.Session, simulStartDate time.Time, domain webdrops.Domain, opts SensorsOptions) error {
+	// the first error cancels all other downloads
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// registries are downloaded once, and the
+	// IDs found are used for all dates.
+	registryFetcher := WrfdaSensorsSession{
+		Ctx:     ctx,
+		Sess:    sess,
+		Domain:  domain,
+		Options: opts,
+	}
+	ids := map[string][]string{}
+	for _, class := range opts.Classes {
+		ids[class] = registryFetcher.FetchSensorIDs(class, simulStartDate, domain, opts.Group)
+	}
+	if registryFetcher.sessError != nil {
+		return registryFetcher.sessError
 	}
 
+	dates := opts.Cycles.OrDefault().Dates(simulStartDate)
 	allDatesFetched := sync.WaitGroup{}
-	errs := make(chan error, 3)
+	errs := make(chan error, len(dates))
 
 	fetchDate := func(date time.Time) {
 		allDatesFetched.Add(1)
 		go func() {
 			defer allDatesFetched.Done()
-			var sess webdrops.Session
-			err := sess.Login()
-			if err != nil {
-				errs <- err
-				return
-			}
 
 			fetcher := WrfdaSensorsSession{
-				Sess:   sess,
-				Domain: domain,
+				Ctx:     ctx,
+				Sess:    sess,
+				Domain:  domain,
+				Options: opts,
 			}
-			for _, class := range sensorClasses {
-				fetcher.fetchSensor(class, date, false, group)
+			for _, class := range opts.Classes {
+				fetcher.fetchSensor(class, ids[class], date, false, opts.Group)
 			}
 			if fetcher.sessError != nil {
 				errs <- fetcher.sessError
+				cancel()
 			}
 
 		}()
 	}
 
-	fetchDate(simulStartDate)
-	fetchDate(simulStartDate.Add(-3 * time.Hour))
-	fetchDate(simulStartDate.Add(-6 * time.Hour))
+	for _, date := range dates {
+		fetchDate(date)
+	}
 
 	allDatesFetched.Wait()
 	var err error
@@ -87,26 +106,34 @@ func WrfdaSensors(simulStartDate time.Time, domain webdrops.Domain, group webdro
 
 // WrfdaSensorsSession ...
 type WrfdaSensorsSession struct {
+	// Ctx is the context used for all requests.
+	// When nil, context.Background is used.
+	Ctx       context.Context
 	sessError error
-	Sess      webdrops.Session
+	Sess      *webdrops.Session
 	Domain    webdrops.Domain
+	// Options are the options used to download observations.
+	// When zero, WrfdaOptions(group) is used.
+	Options SensorsOptions
 }
 
-// FetchSensorIDs ...
+// FetchSensorIDs downloads the sensors registry for class,
+// saves it to <OutputDir>/<SENSORCLASS>-registry.json and
+// returns the IDs of sensors inside domain.
 func (fetcher *WrfdaSensorsSession) FetchSensorIDs(class string, date time.Time, domain webdrops.Domain, group webdrops.SensorGroup) []string {
 	if fetcher.sessError != nil {
 		return nil
 	}
 
 	fmt.Fprintf(os.Stderr, "Downloading sensors registry for %s\n", class)
-	sensorAnag, err := fetcher.Sess.SensorsList(class, group)
+	sensorAnag, err := fetcher.Sess.SensorsList(fetcher.ctx(), class, group)
 	if err != nil {
 		fetcher.sessError = fmt.Errorf("error fetching sensors list: %w", err)
 		return nil
 	}
 
 	jsonFilePath := filepath.Join(
-		"WRFDA/SENSORS",
+		fetcher.options(group).OutputDir,
 		//date.Format("2006010215"),
 		fmt.Sprintf("%s-registry.json", class),
 	)
@@ -126,43 +153,51 @@ func (fetcher *WrfdaSensorsSession) FetchSensorIDs(class string, date time.Time,
 	ids, err := fetcher.Sess.IDFromSensorsList(sensorAnag, domain)
 	fmt.Fprintf(os.Stderr, "Found %d sensors\n", len(ids))
 	if err != nil {
-		fetcher.sessError = fmt.Errorf("error creating directory `%s`: %w", filepath.Dir(jsonFilePath), err)
+		fetcher.sessError = fmt.Errorf("error reading ids: %w", err)
 		return nil
 	}
 	return ids
 }
 
-func (fetcher *WrfdaSensorsSession) fetchSensor(class string, date time.Time, log bool, group webdrops.SensorGroup) {
-	if fetcher.sessError != nil {
-		return
+func (fetcher *WrfdaSensorsSession) options(group webdrops.SensorGroup) SensorsOptions {
+	if fetcher.Options.OutputDir == "" {
+		return WrfdaOptions(group)
 	}
+	return fetcher.Options
+}
 
-	from := date.Add(-5 * time.Minute)
-	to := date.Add(5 * time.Minute)
+func (fetcher *WrfdaSensorsSession) ctx() context.Context {
+	if fetcher.Ctx == nil {
+		return context.Background()
+	}
+	return fetcher.Ctx
+}
 
-	fmt.Fprintf(os.Stderr, "Downloading observations for %s on %s\n", class, date.Format("02/01/2006 15"))
-	observations, err := fetcher.Sess.SensorsData(class /*, ids*/, from, to, 60, group)
-	if err != nil {
-		fetcher.sessError = fmt.Errorf("error fetching sensors data: %w", err)
+func (fetcher *WrfdaSensorsSession) fetchSensor(class string, ids []string, date time.Time, log bool, group webdrops.SensorGroup) {
+	if fetcher.sessError != nil {
 		return
 	}
 
+	opts := fetcher.options(group)
+	from := date.Add(-opts.Window)
+	if opts.History > opts.Window {
+		from = date.Add(-opts.History)
+	}
+	to := date.Add(opts.Window)
+
 	jsonFilePath := filepath.Join(
-		"WRFDA/SENSORS",
+		opts.OutputDir,
 		date.Format("2006010215"),
 		fmt.Sprintf("%s.json", class),
 	)
 
-	err = os.MkdirAll(filepath.Dir(jsonFilePath), os.FileMode(0755))
+	fmt.Fprintf(os.Stderr, "Downloading observations for %s on %s\n", class, date.Format("02/01/2006 15"))
+	download, err := fetcher.Sess.SensorsData(fetcher.ctx(), class, ids, from, to, opts.Aggregation, group, jsonFilePath)
 	if err != nil {
-		fetcher.sessError = fmt.Errorf("error creating directory `%s`: %w", filepath.Dir(jsonFilePath), err)
+		fetcher.sessError = fmt.Errorf("error fetching sensors data: %w", err)
 		return
 	}
 
-	fmt.Fprintf(os.Stderr, "Saving observations to %s\n", jsonFilePath)
-	err = ioutil.WriteFile(jsonFilePath, observations, os.FileMode(0644))
-	if err != nil {
-		fetcher.sessError = fmt.Errorf("error saving sensors data to `%s`: %w", jsonFilePath, err)
-	}
+	fmt.Fprintf(os.Stderr, "Saved observations to %s\n", download)
 
 }
@@ -3,17 +3,15 @@ module github.com/cima-lexis/lexisdn
 go 1.17
 
 require (
-	github.com/meteocima/dewetra2wrf v1.4.0
+	github.com/fhs/go-netcdf v1.2.1
 	github.com/meteocima/radar2wrf v1.10.1
 	github.com/stretchr/testify v1.7.0
+	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/fhs/go-netcdf v1.2.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
 )
 
-// replace github.com/meteocima/dewetra2wrf => ../dewetra2wrf
 // replace github.com/meteocima/radar2wrf => ../radar2wrf
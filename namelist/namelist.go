@@ -0,0 +1,272 @@
+// Package namelist reads Fortran namelist files, as the
+// namelist.input and namelist.wps files used by WRF and WPS.
+//
+// Group and variable names are case insensitive and are
+// stored lowercase. Every variable holds a list of values,
+// one for each domain for per-domain WRF settings.
+package namelist
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Namelist contains all groups of
+// a namelist file, indexed by name.
+type Namelist map[string]Group
+
+// Group contains the variables of a
+// namelist group, indexed by name.
+type Group map[string][]string
+
+// Load reads and parses the namelist file at path.
+func Load(path string) (Namelist, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening namelist `%s`: %w", path, err)
+	}
+	defer f.Close()
+
+	nl, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing namelist `%s`: %w", path, err)
+	}
+	return nl, nil
+}
+
+// Parse parses a namelist from r.
+func Parse(r io.Reader) (Namelist, error) {
+	tokens, err := tokenize(r)
+	if err != nil {
+		return nil, err
+	}
+
+	nl := Namelist{}
+	var group Group
+	var variable string
+
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		switch {
+		case tok.kind == tokGroup:
+			if group != nil {
+				return nil, fmt.Errorf("line %d: group &%s starts before the previous one ends", tok.line, tok.text)
+			}
+			group = Group{}
+			nl[strings.ToLower(tok.text)] = group
+			variable = ""
+
+		case group == nil:
+			return nil, fmt.Errorf("line %d: unexpected `%s` outside of a group", tok.line, tok.text)
+
+		case tok.kind == tokEnd:
+			group = nil
+
+		case tok.kind == tokWord && i+1 < len(tokens) && tokens[i+1].kind == tokEquals:
+			variable = strings.ToLower(tok.text)
+			group[variable] = nil
+			i++
+
+		case tok.kind == tokEquals:
+			return nil, fmt.Errorf("line %d: unexpected `=`", tok.line)
+
+		case variable == "":
+			return nil, fmt.Errorf("line %d: value `%s` without a variable", tok.line, tok.text)
+
+		default:
+			values, err := expandRepeat(tok)
+			if err != nil {
+				return nil, err
+			}
+			group[variable] = append(group[variable], values...)
+		}
+	}
+
+	if group != nil {
+		return nil, fmt.Errorf("unterminated group at end of file")
+	}
+	return nl, nil
+}
+
+// expandRepeat expands the n*value repeat syntax.
+func expandRepeat(tok token) ([]string, error) {
+	if tok.kind != tokWord {
+		return []string{tok.text}, nil
+	}
+	star := strings.Index(tok.text, "*")
+	if star <= 0 {
+		return []string{tok.text}, nil
+	}
+
+	n, err := strconv.Atoi(tok.text[:star])
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("line %d: invalid repeat count in `%s`", tok.line, tok.text)
+	}
+	values := make([]string, n)
+	for i := range values {
+		values[i] = tok.text[star+1:]
+	}
+	return values, nil
+}
+
+// Values returns all values of variable in group.
+func (nl Namelist) Values(group, variable string) ([]string, error) {
+	g, ok := nl[strings.ToLower(group)]
+	if !ok {
+		return nil, fmt.Errorf("group &%s not found", group)
+	}
+	values, ok := g[strings.ToLower(variable)]
+	if !ok || len(values) == 0 {
+		return nil, fmt.Errorf("variable %s not found in group &%s", variable, group)
+	}
+	return values, nil
+}
+
+// String returns the first value of variable in group.
+func (nl Namelist) String(group, variable string) (string, error) {
+	values, err := nl.Values(group, variable)
+	if err != nil {
+		return "", err
+	}
+	return values[0], nil
+}
+
+// Ints returns all values of variable in group as integers.
+func (nl Namelist) Ints(group, variable string) ([]int, error) {
+	values, err := nl.Values(group, variable)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]int, len(values))
+	for i, v := range values {
+		if result[i], err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid value for %s in group &%s: %w", variable, group, err)
+		}
+	}
+	return result, nil
+}
+
+// Int returns the first value of variable in group as an integer.
+func (nl Namelist) Int(group, variable string) (int, error) {
+	values, err := nl.Ints(group, variable)
+	if err != nil {
+		return 0, err
+	}
+	return values[0], nil
+}
+
+// Floats returns all values of variable in group as floats.
+// Fortran double precision exponents (1.0d3) are accepted.
+func (nl Namelist) Floats(group, variable string) ([]float64, error) {
+	values, err := nl.Values(group, variable)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]float64, len(values))
+	for i, v := range values {
+		v = strings.NewReplacer("d", "e", "D", "e").Replace(v)
+		if result[i], err = strconv.ParseFloat(v, 64); err != nil {
+			return nil, fmt.Errorf("invalid value for %s in group &%s: %w", variable, group, err)
+		}
+	}
+	return result, nil
+}
+
+// Float returns the first value of variable in group as a float.
+func (nl Namelist) Float(group, variable string) (float64, error) {
+	values, err := nl.Floats(group, variable)
+	if err != nil {
+		return 0, err
+	}
+	return values[0], nil
+}
+
+type tokenKind int
+
+const (
+	tokWord tokenKind = iota
+	tokString
+	tokGroup
+	tokEnd
+	tokEquals
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	line int
+}
+
+// tokenize splits the namelist in tokens, skipping
+// comments and the commas separating values.
+func tokenize(r io.Reader) ([]token, error) {
+	var tokens []token
+	scanner := bufio.NewScanner(r)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		for i := 0; i < len(text); {
+			c := text[i]
+			switch {
+			case c == '!':
+				// comment up to the end of line
+				i = len(text)
+
+			case c == ' ' || c == '\t' || c == '\r' || c == ',':
+				i++
+
+			case c == '=':
+				tokens = append(tokens, token{kind: tokEquals, text: "=", line: line})
+				i++
+
+			case c == '/':
+				tokens = append(tokens, token{kind: tokEnd, text: "/", line: line})
+				i++
+
+			case c == '\'' || c == '"':
+				// a doubled quote inside a string
+				// stands for a single one.
+				var str strings.Builder
+				for i++; ; i++ {
+					if i >= len(text) {
+						return nil, fmt.Errorf("line %d: unterminated string", line)
+					}
+					if text[i] == c {
+						if i+1 < len(text) && text[i+1] == c {
+							i++
+						} else {
+							break
+						}
+					}
+					str.WriteByte(text[i])
+				}
+				tokens = append(tokens, token{kind: tokString, text: str.String(), line: line})
+				i++
+
+			default:
+				start := i
+				for i < len(text) && !strings.ContainsRune(" \t\r,=!/'\"", rune(text[i])) {
+					i++
+				}
+				word := text[start:i]
+				if c == '&' || c == '$' {
+					if strings.EqualFold(word[1:], "end") {
+						tokens = append(tokens, token{kind: tokEnd, text: word, line: line})
+					} else {
+						tokens = append(tokens, token{kind: tokGroup, text: word[1:], line: line})
+					}
+					continue
+				}
+				tokens = append(tokens, token{kind: tokWord, text: word, line: line})
+			}
+		}
+	}
+
+	return tokens, scanner.Err()
+}
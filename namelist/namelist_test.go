@@ -0,0 +1,113 @@
+package namelist
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	nl, err := Load("testdata/namelist.input")
+	require.NoError(t, err)
+
+	maxDom, err := nl.Int("domains", "max_dom")
+	require.NoError(t, err)
+	assert.Equal(t, 3, maxDom)
+
+	eWE, err := nl.Ints("DOMAINS", "E_WE")
+	require.NoError(t, err)
+	assert.Equal(t, []int{150, 253, 316}, eWE)
+
+	eVert, err := nl.Ints("domains", "e_vert")
+	require.NoError(t, err)
+	assert.Equal(t, []int{50, 50, 50}, eVert)
+
+	ratio, err := nl.Ints("domains", "parent_grid_ratio")
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 3, 3}, ratio)
+
+	dx, err := nl.Float("domains", "dx")
+	require.NoError(t, err)
+	assert.Equal(t, 22500.0, dx)
+
+	outname, err := nl.String("time_control", "history_outname")
+	require.NoError(t, err)
+	assert.Equal(t, "wrfout_d<domain>_<date>", outname)
+
+	input, err := nl.Values("time_control", "input_from_file")
+	require.NoError(t, err)
+	assert.Equal(t, []string{".true.", ".true.", ".true."}, input)
+
+	assert.Contains(t, nl, "dfi_control")
+
+	_, err = nl.Int("domains", "not_there")
+	assert.Error(t, err)
+	_, err = nl.Int("physics", "mp_physics")
+	assert.Error(t, err)
+	_, err = nl.Ints("time_control", "history_outname")
+	assert.Error(t, err)
+}
+
+func TestParseFloats(t *testing.T) {
+	nl, err := Parse(strings.NewReader(`
+&geogrid
+ ref_lat = 42.5d0, truelat1 = -30.0, dx = 1.2e4
+/
+`))
+	require.NoError(t, err)
+
+	refLat, err := nl.Float("geogrid", "ref_lat")
+	require.NoError(t, err)
+	assert.Equal(t, 42.5, refLat)
+
+	truelat1, err := nl.Float("geogrid", "truelat1")
+	require.NoError(t, err)
+	assert.Equal(t, -30.0, truelat1)
+
+	dx, err := nl.Float("geogrid", "dx")
+	require.NoError(t, err)
+	assert.Equal(t, 12000.0, dx)
+}
+
+func TestParseStrings(t *testing.T) {
+	nl, err := Parse(strings.NewReader(`
+&share
+ title = 'it''s', quoted = "a ""b"" c", empty = '', mixed = 'say "hi"', '''' ! comment
+/
+`))
+	require.NoError(t, err)
+
+	title, err := nl.String("share", "title")
+	require.NoError(t, err)
+	assert.Equal(t, "it's", title)
+
+	quoted, err := nl.String("share", "quoted")
+	require.NoError(t, err)
+	assert.Equal(t, `a "b" c`, quoted)
+
+	empty, err := nl.String("share", "empty")
+	require.NoError(t, err)
+	assert.Equal(t, "", empty)
+
+	mixed, err := nl.Values("share", "mixed")
+	require.NoError(t, err)
+	assert.Equal(t, []string{`say "hi"`, "'"}, mixed)
+}
+
+func TestParseInvalid(t *testing.T) {
+	invalid := map[string]string{
+		"unterminated group":  "&domains\n max_dom = 1\n",
+		"unterminated string": "&share\n wrf_core = 'ARW,\n/\n",
+		"unterminated escape": "&share\n title = 'it''\n/\n",
+		"value outside group": "max_dom = 1\n",
+		"value without name":  "&domains\n 1, 2\n/\n",
+		"invalid repeat":      "&domains\n e_vert = x*50\n/\n",
+	}
+
+	for name, content := range invalid {
+		_, err := Parse(strings.NewReader(content))
+		assert.Error(t, err, name)
+	}
+}
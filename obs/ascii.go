@@ -0,0 +1,180 @@
+package obs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Missing is the value written to WRFDA
+// files in place of missing data.
+const Missing = -888888.0
+
+// Quality control flags of WRFDA ASCII files.
+const (
+	qcGood    = 0
+	qcMissing = -88
+)
+
+// Elevation returns the elevation above sea level, in meters,
+// of a point. ok is false when the elevation is unknown.
+type Elevation interface {
+	Elevation(lat, lon float64) (elevation float64, ok bool)
+}
+
+// ConstantElevation is an Elevation
+// that is the same for all points.
+type ConstantElevation float64
+
+// Elevation implements Elevation.
+func (e ConstantElevation) Elevation(lat, lon float64) (float64, bool) {
+	return float64(e), true
+}
+
+// Errors are the observation errors written
+// for each variable of a surface report.
+type Errors struct {
+	// Pressure error, in Pa.
+	Pressure float64
+	// WindSpeed error, in m/s.
+	WindSpeed float64
+	// WindDirection error, in degrees.
+	WindDirection float64
+	// Height error, in m.
+	Height float64
+	// Temperature error, in K.
+	Temperature float64
+	// RelativeHumidity error, in %.
+	RelativeHumidity float64
+}
+
+// DefaultErrors are the errors used by OBSPROC for SYNOP reports.
+var DefaultErrors = Errors{
+	Pressure:         100,
+	WindSpeed:        1.1,
+	WindDirection:    5,
+	Height:           7,
+	Temperature:      2,
+	RelativeHumidity: 10,
+}
+
+// ASCIIOptions configures WriteASCII.
+type ASCIIOptions struct {
+	// Elevation is used to calculate the height of stations.
+	// When nil, heights are written as missing.
+	Elevation Elevation
+	// Errors are the observation errors. Zero
+	// fields take the value of DefaultErrors.
+	Errors Errors
+}
+
+// withDefaults returns errs with zero
+// fields set from DefaultErrors.
+func (errs Errors) withDefaults() Errors {
+	set := func(v *float64, def float64) {
+		if *v == 0 {
+			*v = def
+		}
+	}
+	set(&errs.Pressure, DefaultErrors.Pressure)
+	set(&errs.WindSpeed, DefaultErrors.WindSpeed)
+	set(&errs.WindDirection, DefaultErrors.WindDirection)
+	set(&errs.Height, DefaultErrors.Height)
+	set(&errs.Temperature, DefaultErrors.Temperature)
+	set(&errs.RelativeHumidity, DefaultErrors.RelativeHumidity)
+	return errs
+}
+
+// asciiHeader describes the reports that follow, as written
+// by OBSPROC and read by WRFDA da_read_obs_ascii.
+const asciiHeader = `TOTAL =%7d, MISS. =-888888.,
+SYNOP =%7d, METAR =      0, SHIP  =      0, BUOY  =      0, BOGUS =      0, TEMP  =      0,
+AMDAR =      0, AIREP =      0, TAMDAR=      0, PILOT =      0, SATEM =      0, SATOB =      0,
+GPSPW =      0, GPSZD =      0, GPSRF =      0, GPSEP =      0, SSMT1 =      0, SSMT2 =      0,
+TOVS  =      0, QSCAT =      0, PROFL =      0, AIRSR =      0, OTHER =      0,
+INFO  = PLATFORM, DATE, NAME, LEVELS, LATITUDE, LONGITUDE, ELEVATION, ID.
+SRFC  = SLP, PW (DATA,QC,ERROR).
+EACH  = PRES, SPEED, DIR, HEIGHT, TEMP, DEW PT, HUMID (DATA,QC,ERROR)*LEVELS.
+INFO_FMT = (A12,1X,A19,1X,A40,1X,I6,3(F12.3,11X),6X,A40)
+SRFC_FMT = (F12.3,I4,F7.2,F12.3,I4,F7.3)
+EACH_FMT = (3(F12.3,I4,F7.2),11X,3(F12.3,I4,F7.2),11X,1(F12.3,I4,F7.2))
+#------------------------------------------------------------------------------#
+`
+
+// WriteASCII writes stations to w as surface (FM-12 SYNOP)
+// reports of a WRFDA ASCII observations file (ob.ascii),
+// in the format produced by OBSPROC. Stations without any
+// assimilable field, as those with only rain, are skipped.
+func WriteASCII(w io.Writer, stations []Surface, opts ASCIIOptions) error {
+	errs := opts.Errors.withDefaults()
+	stations = assimilable(stations)
+
+	buf := bufio.NewWriter(w)
+	fmt.Fprintf(buf, asciiHeader, len(stations), len(stations))
+
+	for _, s := range stations {
+		elevation := Missing
+		if opts.Elevation != nil {
+			if e, ok := opts.Elevation.Elevation(s.Lat, s.Lon); ok {
+				elevation = e
+			}
+		}
+
+		fmt.Fprintf(buf, "%-12s %-19s %s %6d%12.3f%11s%12.3f%11s%12.3f%11s%6s%s\n",
+			"FM-12 SYNOP",
+			s.Time.Format("2006-01-02_15:04:05"),
+			fixedWidth(s.Name, 40),
+			1,
+			s.Lat, "",
+			s.Lon, "",
+			elevation, "",
+			"",
+			fixedWidth(s.StationID, 40),
+		)
+
+		// sea level pressure and precipitable water
+		fmt.Fprintf(buf, "%12.3f%4d%7.2f%12.3f%4d%7.3f\n", Missing, qcMissing, 0.0, Missing, qcMissing, 0.0)
+
+		height := &elevation
+		if elevation == Missing {
+			height = nil
+		}
+		fmt.Fprintf(buf, "%s%s%s%11s%s%s%s%11s%s\n",
+			field(s.Pressure, errs.Pressure),
+			field(s.WindSpeed, errs.WindSpeed),
+			field(s.WindDirection, errs.WindDirection),
+			"",
+			field(height, errs.Height),
+			field(s.Temperature, errs.Temperature),
+			field(nil, 0),
+			"",
+			field(s.RelativeHumidity, errs.RelativeHumidity),
+		)
+	}
+
+	return buf.Flush()
+}
+
+// field formats a value of a report
+// together with its QC flag and error.
+func field(value *float64, err float64) string {
+	if value == nil {
+		return fmt.Sprintf("%12.3f%4d%7.2f", Missing, qcMissing, 0.0)
+	}
+	return fmt.Sprintf("%12.3f%4d%7.2f", *value, qcGood, err)
+}
+
+// fixedWidth returns s truncated or padded with spaces to width
+// bytes, since fortran reads fields in fixed byte columns while
+// fmt counts runes. s is never truncated inside a UTF-8 sequence.
+func fixedWidth(s string, width int) string {
+	if len(s) > width {
+		s = s[:width]
+		for len(s) > 0 && !utf8.ValidString(s) {
+			s = s[:len(s)-1]
+		}
+	}
+	return s + strings.Repeat(" ", width-len(s))
+}
@@ -0,0 +1,139 @@
+package obs
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "update golden files in testdata")
+
+// assertGolden compares actual with the content of
+// testdata/name, or rewrites it when -update is set.
+func assertGolden(t *testing.T, name string, actual []byte) {
+	path := filepath.Join("testdata", name)
+	if *update {
+		require.NoError(t, os.WriteFile(path, actual, 0644))
+	}
+	expected, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, string(expected), string(actual))
+}
+
+// elevations is an Elevation that
+// knows the height of some stations.
+type elevations map[[2]float64]float64
+
+func (e elevations) Elevation(lat, lon float64) (float64, bool) {
+	h, ok := e[[2]float64{lat, lon}]
+	return h, ok
+}
+
+func TestWriteASCII(t *testing.T) {
+	stations, err := Load(fixtureDir(t, Classes...), cycle, 5*time.Minute)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	err = WriteASCII(&buf, stations, ASCIIOptions{
+		Elevation: elevations{
+			{44.343433, 8.54158}: 50,
+			{44.05301, 8.088548}: 12.5,
+			{43.7189, 7.2756}:    120,
+		},
+	})
+	require.NoError(t, err)
+	assertGolden(t, "ob.ascii", buf.Bytes())
+}
+
+func TestWriteASCIIMissingValues(t *testing.T) {
+	stations, err := Load(fixtureDir(t, ClassTemperature), cycle, 5*time.Minute)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	err = WriteASCII(&buf, stations[:1], ASCIIOptions{
+		Elevation: ConstantElevation(0),
+		Errors:    Errors{Temperature: 1.5},
+	})
+	require.NoError(t, err)
+	assertGolden(t, "ob.ascii.temperature", buf.Bytes())
+}
+
+// accented are stations with non ASCII names, the
+// second one longer than 40 bytes, with an accented
+// letter across the 40th byte.
+var accented = []Surface{{
+	StationID:   "-1937157087_2",
+	Name:        "Località Beo",
+	Lat:         44.05301,
+	Lon:         8.088548,
+	Time:        cycle,
+	Temperature: float(290.15),
+}, {
+	StationID:   "7272_2",
+	Name:        "Stazione meteorologica di Sant'Agnès àèìòù",
+	Lat:         43.7189,
+	Lon:         7.2756,
+	Time:        cycle,
+	Temperature: float(288.65),
+}}
+
+// unassimilable are stations with only
+// fields WRFDA can't assimilate.
+var unassimilable = []Surface{{
+	StationID: "rain",
+	Lat:       44.1,
+	Lon:       8.1,
+	Time:      cycle,
+	Rain:      float(0.2),
+}, {
+	StationID:     "direction",
+	Lat:           44.2,
+	Lon:           8.2,
+	Time:          cycle,
+	WindDirection: float(270),
+}, {
+	StationID: "speed",
+	Lat:       44.3,
+	Lon:       8.3,
+	Time:      cycle,
+	WindSpeed: float(3),
+}}
+
+func TestWriteASCIINonASCIINames(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteASCII(&buf, accented, ASCIIOptions{})
+	require.NoError(t, err)
+	assertGolden(t, "ob.ascii.accented", buf.Bytes())
+
+	lines := strings.Split(buf.String(), "\n")
+	for _, line := range lines {
+		if strings.HasPrefix(line, "FM-12 SYNOP") {
+			assert.Len(t, line, 195, line)
+			assert.Contains(t, []string{"-1937157087_2", "7272_2"}, strings.TrimSpace(line[155:]))
+		}
+	}
+}
+
+func TestWriteASCIISkipsUnassimilable(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteASCII(&buf, append(unassimilable, accented[0]), ASCIIOptions{})
+	require.NoError(t, err)
+
+	assert.Contains(t, buf.String(), "TOTAL =      1,")
+	assert.Contains(t, buf.String(), "SYNOP =      1,")
+	assert.Equal(t, 1, strings.Count(buf.String(), "FM-12 SYNOP "))
+	assert.Contains(t, buf.String(), "-1937157087_2")
+}
+
+func TestFixedWidth(t *testing.T) {
+	assert.Equal(t, "Località ", fixedWidth("Località", 10))
+	assert.Equal(t, "Localit ", fixedWidth("Località", 8))
+	assert.Equal(t, "abc", fixedWidth("abcdef", 3))
+}
@@ -0,0 +1,132 @@
+package obs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// endOfData is the value of pressure and height
+// of the record closing the data of a LITTLE_R report.
+const endOfData = -777777.0
+
+// DefaultSource is the default source
+// written in LITTLE_R report headers.
+const DefaultSource = "WEBDROPS"
+
+// LittleROptions configures WriteLittleR.
+type LittleROptions struct {
+	// Elevation is used to calculate the height of stations.
+	// When nil, heights are written as missing.
+	Elevation Elevation
+	// Source is the source of reports, as the station group
+	// observations were downloaded from. When empty,
+	// DefaultSource is used.
+	Source string
+}
+
+// WriteLittleR writes stations to w as LITTLE_R surface
+// (FM-12 SYNOP) reports, as read by OBSPROC. Every report
+// consists of a header record, a single data record at the
+// surface, the end of data record and the tail record.
+// Stations without any assimilable field, as those with
+// only rain, are skipped.
+func WriteLittleR(w io.Writer, stations []Surface, opts LittleROptions) error {
+	stations = assimilable(stations)
+	source := opts.Source
+	if source == "" {
+		source = DefaultSource
+	}
+
+	buf := bufio.NewWriter(w)
+	for _, s := range stations {
+		var elevation *float64
+		if opts.Elevation != nil {
+			if e, ok := opts.Elevation.Elevation(s.Lat, s.Lon); ok {
+				elevation = &e
+			}
+		}
+
+		data := []*float64{
+			s.Pressure,
+			elevation,
+			s.Temperature,
+			nil, // dew point
+			s.WindSpeed,
+			s.WindDirection,
+			s.U,
+			s.V,
+			s.RelativeHumidity,
+			nil, // thickness
+		}
+		valid := 0
+		for _, v := range data {
+			if v != nil {
+				valid++
+			}
+		}
+
+		// header record
+		fmt.Fprintf(buf, "%20.5f%20.5f%s%s%s%s%20.5f%10d%10d%10d%10d%10d%10s%10s%10s%10d%10d%20s",
+			s.Lat,
+			s.Lon,
+			fixedWidth(s.StationID, 40),
+			fixedWidth(s.Name, 40),
+			fixedWidth("FM-12 SYNOP", 40),
+			fixedWidth(source, 40),
+			orMissing(elevation),
+			valid, 0, 0, // valid fields, errors, warnings
+			0, 0, // sequence number, duplicates
+			"F", "F", "F", // is sounding, bogus, discard
+			-888888, -888888, // seconds since 1970, julian day
+			s.Time.Format("20060102150405"),
+		)
+		header := []*float64{
+			nil,        // sea level pressure
+			nil,        // reference pressure
+			nil,        // ground temperature
+			nil,        // sea surface temperature
+			s.Pressure, // surface pressure
+			s.Rain,     // precipitation
+			nil,        // max temperature
+			nil,        // min temperature
+			nil,        // night min temperature
+			nil,        // 3 hours pressure change
+			nil,        // 24 hours pressure change
+			nil,        // cloud cover
+			nil,        // ceiling
+		}
+		for _, v := range header {
+			fmt.Fprintf(buf, "%13.5f%7d", orMissing(v), 0)
+		}
+		fmt.Fprintln(buf)
+
+		// data record
+		for _, v := range data {
+			fmt.Fprintf(buf, "%13.5f%7d", orMissing(v), 0)
+		}
+		fmt.Fprintln(buf)
+
+		// end of data record
+		for i := range data {
+			v := Missing
+			if i < 2 {
+				v = endOfData
+			}
+			fmt.Fprintf(buf, "%13.5f%7d", v, 0)
+		}
+		fmt.Fprintln(buf)
+
+		// tail record: valid fields, errors, warnings
+		fmt.Fprintf(buf, "%7d%7d%7d\n", valid, 0, 0)
+	}
+
+	return buf.Flush()
+}
+
+func orMissing(v *float64) float64 {
+	if v == nil {
+		return Missing
+	}
+	return *v
+}
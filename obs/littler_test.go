@@ -0,0 +1,59 @@
+package obs
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteLittleR(t *testing.T) {
+	stations, err := Load(fixtureDir(t, Classes...), cycle, 5*time.Minute)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	err = WriteLittleR(&buf, stations, LittleROptions{
+		Elevation: elevations{
+			{44.343433, 8.54158}: 50,
+			{44.05301, 8.088548}: 12.5,
+			{43.7189, 7.2756}:    120,
+		},
+		Source: "WUNDERGROUND",
+	})
+	require.NoError(t, err)
+	assertGolden(t, "little_r", buf.Bytes())
+
+	// every report has 4 records, the header is 600 characters long
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	require.Len(t, lines, 4*len(stations))
+	for i := 0; i < len(lines); i += 4 {
+		assert.Len(t, lines[i], 600)
+		assert.Len(t, lines[i+1], 200)
+		assert.Len(t, lines[i+2], 200)
+		assert.Len(t, lines[i+3], 21)
+	}
+}
+
+func TestWriteLittleRNonASCIINames(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteLittleR(&buf, accented, LittleROptions{Source: "WUNDERGROUND"})
+	require.NoError(t, err)
+	assertGolden(t, "little_r.accented", buf.Bytes())
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	require.Len(t, lines, 4*len(accented))
+	for i := 0; i < len(lines); i += 4 {
+		assert.Len(t, lines[i], 600)
+		assert.Equal(t, "FM-12 SYNOP", strings.TrimSpace(lines[i][120:160]))
+	}
+}
+
+func TestWriteLittleRSkipsUnassimilable(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteLittleR(&buf, unassimilable, LittleROptions{})
+	require.NoError(t, err)
+	assert.Empty(t, buf.String())
+}
@@ -0,0 +1,239 @@
+// Package obs combines observations of the single variables
+// measured by a station, downloaded per sensor class, into
+// multi-variable surface observations as assimilated by WRFDA.
+package obs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"path/filepath"
+	"time"
+
+	"github.com/cima-lexis/lexisdn/webdrops"
+)
+
+// Dewetra sensor classes of the variables
+// contained in a Surface observation.
+const (
+	ClassTemperature      = "TERMOMETRO"
+	ClassRelativeHumidity = "IGROMETRO"
+	ClassWindSpeed        = "ANEMOMETRO"
+	ClassWindDirection    = "DIREZIONEVENTO"
+	ClassPressure         = "BAROMETRO"
+	ClassRain             = "PLUVIOMETRO"
+)
+
+// Classes are all sensor classes read by Load.
+var Classes = []string{
+	ClassTemperature,
+	ClassRelativeHumidity,
+	ClassWindSpeed,
+	ClassWindDirection,
+	ClassPressure,
+	ClassRain,
+}
+
+// Surface is the observation of a surface station at an
+// instant. Variables not observed by the station are nil.
+type Surface struct {
+	StationID string
+	Name      string
+	Lat, Lon  float64
+	Time      time.Time
+
+	// Temperature is the air temperature, in K.
+	Temperature *float64
+	// RelativeHumidity is in %.
+	RelativeHumidity *float64
+	// WindSpeed is in m/s.
+	WindSpeed *float64
+	// WindDirection is the direction wind blows
+	// from, in degrees clockwise from north.
+	WindDirection *float64
+	// U and V are the west-east and south-north
+	// components of wind, in m/s. They are set only
+	// when both speed and direction are observed,
+	// or when speed is zero.
+	U, V *float64
+	// Pressure is the surface pressure, in Pa.
+	Pressure *float64
+	// Rain is the accumulated precipitation, in mm.
+	Rain *float64
+}
+
+// assimilable reports whether s has a field WRFDA assimilates
+// from surface reports: pressure, temperature, relative humidity
+// or wind, whose components need both speed and direction. Rain
+// is not part of SYNOP reports.
+func (s Surface) assimilable() bool {
+	return s.Pressure != nil || s.Temperature != nil || s.RelativeHumidity != nil || s.U != nil
+}
+
+// assimilable returns the stations with
+// at least an assimilable field.
+func assimilable(stations []Surface) []Surface {
+	var result []Surface
+	for _, s := range stations {
+		if s.assimilable() {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// ClassData contains registry and observations
+// of a sensor class, as saved by the fetcher.
+type ClassData struct {
+	Class    string
+	Registry webdrops.SensorRegistry
+	Series   []webdrops.ObservationSeries
+}
+
+// Load reads the observations and registries of all Classes
+// from dir, as prepared for a WRFDA cycle, and combines them
+// with Combine. Classes whose files are missing are skipped.
+func Load(dir string, cycle time.Time, maxOffset time.Duration) ([]Surface, error) {
+	data, err := LoadClasses(dir)
+	if err != nil {
+		return nil, err
+	}
+	return Combine(cycle, maxOffset, data), nil
+}
+
+// LoadClasses reads the observations and registries of all
+// Classes from dir. Classes whose files are missing are skipped.
+func LoadClasses(dir string) ([]ClassData, error) {
+	data := make([]ClassData, 0, len(Classes))
+	for _, class := range Classes {
+		registry, err := webdrops.LoadSensorRegistry(filepath.Join(dir, class+"-registry.json"))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error loading %s registry: %w", class, err)
+		}
+
+		series, err := webdrops.LoadObservations(filepath.Join(dir, class+".json"))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error loading %s observations: %w", class, err)
+		}
+
+		data = append(data, ClassData{Class: class, Registry: registry, Series: series})
+	}
+
+	return data, nil
+}
+
+// StationKey identifies a station by its position, since
+// sensors of different classes installed on the same
+// station may have different IDs.
+func StationKey(sensor webdrops.Sensor) string {
+	return fmt.Sprintf("%.4f,%.4f", sensor.Lat, sensor.Lng)
+}
+
+// Combine returns an observation at cycle for each station
+// having at least one sensor with a value at most maxOffset
+// far from cycle. The value nearest to cycle is used.
+// Stations are returned in the order their sensors appear
+// in data, and data of unknown classes are ignored.
+func Combine(cycle time.Time, maxOffset time.Duration, data []ClassData) []Surface {
+	stations := map[string]*Surface{}
+	var order []string
+
+	for _, class := range data {
+		set := setter(class.Class)
+		if set == nil {
+			continue
+		}
+		sensors := class.Registry.ByID()
+
+		for _, series := range class.Series {
+			sensor, ok := sensors[series.SensorID]
+			if !ok {
+				continue
+			}
+			observation, ok := series.Nearest(cycle, maxOffset)
+			if !ok {
+				continue
+			}
+
+			key := StationKey(sensor)
+			station, ok := stations[key]
+			if !ok {
+				station = &Surface{
+					StationID: sensor.ID,
+					Name:      sensor.Name,
+					Lat:       sensor.Lat,
+					Lon:       sensor.Lng,
+					Time:      cycle,
+				}
+				stations[key] = station
+				order = append(order, key)
+			}
+			set(station, observation.Value)
+		}
+	}
+
+	result := make([]Surface, len(order))
+	for i, key := range order {
+		station := stations[key]
+		station.setWind()
+		result[i] = *station
+	}
+	return result
+}
+
+// setter returns the function that sets the value
+// observed by a sensor of class, converted to the
+// units of Surface, or nil for unknown classes.
+func setter(class string) func(s *Surface, v float64) {
+	switch class {
+	case ClassTemperature:
+		return func(s *Surface, v float64) { s.Temperature = float(v + 273.15) }
+	case ClassRelativeHumidity:
+		return func(s *Surface, v float64) { s.RelativeHumidity = float(v) }
+	case ClassWindSpeed:
+		return func(s *Surface, v float64) { s.WindSpeed = float(v) }
+	case ClassWindDirection:
+		return func(s *Surface, v float64) { s.WindDirection = float(v) }
+	case ClassPressure:
+		return func(s *Surface, v float64) { s.Pressure = float(v * 100) }
+	case ClassRain:
+		return func(s *Surface, v float64) { s.Rain = float(v) }
+	}
+	return nil
+}
+
+// setWind calculates U and V from wind speed and direction.
+func (s *Surface) setWind() {
+	if s.WindSpeed == nil {
+		return
+	}
+	speed := *s.WindSpeed
+	if speed == 0 {
+		s.U, s.V = float(0), float(0)
+		return
+	}
+	if s.WindDirection == nil {
+		return
+	}
+	u, v := WindComponents(speed, *s.WindDirection)
+	s.U, s.V = &u, &v
+}
+
+// WindComponents returns the west-east and south-north
+// components of a wind of speed blowing from direction,
+// expressed in degrees clockwise from north.
+func WindComponents(speed, direction float64) (u, v float64) {
+	rad := direction * math.Pi / 180
+	return -speed * math.Sin(rad), -speed * math.Cos(rad)
+}
+
+func float(v float64) *float64 {
+	return &v
+}
@@ -0,0 +1,135 @@
+package obs
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cima-lexis/lexisdn/webdrops"
+	"github.com/cima-lexis/lexisdn/webdrops/webdropstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var cycle = time.Date(2020, 6, 9, 21, 0, 0, 0, time.UTC)
+
+// fixtureDir copies registries and observations
+// of classes from the fixtures into a new directory,
+// with the layout of a WRFDA cycle.
+func fixtureDir(t *testing.T, classes ...string) string {
+	dir := t.TempDir()
+	for _, class := range classes {
+		for src, target := range map[string]string{
+			"sensors/list/" + class + ".json": class + "-registry.json",
+			"sensors/data/" + class + ".json": class + ".json",
+		} {
+			content, err := fs.ReadFile(webdropstest.Fixtures(), src)
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(filepath.Join(dir, target), content, 0644))
+		}
+	}
+	return dir
+}
+
+func TestLoad(t *testing.T) {
+	dir := fixtureDir(t, Classes...)
+
+	stations, err := Load(dir, cycle, 5*time.Minute)
+	require.NoError(t, err)
+	// Suvero has no observations
+	require.Len(t, stations, 4)
+
+	celle := stations[0]
+	assert.Equal(t, "-1937152789_2", celle.StationID)
+	assert.Equal(t, "Giardino Botanico Celle", celle.Name)
+	assert.Equal(t, 44.343433, celle.Lat)
+	assert.Equal(t, 8.54158, celle.Lon)
+	assert.Equal(t, cycle, celle.Time)
+
+	assert.InDelta(t, 291.35, *celle.Temperature, 1e-9)
+	assert.Equal(t, 68.0, *celle.RelativeHumidity)
+	assert.Equal(t, 2.1, *celle.WindSpeed)
+	assert.Equal(t, 225.0, *celle.WindDirection)
+	assert.InDelta(t, 1.4849, *celle.U, 1e-4)
+	assert.InDelta(t, 1.4849, *celle.V, 1e-4)
+	assert.InDelta(t, 101280, *celle.Pressure, 1e-6)
+	assert.Equal(t, 0.2, *celle.Rain)
+
+	ids := []string{}
+	for _, s := range stations {
+		ids = append(ids, s.StationID)
+	}
+	assert.Equal(t, []string{"-1937152789_2", "-1937157087_2", "7272_2", "51243_1"}, ids)
+}
+
+func TestLoadMissingClasses(t *testing.T) {
+	dir := fixtureDir(t, ClassTemperature, ClassWindSpeed)
+
+	stations, err := Load(dir, cycle, 5*time.Minute)
+	require.NoError(t, err)
+	require.Len(t, stations, 4)
+
+	s := stations[0]
+	assert.NotNil(t, s.Temperature)
+	assert.NotNil(t, s.WindSpeed)
+	assert.Nil(t, s.RelativeHumidity)
+	assert.Nil(t, s.Pressure)
+	assert.Nil(t, s.Rain)
+	// wind components need a direction
+	assert.Nil(t, s.U)
+	assert.Nil(t, s.V)
+
+	stations, err = Load(dir, cycle.Add(time.Hour), 5*time.Minute)
+	require.NoError(t, err)
+	assert.Empty(t, stations)
+}
+
+func TestCombineByPosition(t *testing.T) {
+	at := func(value float64) webdrops.Observation {
+		return webdrops.Observation{Time: cycle.Add(-time.Minute), Value: value}
+	}
+
+	stations := Combine(cycle, 5*time.Minute, []ClassData{
+		{
+			Class:    ClassWindSpeed,
+			Registry: webdrops.SensorRegistry{{ID: "1", Name: "Genova", Lat: 44.4, Lng: 8.9}},
+			Series:   []webdrops.ObservationSeries{{SensorID: "1", Observations: []webdrops.Observation{at(0)}}},
+		},
+		{
+			Class:    ClassPressure,
+			Registry: webdrops.SensorRegistry{{ID: "2", Name: "Genova", Lat: 44.4, Lng: 8.9}},
+			Series:   []webdrops.ObservationSeries{{SensorID: "2", Observations: []webdrops.Observation{at(1000)}}},
+		},
+		{
+			Class:    "RADIOMETRO",
+			Registry: webdrops.SensorRegistry{{ID: "3", Name: "Savona", Lat: 44.3, Lng: 8.5}},
+			Series:   []webdrops.ObservationSeries{{SensorID: "3", Observations: []webdrops.Observation{at(500)}}},
+		},
+	})
+
+	require.Len(t, stations, 1)
+	s := stations[0]
+	assert.Equal(t, "1", s.StationID)
+	assert.Equal(t, 100000.0, *s.Pressure)
+	// calm wind has no direction
+	assert.Equal(t, 0.0, *s.U)
+	assert.Equal(t, 0.0, *s.V)
+}
+
+func TestWindComponents(t *testing.T) {
+	cases := []struct {
+		direction, u, v float64
+	}{
+		{0, 0, -10},
+		{90, -10, 0},
+		{180, 0, 10},
+		{270, 10, 0},
+	}
+	for _, c := range cases {
+		u, v := WindComponents(10, c.direction)
+		assert.InDelta(t, c.u, u, 1e-9, "direction %g", c.direction)
+		assert.InDelta(t, c.v, v, 1e-9, "direction %g", c.direction)
+	}
+}
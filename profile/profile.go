@@ -0,0 +1,557 @@
+// Package profile contains declarative definitions of
+// the data to download and convert for each kind of run
+// (RISICO, CONTINUUM, WRFIT ...).
+//
+// Profiles are read from YAML files. A set of built-in
+// profiles, replicating the historical download types,
+// is embedded in the executable and can be extended or
+// overridden by a user supplied file.
+package profile
+
+import (
+	_ "embed" // used to embed built-in profiles
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cima-lexis/lexisdn/fetcher"
+	"github.com/cima-lexis/lexisdn/qc"
+	"github.com/cima-lexis/lexisdn/regrid"
+	"github.com/cima-lexis/lexisdn/schedule"
+	"github.com/cima-lexis/lexisdn/webdrops"
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed builtin.yaml
+var builtinProfiles []byte
+
+// Set is a collection of named domains and profiles.
+type Set struct {
+	// Domains maps domain names to their
+	// MinLat,MaxLat,MinLon,MaxLon definition.
+	Domains map[string]string `yaml:"domains"`
+	// Profiles maps download types to their profile.
+	Profiles map[string]Profile `yaml:"profiles"`
+}
+
+// Profile describes all data to download
+// and convert for a kind of run.
+type Profile struct {
+	// Domain is either the name of a domain of the Set,
+	// or a MinLat,MaxLat,MinLon,MaxLon definition.
+	Domain string `yaml:"domain"`
+	// Maps, when set, are the sensors maps to download.
+	Maps *Sensors `yaml:"maps"`
+	// Observations, when set, are the sensors
+	// observations time series to download.
+	Observations *Sensors `yaml:"observations"`
+	// WRFDA, when set, describes the observations
+	// to download and convert for data assimilation.
+	WRFDA *WRFDA `yaml:"wrfda"`
+	// Boundary, when set, describes the forecast model
+	// data used as initial and boundary conditions.
+	Boundary *Boundary `yaml:"boundary"`
+	// Cleanup lists directories to remove
+	// after all data has been converted.
+	Cleanup []string `yaml:"cleanup"`
+}
+
+// Sensors describes a set of sensors to download.
+// See fetcher.SensorsOptions for the meaning of fields.
+type Sensors struct {
+	Classes     []string      `yaml:"classes"`
+	Group       string        `yaml:"group"`
+	Aggregation int           `yaml:"aggregation"`
+	Window      time.Duration `yaml:"window"`
+	History     time.Duration `yaml:"history"`
+	Step        time.Duration `yaml:"step"`
+	Output      string        `yaml:"output"`
+}
+
+// WRFDA describes data to download and
+// convert for a WRFDA data assimilation.
+type WRFDA struct {
+	// Runs are the start dates of the WRFDA runs
+	// to prepare, as offsets from the simulation start
+	// date. When empty, a single run at the simulation
+	// start date is prepared.
+	Runs []time.Duration `yaml:"runs"`
+	// Cycles are the assimilation cycles of each run.
+	// When unset, schedule.DefaultCycles are used.
+	Cycles schedule.Cycles `yaml:"cycles"`
+	// Sensors are the stations observations to assimilate.
+	// Output directory, aggregation and window default to
+	// the ones of fetcher.WrfdaOptions.
+	Sensors Sensors `yaml:"sensors"`
+	// Radar tells whether radar data are
+	// downloaded and converted too.
+	Radar bool `yaml:"radar"`
+	// RadarFilters are the low value filters applied to
+	// each CAPPI level after regridding. Levels not listed
+	// use regrid.DefaultLowValueFilter.
+	RadarFilters map[string]RadarFilter `yaml:"radar_filters"`
+	// RadarSource is where radar data are taken from.
+	// When unset, they are downloaded from the webdrops
+	// datasets of italian radars.
+	RadarSource RadarSource `yaml:"radar_source"`
+	// RadarSelection tells how the instants of radar
+	// variables are chosen for each cycle.
+	RadarSelection RadarSelection `yaml:"radar_selection"`
+	// QC overrides the options of the quality
+	// control of stations observations.
+	QC QC `yaml:"qc"`
+}
+
+// QC overrides qc.DefaultOptions and, for each sensor class
+// listed in Limits, the fields of its qc.DefaultLimits.
+// See qc.Options for the meaning of fields.
+type QC struct {
+	StepInterval      time.Duration       `yaml:"step_interval"`
+	BuddyRadius       float64             `yaml:"buddy_radius"`
+	MinBuddies        int                 `yaml:"min_buddies"`
+	DuplicateDistance float64             `yaml:"duplicate_distance"`
+	Limits            map[string]QCLimits `yaml:"limits"`
+}
+
+// QCLimits overrides the limits of a sensor class.
+// Zero thresholds disable the corresponding check.
+// See qc.Limits for the meaning of fields.
+type QCLimits struct {
+	Min            *float64       `yaml:"min"`
+	Max            *float64       `yaml:"max"`
+	MaxSpike       *float64       `yaml:"max_spike"`
+	MaxStep        *float64       `yaml:"max_step"`
+	StuckDuration  *time.Duration `yaml:"stuck_duration"`
+	Saturation     *float64       `yaml:"saturation"`
+	BuddyThreshold *float64       `yaml:"buddy_threshold"`
+}
+
+// Options returns the quality control options described by q.
+func (q QC) Options() qc.Options {
+	opts := qc.Options{
+		StepInterval:      q.StepInterval,
+		BuddyRadius:       q.BuddyRadius,
+		MinBuddies:        q.MinBuddies,
+		DuplicateDistance: q.DuplicateDistance,
+	}
+	if len(q.Limits) == 0 {
+		return opts
+	}
+
+	opts.Limits = map[string]qc.Limits{}
+	for class, l := range q.Limits {
+		limits := qc.DefaultLimits[class]
+		setFloat(&limits.Min, l.Min)
+		setFloat(&limits.Max, l.Max)
+		setFloat(&limits.MaxSpike, l.MaxSpike)
+		setFloat(&limits.MaxStep, l.MaxStep)
+		setFloat(&limits.Saturation, l.Saturation)
+		setFloat(&limits.BuddyThreshold, l.BuddyThreshold)
+		if l.StuckDuration != nil {
+			limits.StuckDuration = *l.StuckDuration
+		}
+		opts.Limits[class] = limits
+	}
+	return opts
+}
+
+func setFloat(field *float64, value *float64) {
+	if value != nil {
+		*field = *value
+	}
+}
+
+func (q QC) validate() error {
+	if q.StepInterval < 0 || q.BuddyRadius < 0 || q.MinBuddies < 0 || q.DuplicateDistance < 0 {
+		return fmt.Errorf("negative options")
+	}
+	for class, l := range q.Limits {
+		if l.StuckDuration != nil && *l.StuckDuration < 0 {
+			return fmt.Errorf("limits of %s: negative stuck_duration", class)
+		}
+	}
+	return nil
+}
+
+// RadarSelection describes how the instants of radar variables
+// are chosen. See webdrops.RadarSelection for the meaning of fields.
+type RadarSelection struct {
+	MaxOffset time.Duration `yaml:"max_offset"`
+	// Policy is either common, partial or per_variable.
+	Policy string `yaml:"policy"`
+}
+
+func (sel RadarSelection) selection() webdrops.RadarSelection {
+	return webdrops.RadarSelection{
+		MaxOffset: sel.MaxOffset,
+		Policy:    webdrops.RadarPolicy(sel.Policy),
+	}
+}
+
+// Radar source types.
+const (
+	RadarWebdrops = "webdrops"
+	RadarLocal    = "local"
+)
+
+// RadarSource describes where radar data are taken from.
+// See fetcher.WebdropsRadar and fetcher.LocalRadar for the
+// meaning of fields.
+type RadarSource struct {
+	// Type is either webdrops or local. When empty, webdrops is used.
+	Type string `yaml:"type"`
+	// Vars are the radar variables to assimilate, a subset of
+	// webdrops.DefaultRadarVars, the CAPPI levels converted by
+	// radar2wrf. When empty, all of them are used.
+	Vars []string `yaml:"vars"`
+	// Dataset is used by webdrops sources only.
+	Dataset string `yaml:"dataset"`
+	// Dir and Pattern are used by local sources only.
+	Dir     string `yaml:"dir"`
+	Pattern string `yaml:"pattern"`
+}
+
+// RadarFilter overrides the threshold and the missing
+// value of regrid.DefaultLowValueFilter for a CAPPI level.
+type RadarFilter struct {
+	Threshold *float32 `yaml:"threshold"`
+	Missing   *float32 `yaml:"missing"`
+}
+
+// Boundary sources.
+const (
+	SourceGFS = "gfs"
+	SourceIFS = "ifs"
+)
+
+// Boundary describes the forecast model data used as
+// initial and boundary conditions of a simulation.
+// See fetcher.BoundaryOptions for the meaning of fields.
+type Boundary struct {
+	// Source is the forecast model, gfs or ifs.
+	Source string `yaml:"source"`
+	// Hours is the length of the simulation.
+	Hours      int           `yaml:"hours"`
+	Interval   time.Duration `yaml:"interval"`
+	WarmupDays int           `yaml:"warmup_days"`
+	Output     string        `yaml:"output"`
+}
+
+// Builtin returns the built-in profiles.
+func Builtin() Set {
+	set, err := Parse(builtinProfiles)
+	if err != nil {
+		panic(fmt.Sprintf("invalid built-in profiles: %s", err))
+	}
+	return set
+}
+
+// Parse parses and validates a set of profiles from YAML content.
+func Parse(content []byte) (Set, error) {
+	var set Set
+	if err := yaml.Unmarshal(content, &set); err != nil {
+		return Set{}, fmt.Errorf("error parsing profiles: %w", err)
+	}
+	if err := set.Validate(); err != nil {
+		return Set{}, err
+	}
+	return set, nil
+}
+
+// Load reads the profiles file at path and returns
+// the built-in profiles merged with the ones read.
+// Domains and profiles with the same name of a
+// built-in one replace it.
+func Load(path string) (Set, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Set{}, fmt.Errorf("error reading profiles file `%s`: %w", path, err)
+	}
+
+	var user Set
+	if err := yaml.Unmarshal(content, &user); err != nil {
+		return Set{}, fmt.Errorf("error parsing profiles file `%s`: %w", path, err)
+	}
+
+	set := Builtin()
+	for name, domain := range user.Domains {
+		set.Domains[name] = domain
+	}
+	for name, profile := range user.Profiles {
+		set.Profiles[name] = profile
+	}
+
+	if err := set.Validate(); err != nil {
+		return Set{}, fmt.Errorf("invalid profiles file `%s`: %w", path, err)
+	}
+	return set, nil
+}
+
+// Names returns the sorted names of all profiles.
+func (set Set) Names() []string {
+	names := make([]string, 0, len(set.Profiles))
+	for name := range set.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get returns the profile with given name.
+func (set Set) Get(name string) (Profile, error) {
+	profile, ok := set.Profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("unknown profile `%s`. Available profiles: %s", name, strings.Join(set.Names(), ", "))
+	}
+	return profile, nil
+}
+
+// Domain resolves domain, that is either the name of
+// a domain of the set, or a MinLat,MaxLat,MinLon,MaxLon
+// definition.
+func (set Set) Domain(domain string) (webdrops.Domain, error) {
+	if coords, ok := set.Domains[domain]; ok {
+		domain = coords
+	}
+	return webdrops.ParseDomain(domain)
+}
+
+// Validate checks that all profiles of the set are well formed.
+func (set Set) Validate() error {
+	for _, name := range set.Names() {
+		if err := set.validate(set.Profiles[name]); err != nil {
+			return fmt.Errorf("profile `%s`: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (set Set) validate(profile Profile) error {
+	if _, err := set.Domain(profile.Domain); err != nil {
+		return err
+	}
+
+	if profile.Maps != nil {
+		opts, err := profile.Maps.Options(fetcher.SensorsOptions{})
+		if err != nil {
+			return fmt.Errorf("maps: %w", err)
+		}
+		if opts.Step <= 0 || opts.Window < opts.Step {
+			return fmt.Errorf("maps: step must be positive and not greater than window")
+		}
+	}
+
+	if profile.Observations != nil {
+		if _, err := profile.Observations.Options(fetcher.SensorsOptions{}); err != nil {
+			return fmt.Errorf("observations: %w", err)
+		}
+	}
+
+	if profile.WRFDA != nil {
+		if _, err := profile.WRFDA.Options(); err != nil {
+			return fmt.Errorf("wrfda: %w", err)
+		}
+		if err := profile.WRFDA.RadarSource.validate(); err != nil {
+			return fmt.Errorf("wrfda: radar_source: %w", err)
+		}
+		if err := profile.WRFDA.RadarSelection.selection().Validate(); err != nil {
+			return fmt.Errorf("wrfda: radar_selection: %w", err)
+		}
+		if err := profile.WRFDA.QC.validate(); err != nil {
+			return fmt.Errorf("wrfda: qc: %w", err)
+		}
+	}
+
+	if profile.Boundary != nil {
+		if _, err := profile.BoundaryOptions(); err != nil {
+			return fmt.Errorf("boundary: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// BoundaryOptions returns the options used to download initial
+// and boundary conditions of the profile. When the profile
+// includes a WRFDA assimilation, its cycles need initial
+// conditions too.
+func (profile Profile) BoundaryOptions() (fetcher.BoundaryOptions, error) {
+	b := profile.Boundary
+	if b == nil {
+		return fetcher.BoundaryOptions{}, fmt.Errorf("no boundary conditions specified")
+	}
+	var src fetcher.BoundarySource
+	switch b.Source {
+	case SourceGFS:
+		src = fetcher.NewGFSSource(fetcher.GFSOptions{})
+	case SourceIFS:
+		src = fetcher.NewIFSSource(fetcher.IFSOptions{})
+	default:
+		return fetcher.BoundaryOptions{}, fmt.Errorf("unknown source `%s`", b.Source)
+	}
+	if b.Hours <= 0 {
+		return fetcher.BoundaryOptions{}, fmt.Errorf("invalid hours %d", b.Hours)
+	}
+	if b.WarmupDays < 0 {
+		return fetcher.BoundaryOptions{}, fmt.Errorf("invalid warmup_days %d", b.WarmupDays)
+	}
+	if step := src.StepInterval(); b.Interval != 0 && (b.Interval < step || b.Interval%step != 0) {
+		return fetcher.BoundaryOptions{}, fmt.Errorf("invalid interval %s, must be a multiple of %s for %s", b.Interval, step, b.Source)
+	}
+
+	opts := fetcher.BoundaryOptions{
+		Interval:   b.Interval,
+		WarmupDays: b.WarmupDays,
+		OutputDir:  b.Output,
+	}
+	if profile.WRFDA != nil {
+		opts.Cycles = profile.WRFDA.Cycles.OrDefault()
+	}
+	return opts, nil
+}
+
+// Options returns the fetcher options described by
+// sensors, using values from defaults for unset fields.
+func (sensors Sensors) Options(defaults fetcher.SensorsOptions) (fetcher.SensorsOptions, error) {
+	opts := defaults
+	if len(sensors.Classes) > 0 {
+		opts.Classes = sensors.Classes
+	}
+	if len(opts.Classes) == 0 {
+		return opts, fmt.Errorf("no sensor classes specified")
+	}
+
+	group, err := webdrops.ParseSensorGroup(sensors.Group)
+	if err != nil {
+		return opts, err
+	}
+	opts.Group = group
+
+	if sensors.Aggregation != 0 {
+		opts.Aggregation = sensors.Aggregation
+	}
+	if sensors.Window != 0 {
+		opts.Window = sensors.Window
+	}
+	if sensors.History != 0 {
+		opts.History = sensors.History
+	}
+	if sensors.Step != 0 {
+		opts.Step = sensors.Step
+	}
+	if sensors.Output != "" {
+		opts.OutputDir = sensors.Output
+	}
+	if opts.OutputDir == "" {
+		return opts, fmt.Errorf("no output directory specified")
+	}
+
+	return opts, nil
+}
+
+// Options returns the fetcher options used to download
+// stations observations for WRFDA. The output directory
+// is always the one expected by the conversion.
+func (wrfda WRFDA) Options() (fetcher.SensorsOptions, error) {
+	group, err := webdrops.ParseSensorGroup(wrfda.Sensors.Group)
+	if err != nil {
+		return fetcher.SensorsOptions{}, err
+	}
+
+	cycles := wrfda.Cycles.OrDefault()
+	if err := cycles.Validate(); err != nil {
+		return fetcher.SensorsOptions{}, err
+	}
+
+	sensors := wrfda.Sensors
+	sensors.Output = ""
+	opts, err := sensors.Options(fetcher.WrfdaOptions(group))
+	opts.Cycles = cycles
+	return opts, err
+}
+
+// LowValueFilters returns the low value
+// filter to apply to each CAPPI level.
+func (wrfda WRFDA) LowValueFilters() map[string]regrid.LowValueFilter {
+	filters := map[string]regrid.LowValueFilter{}
+	for varname, f := range wrfda.RadarFilters {
+		filter := regrid.DefaultLowValueFilter
+		if f.Threshold != nil {
+			filter.Threshold = *f.Threshold
+		}
+		if f.Missing != nil {
+			filter.Missing = *f.Missing
+		}
+		filters[varname] = filter
+	}
+	return filters
+}
+
+func (src RadarSource) validate() error {
+	for _, varName := range src.Vars {
+		if !contains(webdrops.DefaultRadarVars, varName) {
+			return fmt.Errorf("unknown variable `%s`, radar2wrf converts only %s", varName, strings.Join(webdrops.DefaultRadarVars, ", "))
+		}
+	}
+
+	switch src.Type {
+	case "", RadarWebdrops:
+		return nil
+	case RadarLocal:
+		if src.Dir == "" {
+			return fmt.Errorf("no directory specified for local source")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown type `%s`", src.Type)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// RadarVars returns the radar variables to assimilate.
+func (wrfda WRFDA) RadarVars() []string {
+	if len(wrfda.RadarSource.Vars) > 0 {
+		return wrfda.RadarSource.Vars
+	}
+	return webdrops.DefaultRadarVars
+}
+
+// RadarOptions returns the options used to retrieve radar
+// data for cycles, using sess for webdrops sources.
+func (wrfda WRFDA) RadarOptions(sess *webdrops.Session, cycles schedule.Cycles) fetcher.RadarOptions {
+	opts := fetcher.RadarOptions{
+		Vars:      wrfda.RadarVars(),
+		Selection: wrfda.RadarSelection.selection(),
+		Cycles:    cycles,
+	}
+	src := wrfda.RadarSource
+	if src.Type == RadarLocal {
+		opts.Source = &fetcher.LocalRadar{Dir: src.Dir, Pattern: src.Pattern}
+	} else {
+		opts.Source = &fetcher.WebdropsRadar{Sess: sess, Dataset: src.Dataset}
+	}
+	return opts
+}
+
+// RunDates returns the start dates of the
+// WRFDA runs for a simulation starting at start.
+func (wrfda WRFDA) RunDates(start time.Time) []time.Time {
+	if len(wrfda.Runs) == 0 {
+		return []time.Time{start}
+	}
+
+	dates := make([]time.Time, len(wrfda.Runs))
+	for i, offset := range wrfda.Runs {
+		dates[i] = start.Add(offset)
+	}
+	return dates
+}
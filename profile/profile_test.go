@@ -0,0 +1,396 @@
+package profile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cima-lexis/lexisdn/fetcher"
+	"github.com/cima-lexis/lexisdn/obs"
+	"github.com/cima-lexis/lexisdn/qc"
+	"github.com/cima-lexis/lexisdn/regrid"
+	"github.com/cima-lexis/lexisdn/schedule"
+	"github.com/cima-lexis/lexisdn/webdrops"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuiltin(t *testing.T) {
+	set := Builtin()
+	assert.Equal(t, []string{"ADMS", "CONTINUUM", "LIMAGRAIN", "RISICO", "WRFFR", "WRFIT", "WRFITDPC"}, set.Names())
+
+	risico, err := set.Get("RISICO")
+	require.NoError(t, err)
+
+	domain, err := set.Domain(risico.Domain)
+	require.NoError(t, err)
+	assert.Equal(t, webdrops.Domain{MinLat: 24, MaxLat: 64, MinLon: -19, MaxLon: 48}, domain)
+
+	maps, err := risico.Maps.Options(fetcher.SensorsOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, fetcher.RisicoOptions, maps)
+
+	start := time.Date(2020, 6, 10, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, []time.Time{start, start.Add(-24 * time.Hour), start.Add(-48 * time.Hour)}, risico.WRFDA.RunDates(start))
+	assert.True(t, risico.WRFDA.Radar)
+
+	wrfda, err := risico.WRFDA.Options()
+	require.NoError(t, err)
+	assert.Equal(t, fetcher.WrfdaOptions(webdrops.GroupWunderground), wrfda)
+
+	continuum, err := set.Get("CONTINUUM")
+	require.NoError(t, err)
+	observations, err := continuum.Observations.Options(fetcher.SensorsOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, fetcher.ContinuumOptions, observations)
+	assert.Equal(t, []time.Time{start}, continuum.WRFDA.RunDates(start))
+
+	wrfitdpc, err := set.Get("WRFITDPC")
+	require.NoError(t, err)
+	wrfda, err = wrfitdpc.WRFDA.Options()
+	require.NoError(t, err)
+	assert.Equal(t, webdrops.GroupDPC, wrfda.Group)
+
+	for _, name := range []string{"WRFFR", "ADMS", "LIMAGRAIN"} {
+		france, err := set.Get(name)
+		require.NoError(t, err)
+		assert.Equal(t, "france", france.Domain)
+		assert.True(t, france.WRFDA.Radar)
+		assert.Equal(t, RadarSource{Type: RadarLocal, Dir: "DDI/RADARS"}, france.WRFDA.RadarSource)
+		assert.Equal(t, []string{"WRFDA/SENSORS", "WRFDA/RADARS"}, france.Cleanup)
+		assert.Equal(t, SourceIFS, france.Boundary.Source)
+	}
+
+	_, err = set.Get("NOTAPROFILE")
+	assert.Error(t, err)
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profiles.yaml")
+	err := os.WriteFile(path, []byte(`
+domains:
+  liguria: 43.7,44.7,7.4,10.1
+profiles:
+  WRFIT:
+    domain: 40,46,6,12
+    wrfda:
+      sensors:
+        group: DPC
+  LIGURIA:
+    domain: liguria
+    observations:
+      classes: [PLUVIOMETRO]
+      group: DPC
+      aggregation: 600
+      window: 24h
+      output: LIGURIA/SENSORS
+`), 0644)
+	require.NoError(t, err)
+
+	set, err := Load(path)
+	require.NoError(t, err)
+
+	// built-in profiles are still available
+	_, err = set.Get("RISICO")
+	require.NoError(t, err)
+
+	wrfit, err := set.Get("WRFIT")
+	require.NoError(t, err)
+	assert.False(t, wrfit.WRFDA.Radar)
+	domain, err := set.Domain(wrfit.Domain)
+	require.NoError(t, err)
+	assert.Equal(t, webdrops.Domain{MinLat: 40, MaxLat: 46, MinLon: 6, MaxLon: 12}, domain)
+
+	liguria, err := set.Get("LIGURIA")
+	require.NoError(t, err)
+	assert.Nil(t, liguria.WRFDA)
+	domain, err = set.Domain(liguria.Domain)
+	require.NoError(t, err)
+	assert.Equal(t, webdrops.Domain{MinLat: 43.7, MaxLat: 44.7, MinLon: 7.4, MaxLon: 10.1}, domain)
+
+	opts, err := liguria.Observations.Options(fetcher.SensorsOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, fetcher.SensorsOptions{
+		Classes:     []string{"PLUVIOMETRO"},
+		Group:       webdrops.GroupDPC,
+		Aggregation: 600,
+		Window:      24 * time.Hour,
+		OutputDir:   "LIGURIA/SENSORS",
+	}, opts)
+}
+
+func TestParseInvalid(t *testing.T) {
+	invalid := map[string]string{
+		"unknown domain": `
+profiles:
+  X:
+    domain: atlantis
+`,
+		"unknown group": `
+profiles:
+  X:
+    domain: 1,2,3,4
+    wrfda:
+      sensors:
+        group: NASA
+`,
+		"maps without step": `
+profiles:
+  X:
+    domain: 1,2,3,4
+    maps:
+      classes: [TERMOMETRO]
+      group: DPC
+      window: 24h
+      output: MAPS
+`,
+		"observations without classes": `
+profiles:
+  X:
+    domain: 1,2,3,4
+    observations:
+      group: DPC
+      output: OBS
+`,
+	}
+
+	for name, content := range invalid {
+		_, err := Parse([]byte(content))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestRadarFilters(t *testing.T) {
+	set, err := Parse([]byte(`
+profiles:
+  X:
+    domain: 1,2,3,4
+    wrfda:
+      sensors:
+        group: DPC
+      radar: true
+      radar_filters:
+        CAPPI2:
+          threshold: 15
+        CAPPI5:
+          threshold: 5
+          missing: -999
+`))
+	require.NoError(t, err)
+
+	x, err := set.Get("X")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]regrid.LowValueFilter{
+		"CAPPI2": {Threshold: 15, Missing: -9999},
+		"CAPPI5": {Threshold: 5, Missing: -999},
+	}, x.WRFDA.LowValueFilters())
+}
+
+func TestCycles(t *testing.T) {
+	set, err := Parse([]byte(`
+profiles:
+  RUC:
+    domain: 1,2,3,4
+    wrfda:
+      cycles:
+        count: 6
+        interval: 1h
+      sensors:
+        group: DPC
+`))
+	require.NoError(t, err)
+
+	ruc, err := set.Get("RUC")
+	require.NoError(t, err)
+	opts, err := ruc.WRFDA.Options()
+	require.NoError(t, err)
+	assert.Equal(t, schedule.Cycles{Count: 6, Interval: time.Hour}, opts.Cycles)
+
+	wrfit, err := Builtin().Get("WRFIT")
+	require.NoError(t, err)
+	opts, err = wrfit.WRFDA.Options()
+	require.NoError(t, err)
+	assert.Equal(t, schedule.DefaultCycles, opts.Cycles)
+
+	_, err = Parse([]byte(`
+profiles:
+  X:
+    domain: 1,2,3,4
+    wrfda:
+      cycles:
+        count: 2
+      sensors:
+        group: DPC
+`))
+	assert.Error(t, err)
+}
+
+func TestBoundary(t *testing.T) {
+	set := Builtin()
+
+	risico, err := set.Get("RISICO")
+	require.NoError(t, err)
+	opts, err := risico.BoundaryOptions()
+	require.NoError(t, err)
+	assert.Equal(t, fetcher.BoundaryOptions{Cycles: schedule.DefaultCycles, WarmupDays: 2}, opts)
+	assert.Equal(t, 48, risico.Boundary.Hours)
+
+	set, err = Parse([]byte(`
+profiles:
+  GFSONLY:
+    domain: 1,2,3,4
+    boundary:
+      source: gfs
+      hours: 24
+      interval: 3h
+      output: BOUNDARY
+`))
+	require.NoError(t, err)
+	gfsOnly, err := set.Get("GFSONLY")
+	require.NoError(t, err)
+	opts, err = gfsOnly.BoundaryOptions()
+	require.NoError(t, err)
+	assert.Equal(t, fetcher.BoundaryOptions{Interval: 3 * time.Hour, OutputDir: "BOUNDARY"}, opts)
+
+	for name, boundary := range map[string]string{
+		"unknown source":   "source: nam\n      hours: 24",
+		"missing hours":    "source: gfs",
+		"invalid interval": "source: gfs\n      hours: 24\n      interval: 90m",
+		"invalid ifs step": "source: ifs\n      hours: 24\n      interval: 2h",
+	} {
+		_, err := Parse([]byte("profiles:\n  X:\n    domain: 1,2,3,4\n    boundary:\n      " + boundary + "\n"))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestRadarSource(t *testing.T) {
+	set, err := Parse([]byte(`
+profiles:
+  FR:
+    domain: 1,2,3,4
+    wrfda:
+      sensors:
+        group: DPC
+      radar: true
+      radar_source:
+        type: local
+        vars: [CAPPI2, CAPPI3]
+        dir: /data/ddi
+        pattern: "{var}/{date}.nc"
+  IT:
+    domain: 1,2,3,4
+    wrfda:
+      sensors:
+        group: DPC
+      radar: true
+      radar_source:
+        dataset: RADAR_{var}
+      radar_selection:
+        max_offset: 15m
+        policy: per_variable
+`))
+	require.NoError(t, err)
+
+	fr, err := set.Get("FR")
+	require.NoError(t, err)
+	opts := fr.WRFDA.RadarOptions(nil, schedule.DefaultCycles)
+	assert.Equal(t, []string{"CAPPI2", "CAPPI3"}, opts.Vars)
+	assert.Equal(t, &fetcher.LocalRadar{Dir: "/data/ddi", Pattern: "{var}/{date}.nc"}, opts.Source)
+
+	it, err := set.Get("IT")
+	require.NoError(t, err)
+	opts = it.WRFDA.RadarOptions(nil, schedule.DefaultCycles)
+	assert.Equal(t, webdrops.DefaultRadarVars, opts.Vars)
+	assert.Equal(t, &fetcher.WebdropsRadar{Dataset: "RADAR_{var}"}, opts.Source)
+	assert.Equal(t, webdrops.RadarSelection{MaxOffset: 15 * time.Minute, Policy: webdrops.RadarPerVariable}, opts.Selection)
+
+	_, err = Parse([]byte(`
+profiles:
+  X:
+    domain: 1,2,3,4
+    wrfda:
+      sensors:
+        group: DPC
+      radar_source:
+        type: local
+`))
+	assert.Error(t, err)
+
+	_, err = Parse([]byte(`
+profiles:
+  X:
+    domain: 1,2,3,4
+    wrfda:
+      sensors:
+        group: DPC
+      radar_selection:
+        policy: nearest
+`))
+	assert.Error(t, err)
+	_, err = Parse([]byte(`
+profiles:
+  X:
+    domain: 1,2,3,4
+    wrfda:
+      sensors:
+        group: DPC
+      radar_source:
+        vars: [CAPPI2, DBZH]
+`))
+	assert.EqualError(t, err, "profile `X`: wrfda: radar_source: unknown variable `DBZH`, radar2wrf converts only CAPPI2, CAPPI3, CAPPI4, CAPPI5")
+}
+
+func TestQC(t *testing.T) {
+	set, err := Parse([]byte(`
+profiles:
+  X:
+    domain: 1,2,3,4
+    wrfda:
+      sensors:
+        group: DPC
+        history: 12h
+      qc:
+        buddy_radius: 30
+        limits:
+          TERMOMETRO:
+            buddy_threshold: 4
+            stuck_duration: 0s
+          BAROMETRO:
+            buddy_threshold: 2
+`))
+	require.NoError(t, err)
+	x, err := set.Get("X")
+	require.NoError(t, err)
+
+	sensors, err := x.WRFDA.Options()
+	require.NoError(t, err)
+	assert.Equal(t, 12*time.Hour, sensors.History)
+
+	opts := x.WRFDA.QC.Options()
+	assert.Equal(t, 30.0, opts.BuddyRadius)
+
+	temperature := qc.DefaultLimits[obs.ClassTemperature]
+	temperature.BuddyThreshold = 4
+	temperature.StuckDuration = 0
+	pressure := qc.DefaultLimits[obs.ClassPressure]
+	pressure.BuddyThreshold = 2
+	assert.Equal(t, map[string]qc.Limits{
+		obs.ClassTemperature: temperature,
+		obs.ClassPressure:    pressure,
+	}, opts.Limits)
+
+	_, err = Parse([]byte(`
+profiles:
+  X:
+    domain: 1,2,3,4
+    wrfda:
+      sensors:
+        group: DPC
+      qc:
+        limits:
+          TERMOMETRO:
+            stuck_duration: -1h
+`))
+	assert.Error(t, err)
+}
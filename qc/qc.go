@@ -0,0 +1,307 @@
+// Package qc implements the quality control of stations
+// observations, downloaded per sensor class, before they
+// are combined and converted for WRFDA.
+//
+// Every sensor series goes through gross range, spike,
+// step and stuck sensor checks. Sensors of the same class
+// installed at the same location are then reduced to one,
+// and the values used for the cycle are compared with the
+// ones of nearby stations. Rejected observations are
+// removed and listed, per station, in a Report.
+package qc
+
+import (
+	"sort"
+	"time"
+
+	"github.com/cima-lexis/lexisdn/obs"
+	"github.com/cima-lexis/lexisdn/webdrops"
+)
+
+// Check is the name of a quality control check.
+type Check string
+
+// Checks that can reject an observation.
+const (
+	// CheckRange rejects values outside of the gross
+	// range of the class.
+	CheckRange Check = "range"
+	// CheckSpike rejects values differing, in the same
+	// direction, from both the previous and the next one.
+	CheckSpike Check = "spike"
+	// CheckStep rejects values differing too much
+	// from the previous accepted one.
+	CheckStep Check = "step"
+	// CheckStuck rejects runs of identical values.
+	CheckStuck Check = "stuck"
+	// CheckDuplicate rejects sensors at the location
+	// of another sensor of the same class.
+	CheckDuplicate Check = "duplicate"
+	// CheckBuddy rejects values differing too much
+	// from the ones of nearby stations.
+	CheckBuddy Check = "buddy"
+)
+
+// Limits are the thresholds of the checks of a sensor
+// class, in the units of its observations as downloaded.
+// Zero thresholds disable the corresponding check.
+type Limits struct {
+	// Min and Max are the gross range of values.
+	// The check is disabled when both are zero.
+	Min, Max float64
+	// MaxSpike is the maximum difference of a
+	// value from both its neighbours.
+	MaxSpike float64
+	// MaxStep is the maximum difference between
+	// consecutive values.
+	MaxStep float64
+	// StuckDuration is the minimum duration of
+	// a run of identical values rejected.
+	StuckDuration time.Duration
+	// Saturation, when not zero, is the value at which sensors
+	// saturate: runs of values not lower than it are not stuck,
+	// as relative humidity staying at 100% in fog or rain.
+	Saturation float64
+	// BuddyThreshold is the maximum difference of the value
+	// of a station from the median of its buddies.
+	BuddyThreshold float64
+}
+
+// DefaultLimits are the limits of sensor classes not listed
+// in Options.Limits. Stuck checks need observations longer than
+// StuckDuration before the cycle, as the History downloaded by
+// fetcher.WrfdaOptions.
+var DefaultLimits = map[string]Limits{
+	// temperature of stations depends on their height,
+	// so that it's not compared with buddies by default.
+	obs.ClassTemperature: {
+		Min: -40, Max: 50,
+		MaxSpike: 2, MaxStep: 3,
+		StuckDuration: 6 * time.Hour,
+	},
+	obs.ClassRelativeHumidity: {
+		Min: 1, Max: 100,
+		MaxSpike: 10, MaxStep: 15,
+		StuckDuration:  6 * time.Hour,
+		Saturation:     100,
+		BuddyThreshold: 30,
+	},
+	obs.ClassWindSpeed: {
+		Min: 0, Max: 60,
+		MaxSpike: 10, MaxStep: 15,
+		StuckDuration:  6 * time.Hour,
+		BuddyThreshold: 10,
+	},
+	obs.ClassWindDirection: {
+		Min: 0, Max: 360,
+	},
+	// pressure of stations depends on their height,
+	// so that it's not compared with buddies by default.
+	obs.ClassPressure: {
+		Min: 500, Max: 1080,
+		MaxSpike: 1.5, MaxStep: 2,
+	},
+	obs.ClassRain: {
+		Min: 0, Max: 50,
+	},
+}
+
+// DefaultOptions are the options used for zero fields of Options.
+var DefaultOptions = Options{
+	StepInterval:      10 * time.Minute,
+	BuddyRadius:       50,
+	MinBuddies:        3,
+	DuplicateDistance: 0.1,
+}
+
+// Options configures Run.
+type Options struct {
+	// Limits are the limits of each sensor class.
+	// Classes not listed use DefaultLimits, and
+	// classes in neither are not checked.
+	Limits map[string]Limits
+	// StepInterval is the maximum distance of consecutive
+	// values compared by spike and step checks.
+	StepInterval time.Duration
+	// BuddyRadius is the distance, in km, of the
+	// stations compared with each one by buddy checks.
+	BuddyRadius float64
+	// MinBuddies is the minimum number of buddies
+	// needed to check the value of a station.
+	MinBuddies int
+	// DuplicateDistance is the distance, in km, under
+	// which sensors are considered at the same location.
+	DuplicateDistance float64
+}
+
+func (opts Options) withDefaults() Options {
+	if opts.StepInterval == 0 {
+		opts.StepInterval = DefaultOptions.StepInterval
+	}
+	if opts.BuddyRadius == 0 {
+		opts.BuddyRadius = DefaultOptions.BuddyRadius
+	}
+	if opts.MinBuddies == 0 {
+		opts.MinBuddies = DefaultOptions.MinBuddies
+	}
+	if opts.DuplicateDistance == 0 {
+		opts.DuplicateDistance = DefaultOptions.DuplicateDistance
+	}
+	return opts
+}
+
+func (opts Options) limits(class string) (Limits, bool) {
+	if limits, ok := opts.Limits[class]; ok {
+		return limits, true
+	}
+	limits, ok := DefaultLimits[class]
+	return limits, ok
+}
+
+// Report lists the observations rejected for a cycle.
+type Report struct {
+	Cycle time.Time `json:"cycle"`
+	// Stations are the stations with at least
+	// a rejected observation, in the order the
+	// first one was rejected.
+	Stations []StationReport `json:"stations"`
+}
+
+// StationReport lists the observations
+// rejected for a station.
+type StationReport struct {
+	Name  string  `json:"name"`
+	Lat   float64 `json:"lat"`
+	Lon   float64 `json:"lon"`
+	Flags []Flag  `json:"flags"`
+}
+
+// Flag is an observation rejected by a check.
+type Flag struct {
+	Check    Check     `json:"check"`
+	Class    string    `json:"class"`
+	SensorID string    `json:"sensorId"`
+	Time     time.Time `json:"time"`
+	Value    float64   `json:"value"`
+	// Reference is the value the observation was compared
+	// with by buddy and duplicate checks: the median of the
+	// buddies, or the value of the sensor duplicated.
+	Reference *float64 `json:"reference,omitempty"`
+}
+
+// Rejected returns the number of flags in report. Sensors
+// rejected by duplicate and buddy checks have a single flag.
+func (report Report) Rejected() int {
+	n := 0
+	for _, station := range report.Stations {
+		n += len(station.Flags)
+	}
+	return n
+}
+
+// reporter collects the flags of a Report, grouping them by station.
+type reporter struct {
+	report   *Report
+	stations map[string]int
+}
+
+func (r *reporter) flag(sensor webdrops.Sensor, flag Flag) {
+	key := obs.StationKey(sensor)
+	i, ok := r.stations[key]
+	if !ok {
+		i = len(r.report.Stations)
+		r.stations[key] = i
+		r.report.Stations = append(r.report.Stations, StationReport{
+			Name: sensor.Name,
+			Lat:  sensor.Lat,
+			Lon:  sensor.Lng,
+		})
+	}
+	r.report.Stations[i].Flags = append(r.report.Stations[i].Flags, flag)
+}
+
+// Run checks the observations of data, prepared for cycle, and
+// returns a copy of data containing accepted observations only,
+// together with the report of the rejected ones. maxOffset is
+// the maximum distance from cycle of the values used for it,
+// as given to obs.Combine: duplicate and buddy checks compare
+// those values, and reject all values of a sensor in it.
+func Run(cycle time.Time, maxOffset time.Duration, data []obs.ClassData, opts Options) ([]obs.ClassData, Report) {
+	opts = opts.withDefaults()
+	report := Report{Cycle: cycle, Stations: []StationReport{}}
+	r := &reporter{report: &report, stations: map[string]int{}}
+
+	result := make([]obs.ClassData, len(data))
+	for i, class := range data {
+		result[i] = class
+		limits, ok := opts.limits(class.Class)
+		if !ok {
+			continue
+		}
+
+		sensors := class.Registry.ByID()
+		series := make([]webdrops.ObservationSeries, 0, len(class.Series))
+		for _, s := range class.Series {
+			sensor, ok := sensors[s.SensorID]
+			if !ok {
+				// not combined, anyway
+				series = append(series, s)
+				continue
+			}
+			accepted, flags := checkSeries(s, limits, opts.StepInterval)
+			for _, flag := range flags {
+				flag.Class = class.Class
+				r.flag(sensor, flag)
+			}
+			series = append(series, webdrops.ObservationSeries{SensorID: s.SensorID, Observations: accepted})
+		}
+
+		values := currentValues(cycle, maxOffset, class.Registry, series)
+		rejected := map[string]bool{}
+		for _, flag := range checkDuplicates(values, opts.DuplicateDistance) {
+			rejected[flag.SensorID] = true
+			flag.Class = class.Class
+			r.flag(sensors[flag.SensorID], flag)
+		}
+		values = without(values, rejected)
+		if limits.BuddyThreshold > 0 {
+			for _, flag := range checkBuddies(values, limits.BuddyThreshold, opts.BuddyRadius, opts.MinBuddies) {
+				rejected[flag.SensorID] = true
+				flag.Class = class.Class
+				r.flag(sensors[flag.SensorID], flag)
+			}
+		}
+
+		for j, s := range series {
+			if rejected[s.SensorID] {
+				series[j].Observations = outside(s.Observations, cycle, maxOffset)
+			}
+		}
+		result[i].Series = series
+	}
+
+	return result, report
+}
+
+// sortedObservations returns a copy of
+// observations, sorted by time.
+func sortedObservations(observations []webdrops.Observation) []webdrops.Observation {
+	sorted := make([]webdrops.Observation, len(observations))
+	copy(sorted, observations)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Time.Before(sorted[j].Time)
+	})
+	return sorted
+}
+
+// outside returns the observations
+// farther than maxOffset from cycle.
+func outside(observations []webdrops.Observation, cycle time.Time, maxOffset time.Duration) []webdrops.Observation {
+	result := []webdrops.Observation{}
+	for _, o := range observations {
+		if offset := o.Time.Sub(cycle); offset < -maxOffset || offset > maxOffset {
+			result = append(result, o)
+		}
+	}
+	return result
+}
@@ -0,0 +1,215 @@
+package qc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cima-lexis/lexisdn/obs"
+	"github.com/cima-lexis/lexisdn/webdrops"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var cycle = time.Date(2020, 6, 10, 0, 0, 0, 0, time.UTC)
+
+// minutes returns observations with values, one per minute,
+// starting from the given number of minutes before cycle.
+func minutes(before int, values ...float64) []webdrops.Observation {
+	observations := make([]webdrops.Observation, len(values))
+	for i, v := range values {
+		observations[i] = webdrops.Observation{
+			Time:  cycle.Add(time.Duration(i-before) * time.Minute),
+			Value: v,
+		}
+	}
+	return observations
+}
+
+func values(observations []webdrops.Observation) []float64 {
+	result := make([]float64, len(observations))
+	for i, o := range observations {
+		result[i] = o.Value
+	}
+	return result
+}
+
+func checks(flags []Flag) []Check {
+	var result []Check
+	for _, flag := range flags {
+		result = append(result, flag.Check)
+	}
+	return result
+}
+
+func TestCheckSeries(t *testing.T) {
+	limits := DefaultLimits[obs.ClassTemperature]
+	limits.StuckDuration = 10 * time.Minute
+	tests := []struct {
+		name     string
+		values   []float64
+		accepted []float64
+		checks   []Check
+	}{{
+		name:     "good",
+		values:   []float64{18.1, 18.0, 18.2, 18.1, 17.9},
+		accepted: []float64{18.1, 18.0, 18.2, 18.1, 17.9},
+	}, {
+		name:     "range",
+		values:   []float64{18.1, -99.9, 18.2},
+		accepted: []float64{18.1, 18.2},
+		checks:   []Check{CheckRange},
+	}, {
+		name:     "spike",
+		values:   []float64{18.1, 18.0, 24.5, 18.1, 17.9},
+		accepted: []float64{18.1, 18.0, 18.1, 17.9},
+		checks:   []Check{CheckSpike},
+	}, {
+		name:     "step",
+		values:   []float64{18.1, 18.0, 22.5, 22.6, 22.4},
+		accepted: []float64{18.1, 18.0},
+		checks:   []Check{CheckStep, CheckStep, CheckStep},
+	}, {
+		name:     "stuck",
+		values:   []float64{18, 18, 18, 18, 18, 18, 18, 18, 18, 18, 18},
+		accepted: []float64{},
+		checks:   []Check{CheckStuck, CheckStuck, CheckStuck, CheckStuck, CheckStuck, CheckStuck, CheckStuck, CheckStuck, CheckStuck, CheckStuck, CheckStuck},
+	}, {
+		name:     "short stuck runs",
+		values:   []float64{18, 18, 18, 18, 18, 18.1, 18.1, 18.1, 18.1, 18.1, 18.1},
+		accepted: []float64{18, 18, 18, 18, 18, 18.1, 18.1, 18.1, 18.1, 18.1, 18.1},
+	}}
+
+	for _, test := range tests {
+		series := webdrops.ObservationSeries{SensorID: "1", Observations: minutes(5, test.values...)}
+		accepted, flags := checkSeries(series, limits, 10*time.Minute)
+		assert.Equal(t, test.accepted, values(accepted), test.name)
+		assert.Equal(t, test.checks, checks(flags), test.name)
+	}
+
+	// values far apart are not compared
+	series := webdrops.ObservationSeries{SensorID: "1", Observations: []webdrops.Observation{
+		{Time: cycle.Add(-3 * time.Hour), Value: 25},
+		{Time: cycle, Value: 18},
+	}}
+	accepted, flags := checkSeries(series, limits, 10*time.Minute)
+	assert.Len(t, accepted, 2)
+	assert.Empty(t, flags)
+
+	// steady values for minutes are accepted by default,
+	// values stuck for the whole history are not
+	series = webdrops.ObservationSeries{SensorID: "1", Observations: minutes(10, 18, 18, 18, 18, 18, 18, 18, 18, 18, 18, 18)}
+	accepted, flags = checkSeries(series, DefaultLimits[obs.ClassTemperature], 10*time.Minute)
+	assert.Len(t, accepted, 11)
+	assert.Empty(t, flags)
+
+	series = webdrops.ObservationSeries{SensorID: "1"}
+	for offset := -6 * time.Hour; offset <= 0; offset += 10 * time.Minute {
+		series.Observations = append(series.Observations, webdrops.Observation{Time: cycle.Add(offset), Value: 18})
+	}
+	accepted, flags = checkSeries(series, DefaultLimits[obs.ClassTemperature], 10*time.Minute)
+	assert.Empty(t, accepted)
+	assert.Len(t, flags, 37)
+}
+
+func TestCheckSeriesSaturation(t *testing.T) {
+	limits := DefaultLimits[obs.ClassRelativeHumidity]
+	limits.StuckDuration = 10 * time.Minute
+
+	series := webdrops.ObservationSeries{SensorID: "1", Observations: minutes(10, 100, 100, 100, 100, 100, 100, 100, 100, 100, 100, 100)}
+	accepted, flags := checkSeries(series, limits, 10*time.Minute)
+	assert.Len(t, accepted, 11)
+	assert.Empty(t, flags)
+
+	series = webdrops.ObservationSeries{SensorID: "1", Observations: minutes(10, 85, 85, 85, 85, 85, 85, 85, 85, 85, 85, 85)}
+	accepted, flags = checkSeries(series, limits, 10*time.Minute)
+	assert.Empty(t, accepted)
+	assert.Len(t, flags, 11)
+}
+
+func TestRun(t *testing.T) {
+	registry := webdrops.SensorRegistry{
+		{ID: "a", Name: "A", Lat: 44.40, Lng: 8.90},
+		{ID: "b", Name: "B", Lat: 44.45, Lng: 8.95},
+		{ID: "c", Name: "C", Lat: 44.35, Lng: 8.85},
+		{ID: "d", Name: "D", Lat: 44.42, Lng: 8.80},
+		// 30 meters from a
+		{ID: "e", Name: "E", Lat: 44.4002, Lng: 8.9002},
+		// in the Alps, far from all others
+		{ID: "f", Name: "F", Lat: 46.5, Lng: 10.5},
+	}
+	data := []obs.ClassData{{
+		Class:    obs.ClassTemperature,
+		Registry: registry,
+		Series: []webdrops.ObservationSeries{
+			{SensorID: "a", Observations: minutes(1, 18.1, 18.2, 18.3)},
+			{SensorID: "b", Observations: minutes(1, 17.9, 18.0, 18.1)},
+			{SensorID: "c", Observations: minutes(1, 18.6, 18.5, 18.4)},
+			{SensorID: "d", Observations: minutes(1, 26.1, 26.0, 26.2)},
+			{SensorID: "e", Observations: minutes(1, 18.0, 18.0, 18.1)},
+			{SensorID: "f", Observations: minutes(1, 2.0, 60, 2.1)},
+		},
+	}, {
+		// not checked
+		Class:    "RADIOMETRO",
+		Registry: registry,
+		Series:   []webdrops.ObservationSeries{{SensorID: "a", Observations: minutes(0, 5000)}},
+	}}
+
+	// temperatures are not compared with buddies by default
+	limits := DefaultLimits[obs.ClassTemperature]
+	limits.BuddyThreshold = 5
+	opts := Options{Limits: map[string]Limits{obs.ClassTemperature: limits}}
+
+	result, report := Run(cycle, 5*time.Minute, data, opts)
+	require.Len(t, result, 2)
+	assert.Equal(t, data[1], result[1])
+
+	accepted := map[string][]float64{}
+	for _, s := range result[0].Series {
+		accepted[s.SensorID] = values(s.Observations)
+	}
+	assert.Equal(t, map[string][]float64{
+		"a": {18.1, 18.2, 18.3},
+		"b": {17.9, 18.0, 18.1},
+		"c": {18.6, 18.5, 18.4},
+		"d": {},
+		"e": {},
+		"f": {2.0, 2.1},
+	}, accepted)
+
+	// one flag for the value used by each rejected sensor
+	assert.Equal(t, 3, report.Rejected())
+	require.Len(t, report.Stations, 3)
+
+	assert.Equal(t, "F", report.Stations[0].Name)
+	assert.Equal(t, []Check{CheckRange}, checks(report.Stations[0].Flags))
+
+	assert.Equal(t, "E", report.Stations[1].Name)
+	assert.Equal(t, []Check{CheckDuplicate}, checks(report.Stations[1].Flags))
+	assert.Equal(t, 18.2, *report.Stations[1].Flags[0].Reference)
+
+	d := report.Stations[2]
+	assert.Equal(t, "D", d.Name)
+	assert.Equal(t, []Check{CheckBuddy}, checks(d.Flags))
+	assert.Equal(t, Flag{
+		Check:     CheckBuddy,
+		Class:     obs.ClassTemperature,
+		SensorID:  "d",
+		Time:      cycle,
+		Value:     26.0,
+		Reference: d.Flags[0].Reference,
+	}, d.Flags[0])
+	assert.InDelta(t, 18.2, *d.Flags[0].Reference, 1e-9)
+	// the rejected value is no longer combined
+	assert.Len(t, obs.Combine(cycle, 5*time.Minute, result), 4)
+
+	_, report = Run(cycle, 5*time.Minute, data, Options{})
+	assert.Equal(t, 2, report.Rejected())
+}
+
+func TestSensorsDistance(t *testing.T) {
+	genoa := webdrops.Sensor{Lat: 44.4056, Lng: 8.9463}
+	milan := webdrops.Sensor{Lat: 45.4642, Lng: 9.1900}
+	assert.InDelta(t, 119, sensorsDistance(genoa, milan), 1)
+	assert.Equal(t, 0.0, sensorsDistance(genoa, genoa))
+}
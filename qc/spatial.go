@@ -0,0 +1,126 @@
+package qc
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/cima-lexis/lexisdn/webdrops"
+)
+
+// earthRadius is the mean radius of the earth, in km.
+const earthRadius = 6371.0
+
+// sensorValue is the value of a
+// sensor used for a cycle.
+type sensorValue struct {
+	sensor webdrops.Sensor
+	value  webdrops.Observation
+}
+
+// currentValues returns, in the order of series, the value
+// nearest to cycle, at most maxOffset far, of every sensor
+// in registry having one.
+func currentValues(cycle time.Time, maxOffset time.Duration, registry webdrops.SensorRegistry, series []webdrops.ObservationSeries) []sensorValue {
+	sensors := registry.ByID()
+	var values []sensorValue
+	for _, s := range series {
+		sensor, ok := sensors[s.SensorID]
+		if !ok {
+			continue
+		}
+		if o, ok := s.Nearest(cycle, maxOffset); ok {
+			values = append(values, sensorValue{sensor: sensor, value: o})
+		}
+	}
+	return values
+}
+
+func without(values []sensorValue, rejected map[string]bool) []sensorValue {
+	var result []sensorValue
+	for _, v := range values {
+		if !rejected[v.sensor.ID] {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// checkDuplicates rejects the values of sensors nearer than
+// distance to a previous accepted one. Flags miss the class.
+func checkDuplicates(values []sensorValue, distance float64) []Flag {
+	var flags []Flag
+	var accepted []sensorValue
+	for _, v := range values {
+		duplicate := false
+		for _, other := range accepted {
+			if sensorsDistance(v.sensor, other.sensor) < distance {
+				duplicate = true
+				reference := other.value.Value
+				flags = append(flags, flagValue(CheckDuplicate, v, &reference))
+				break
+			}
+		}
+		if !duplicate {
+			accepted = append(accepted, v)
+		}
+	}
+	return flags
+}
+
+// checkBuddies rejects the values differing more than threshold
+// from the median of the values of sensors at most radius far,
+// when there are at least minBuddies of them. All values are
+// compared with the same buddies, rejected or not. Flags miss
+// the class.
+func checkBuddies(values []sensorValue, threshold, radius float64, minBuddies int) []Flag {
+	var flags []Flag
+	for i, v := range values {
+		var buddies []float64
+		for j, other := range values {
+			if i != j && sensorsDistance(v.sensor, other.sensor) <= radius {
+				buddies = append(buddies, other.value.Value)
+			}
+		}
+		if len(buddies) < minBuddies {
+			continue
+		}
+		reference := median(buddies)
+		if math.Abs(v.value.Value-reference) > threshold {
+			flags = append(flags, flagValue(CheckBuddy, v, &reference))
+		}
+	}
+	return flags
+}
+
+func flagValue(check Check, v sensorValue, reference *float64) Flag {
+	return Flag{
+		Check:     check,
+		SensorID:  v.sensor.ID,
+		Time:      v.value.Time,
+		Value:     v.value.Value,
+		Reference: reference,
+	}
+}
+
+func median(values []float64) float64 {
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// sensorsDistance returns the great circle
+// distance between sensors a and b, in km.
+func sensorsDistance(a, b webdrops.Sensor) float64 {
+	const rad = math.Pi / 180
+	dLat := (b.Lat - a.Lat) * rad
+	dLon := (b.Lng - a.Lng) * rad
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(a.Lat*rad)*math.Cos(b.Lat*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(math.Min(1, h)))
+}
@@ -0,0 +1,114 @@
+package qc
+
+import (
+	"math"
+	"time"
+
+	"github.com/cima-lexis/lexisdn/webdrops"
+)
+
+// checkSeries applies gross range, spike, step and stuck
+// checks, in this order, to the observations of series.
+// Every check is applied to the values accepted by the
+// previous ones. Flags miss the class.
+func checkSeries(series webdrops.ObservationSeries, limits Limits, stepInterval time.Duration) ([]webdrops.Observation, []Flag) {
+	observations := sortedObservations(series.Observations)
+	var flags []Flag
+	reject := func(check Check, o webdrops.Observation) {
+		flags = append(flags, Flag{Check: check, SensorID: series.SensorID, Time: o.Time, Value: o.Value})
+	}
+
+	checks := []func([]webdrops.Observation) []bool{
+		func(obs []webdrops.Observation) []bool { return outOfRange(obs, limits) },
+		func(obs []webdrops.Observation) []bool { return spikes(obs, limits.MaxSpike, stepInterval) },
+		func(obs []webdrops.Observation) []bool { return steps(obs, limits.MaxStep, stepInterval) },
+		func(obs []webdrops.Observation) []bool { return stuck(obs, limits.StuckDuration, limits.Saturation) },
+	}
+	names := []Check{CheckRange, CheckSpike, CheckStep, CheckStuck}
+
+	for i, check := range checks {
+		rejected := check(observations)
+		accepted := observations[:0:0]
+		for j, o := range observations {
+			if rejected[j] {
+				reject(names[i], o)
+			} else {
+				accepted = append(accepted, o)
+			}
+		}
+		observations = accepted
+	}
+
+	return observations, flags
+}
+
+func outOfRange(observations []webdrops.Observation, limits Limits) []bool {
+	rejected := make([]bool, len(observations))
+	if limits.Min == 0 && limits.Max == 0 {
+		return rejected
+	}
+	for i, o := range observations {
+		rejected[i] = o.Value < limits.Min || o.Value > limits.Max || math.IsNaN(o.Value)
+	}
+	return rejected
+}
+
+// spikes rejects values differing more than maxSpike, in the
+// same direction, from both the previous and the next values,
+// when both are at most stepInterval far.
+func spikes(observations []webdrops.Observation, maxSpike float64, stepInterval time.Duration) []bool {
+	rejected := make([]bool, len(observations))
+	if maxSpike == 0 {
+		return rejected
+	}
+	for i := 1; i < len(observations)-1; i++ {
+		prev, o, next := observations[i-1], observations[i], observations[i+1]
+		if o.Time.Sub(prev.Time) > stepInterval || next.Time.Sub(o.Time) > stepInterval {
+			continue
+		}
+		before, after := o.Value-prev.Value, o.Value-next.Value
+		rejected[i] = math.Abs(before) > maxSpike && math.Abs(after) > maxSpike && before*after > 0
+	}
+	return rejected
+}
+
+// steps rejects values differing more than maxStep from the
+// previous accepted value, when it's at most stepInterval far.
+func steps(observations []webdrops.Observation, maxStep float64, stepInterval time.Duration) []bool {
+	rejected := make([]bool, len(observations))
+	if maxStep == 0 || len(observations) == 0 {
+		return rejected
+	}
+	prev := observations[0]
+	for i, o := range observations[1:] {
+		if o.Time.Sub(prev.Time) <= stepInterval && math.Abs(o.Value-prev.Value) > maxStep {
+			rejected[i+1] = true
+			continue
+		}
+		prev = o
+	}
+	return rejected
+}
+
+// stuck rejects runs of identical values lasting at least
+// duration, but runs at saturation when it's not zero.
+func stuck(observations []webdrops.Observation, duration time.Duration, saturation float64) []bool {
+	rejected := make([]bool, len(observations))
+	if duration == 0 {
+		return rejected
+	}
+	start := 0
+	for i := 1; i <= len(observations); i++ {
+		if i < len(observations) && observations[i].Value == observations[start].Value {
+			continue
+		}
+		saturated := saturation != 0 && observations[start].Value >= saturation
+		if !saturated && observations[i-1].Time.Sub(observations[start].Time) >= duration {
+			for j := start; j < i; j++ {
+				rejected[j] = true
+			}
+		}
+		start = i
+	}
+	return rejected
+}
@@ -0,0 +1,27 @@
+package regrid
+
+// LowValueFilter masks values below a threshold, as
+// radar reflectivity too low to be assimilated.
+type LowValueFilter struct {
+	// Threshold is the minimum value kept.
+	Threshold float32
+	// Missing is the value assigned to masked points,
+	// and the one that marks points without data.
+	Missing float32
+}
+
+// DefaultLowValueFilter masks reflectivity under 10 dBZ.
+var DefaultLowValueFilter = LowValueFilter{
+	Threshold: 10,
+	Missing:   -9999,
+}
+
+// Apply sets to f.Missing, in place, all values
+// lower than f.Threshold or not a number.
+func (f LowValueFilter) Apply(values []float32) {
+	for i, v := range values {
+		if v < f.Threshold || v != v {
+			values[i] = f.Missing
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package regrid
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLowValueFilter(t *testing.T) {
+	values := []float32{-9999, 0, 9.99, 10, 35.5, float32(math.NaN())}
+
+	DefaultLowValueFilter.Apply(values)
+	assert.Equal(t, []float32{-9999, -9999, -9999, 10, 35.5, -9999}, values)
+
+	values = []float32{-1, 4, 5, 20}
+	LowValueFilter{Threshold: 5, Missing: -1}.Apply(values)
+	assert.Equal(t, []float32{-1, -1, 5, 20}, values)
+}
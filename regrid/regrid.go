@@ -0,0 +1,309 @@
+// Package regrid implements bilinear interpolation of fields
+// defined on a regular latitude/longitude grid, as italian
+// radar CAPPI, onto the curvilinear grid of a WRF domain.
+//
+// It replaces the `cdo remapbil` command previously used,
+// and contains no I/O: grids and fields are plain slices.
+package regrid
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Grid is a regular latitude/longitude grid. Both
+// axes must be strictly monotonic, either ascending
+// or descending. Fields defined on the grid are
+// stored row-major, with Lats varying slowest.
+type Grid struct {
+	Lats, Lons []float64
+}
+
+// Points are the centres of the cells of a curvilinear
+// grid, as the mass points of a WRF domain read from
+// XLAT and XLONG. Coordinates are stored row-major,
+// with Rows south_north and Cols west_east.
+type Points struct {
+	Lats, Lons []float64
+	Rows, Cols int
+}
+
+// Len returns the number of points.
+func (p Points) Len() int {
+	return p.Rows * p.Cols
+}
+
+// weight is the contribution of
+// a source cell to a target point.
+type weight struct {
+	idx [4]int
+	w   [4]float64
+}
+
+// Bilinear interpolates fields from a source Grid onto target
+// Points. Interpolation weights are calculated once by
+// NewBilinear and reused for every field.
+type Bilinear struct {
+	srcLen  int
+	weights []weight
+	// inside[i] is false for target points
+	// falling outside of the source grid.
+	inside []bool
+}
+
+// NewBilinear returns a Bilinear that interpolates
+// fields defined on src onto dst.
+func NewBilinear(src Grid, dst Points) (*Bilinear, error) {
+	latAxis, lonAxis, err := src.axes()
+	if err != nil {
+		return nil, err
+	}
+	if len(dst.Lats) != dst.Len() || len(dst.Lons) != dst.Len() {
+		return nil, fmt.Errorf("target grid is %dx%d, but has %d latitudes and %d longitudes", dst.Rows, dst.Cols, len(dst.Lats), len(dst.Lons))
+	}
+
+	b := &Bilinear{
+		srcLen:  len(src.Lats) * len(src.Lons),
+		weights: make([]weight, dst.Len()),
+		inside:  make([]bool, dst.Len()),
+	}
+	for i := range b.weights {
+		b.weights[i], b.inside[i] = weightAt(latAxis, lonAxis, dst.Lats[i], dst.Lons[i])
+	}
+
+	return b, nil
+}
+
+// axes returns the latitude and longitude axes of g.
+func (g Grid) axes() (lat, lon axis, err error) {
+	if len(g.Lats) < 2 || len(g.Lons) < 2 {
+		return axis{}, axis{}, fmt.Errorf("source grid must have at least 2 latitudes and longitudes, got %dx%d", len(g.Lats), len(g.Lons))
+	}
+	if lat, err = newAxis(g.Lats); err != nil {
+		return axis{}, axis{}, fmt.Errorf("invalid source latitudes: %w", err)
+	}
+	if lon, err = newAxis(g.Lons); err != nil {
+		return axis{}, axis{}, fmt.Errorf("invalid source longitudes: %w", err)
+	}
+	return lat, lon, nil
+}
+
+// weightAt returns the weights of the source cells surrounding
+// the point at lat, lon. ok is false if the point falls outside
+// of the source grid.
+func weightAt(latAxis, lonAxis axis, lat, lon float64) (wg weight, ok bool) {
+	y, ty, okLat := latAxis.locate(lat)
+	x, tx, okLon := lonAxis.locate(lon)
+	if !okLat || !okLon {
+		return weight{}, false
+	}
+	cols := len(lonAxis.values)
+	return weight{
+		idx: [4]int{
+			y*cols + x,
+			y*cols + x + 1,
+			(y+1)*cols + x,
+			(y+1)*cols + x + 1,
+		},
+		w: [4]float64{
+			(1 - ty) * (1 - tx),
+			(1 - ty) * tx,
+			ty * (1 - tx),
+			ty * tx,
+		},
+	}, true
+}
+
+// apply returns the weighted sum of values, or
+// missing if any weighted value is missing.
+func (wg weight) apply(values []float32, missing float32) float32 {
+	var sum float64
+	for n, idx := range wg.idx {
+		if wg.w[n] == 0 {
+			continue
+		}
+		v := values[idx]
+		if v == missing || v != v {
+			return missing
+		}
+		sum += wg.w[n] * float64(v)
+	}
+	return float32(sum)
+}
+
+// Apply interpolates values, defined on the source grid,
+// onto the target points. Target points outside of the
+// source grid, or whose surrounding source cells contain
+// missing, are set to missing.
+func (b *Bilinear) Apply(values []float32, missing float32) ([]float32, error) {
+	if len(values) != b.srcLen {
+		return nil, fmt.Errorf("field has %d values, expected %d", len(values), b.srcLen)
+	}
+
+	result := make([]float32, len(b.weights))
+	for i, wg := range b.weights {
+		if !b.inside[i] {
+			result[i] = missing
+			continue
+		}
+		result[i] = wg.apply(values, missing)
+	}
+
+	return result, nil
+}
+
+// Sampler interpolates a single field, defined on a
+// Grid, at points known one at a time, as the stations
+// read from observations.
+type Sampler struct {
+	lat, lon axis
+	values   []float32
+	missing  float32
+}
+
+// NewSampler returns a Sampler of values, defined on
+// src, where missing marks points without a valid value.
+func NewSampler(src Grid, values []float32, missing float32) (*Sampler, error) {
+	lat, lon, err := src.axes()
+	if err != nil {
+		return nil, err
+	}
+	if len(values) != len(src.Lats)*len(src.Lons) {
+		return nil, fmt.Errorf("field has %d values, expected %d", len(values), len(src.Lats)*len(src.Lons))
+	}
+	return &Sampler{lat: lat, lon: lon, values: values, missing: missing}, nil
+}
+
+// At returns the value interpolated at lat, lon. ok is false
+// for points outside of the source grid, or whose surrounding
+// source cells contain missing.
+func (s *Sampler) At(lat, lon float64) (v float32, ok bool) {
+	wg, inside := weightAt(s.lat, s.lon, lat, lon)
+	if !inside {
+		return s.missing, false
+	}
+	v = wg.apply(s.values, s.missing)
+	return v, v != s.missing
+}
+
+// axis is a strictly monotonic
+// coordinate, stored ascending.
+type axis struct {
+	values     []float64
+	descending bool
+}
+
+func newAxis(values []float64) (axis, error) {
+	descending := values[1] < values[0]
+	ascending := make([]float64, len(values))
+	for i, v := range values {
+		if descending {
+			ascending[len(values)-1-i] = v
+		} else {
+			ascending[i] = v
+		}
+	}
+
+	for i := 1; i < len(ascending); i++ {
+		if ascending[i] <= ascending[i-1] {
+			return axis{}, fmt.Errorf("values are not strictly monotonic at index %d", i)
+		}
+	}
+
+	return axis{values: ascending, descending: descending}, nil
+}
+
+// locate returns the index i of the axis value
+// preceding v, in original order, and the fractional
+// distance of v from it towards value i+1. ok is false
+// if v falls outside of the axis.
+func (a axis) locate(v float64) (i int, t float64, ok bool) {
+	n := len(a.values)
+	if v < a.values[0] || v > a.values[n-1] {
+		return 0, 0, false
+	}
+
+	// index of the first value greater than v, in [1, n-1]
+	upper := sort.SearchFloat64s(a.values, v)
+	if upper < n && a.values[upper] == v {
+		upper++
+	}
+	if upper > n-1 {
+		upper = n - 1
+	}
+	lower := upper - 1
+	t = (v - a.values[lower]) / (a.values[upper] - a.values[lower])
+
+	if a.descending {
+		// index lower in ascending order is n-1-lower in
+		// original order, that follows n-1-upper.
+		return n - 1 - upper, 1 - t, true
+	}
+	return lower, t, true
+}
+
+// Crop returns the smallest part of g, and of values defined
+// on it, that still contains all points inside the given
+// bounding box together with their surrounding cells, so that
+// interpolation onto those points is unchanged.
+func (g Grid) Crop(values []float32, minLat, maxLat, minLon, maxLon float64) (Grid, []float32, error) {
+	if len(values) != len(g.Lats)*len(g.Lons) {
+		return Grid{}, nil, fmt.Errorf("field has %d values, expected %d", len(values), len(g.Lats)*len(g.Lons))
+	}
+	if len(g.Lats) < 2 || len(g.Lons) < 2 {
+		return g, values, nil
+	}
+
+	latAxis, err := newAxis(g.Lats)
+	if err != nil {
+		return Grid{}, nil, fmt.Errorf("invalid latitudes: %w", err)
+	}
+	lonAxis, err := newAxis(g.Lons)
+	if err != nil {
+		return Grid{}, nil, fmt.Errorf("invalid longitudes: %w", err)
+	}
+
+	latFrom, latTo := latAxis.span(minLat, maxLat)
+	lonFrom, lonTo := lonAxis.span(minLon, maxLon)
+
+	cropped := Grid{
+		Lats: g.Lats[latFrom : latTo+1],
+		Lons: g.Lons[lonFrom : lonTo+1],
+	}
+	croppedValues := make([]float32, 0, len(cropped.Lats)*len(cropped.Lons))
+	for y := latFrom; y <= latTo; y++ {
+		row := values[y*len(g.Lons) : (y+1)*len(g.Lons)]
+		croppedValues = append(croppedValues, row[lonFrom:lonTo+1]...)
+	}
+
+	return cropped, croppedValues, nil
+}
+
+// span returns the range of indices, in original order,
+// of the axis values between min and max, extended by
+// one value on each side. The range contains at least
+// two values.
+func (a axis) span(min, max float64) (from, to int) {
+	n := len(a.values)
+	lower := sort.SearchFloat64s(a.values, min) - 1
+	upper := sort.Search(n, func(i int) bool { return a.values[i] > max })
+
+	if lower < 0 {
+		lower = 0
+	}
+	if upper > n-1 {
+		upper = n - 1
+	}
+	if upper <= lower {
+		// the box lies outside of the axis
+		if lower >= n-1 {
+			lower = n - 2
+		}
+		upper = lower + 1
+	}
+
+	if a.descending {
+		return n - 1 - upper, n - 1 - lower
+	}
+	return lower, upper
+}
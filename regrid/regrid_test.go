@@ -0,0 +1,176 @@
+package regrid
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const missing = -9999
+
+// linear returns the values of f on grid.
+func linear(grid Grid, f func(lat, lon float64) float64) []float32 {
+	values := make([]float32, 0, len(grid.Lats)*len(grid.Lons))
+	for _, lat := range grid.Lats {
+		for _, lon := range grid.Lons {
+			values = append(values, float32(f(lat, lon)))
+		}
+	}
+	return values
+}
+
+func field(lat, lon float64) float64 {
+	return 2*lat + 3*lon + 1
+}
+
+func TestBilinearReproducesLinearFields(t *testing.T) {
+	grids := map[string]Grid{
+		"ascending":  {Lats: []float64{40, 41, 42, 43}, Lons: []float64{8, 9, 10}},
+		"descending": {Lats: []float64{43, 42, 41, 40}, Lons: []float64{8, 9, 10}},
+		"irregular":  {Lats: []float64{40, 40.5, 42, 43}, Lons: []float64{10, 9.5, 8}},
+	}
+
+	points := Points{
+		Rows: 2,
+		Cols: 3,
+		Lats: []float64{40, 40.25, 41.7, 42.5, 42.9, 43},
+		Lons: []float64{8, 8.1, 9.5, 9.99, 9.2, 10},
+	}
+
+	for name, grid := range grids {
+		b, err := NewBilinear(grid, points)
+		require.NoError(t, err, name)
+
+		result, err := b.Apply(linear(grid, field), missing)
+		require.NoError(t, err, name)
+		require.Len(t, result, points.Len())
+
+		for i := range result {
+			assert.InDelta(t, field(points.Lats[i], points.Lons[i]), result[i], 1e-4, "%s: point %d", name, i)
+		}
+	}
+}
+
+func TestBilinearOutsideSource(t *testing.T) {
+	grid := Grid{Lats: []float64{40, 41}, Lons: []float64{8, 9}}
+	points := Points{
+		Rows: 1,
+		Cols: 4,
+		Lats: []float64{39.9, 40.5, 41.1, 40.5},
+		Lons: []float64{8.5, 7.9, 8.5, 8.5},
+	}
+
+	b, err := NewBilinear(grid, points)
+	require.NoError(t, err)
+
+	result, err := b.Apply(linear(grid, field), missing)
+	require.NoError(t, err)
+	assert.Equal(t, []float32{missing, missing, missing, float32(field(40.5, 8.5))}, result)
+}
+
+func TestBilinearPropagatesMissing(t *testing.T) {
+	grid := Grid{Lats: []float64{40, 41, 42}, Lons: []float64{8, 9}}
+	values := []float32{
+		1, 2,
+		3, missing,
+		5, 6,
+	}
+	points := Points{
+		Rows: 1,
+		Cols: 3,
+		Lats: []float64{40.5, 41.5, 42},
+		Lons: []float64{8.5, 8.5, 8.5},
+	}
+
+	b, err := NewBilinear(grid, points)
+	require.NoError(t, err)
+
+	result, err := b.Apply(values, missing)
+	require.NoError(t, err)
+	// the last point lies on the northern edge, where
+	// the missing value has no weight.
+	assert.Equal(t, []float32{missing, missing, 5.5}, result)
+}
+
+func TestBilinearErrors(t *testing.T) {
+	_, err := NewBilinear(Grid{Lats: []float64{40}, Lons: []float64{8, 9}}, Points{})
+	assert.Error(t, err)
+
+	_, err = NewBilinear(Grid{Lats: []float64{40, 41, 40.5}, Lons: []float64{8, 9}}, Points{})
+	assert.Error(t, err)
+
+	_, err = NewBilinear(Grid{Lats: []float64{40, 41}, Lons: []float64{8, 9}}, Points{Rows: 2, Cols: 2, Lats: []float64{40}})
+	assert.Error(t, err)
+
+	b, err := NewBilinear(Grid{Lats: []float64{40, 41}, Lons: []float64{8, 9}}, Points{})
+	require.NoError(t, err)
+	_, err = b.Apply([]float32{1, 2, 3}, missing)
+	assert.Error(t, err)
+}
+
+func TestCrop(t *testing.T) {
+	grids := map[string]Grid{
+		"ascending":  {Lats: []float64{40, 41, 42, 43, 44}, Lons: []float64{8, 9, 10, 11}},
+		"descending": {Lats: []float64{44, 43, 42, 41, 40}, Lons: []float64{11, 10, 9, 8}},
+	}
+	points := Points{
+		Rows: 1,
+		Cols: 3,
+		Lats: []float64{41.2, 41.5, 42},
+		Lons: []float64{9.1, 9.5, 9.9},
+	}
+
+	for name, grid := range grids {
+		values := linear(grid, field)
+		cropped, croppedValues, err := grid.Crop(values, 41.2, 42, 9.1, 9.9)
+		require.NoError(t, err, name)
+
+		assert.ElementsMatch(t, []float64{41, 42, 43}, cropped.Lats, name)
+		assert.ElementsMatch(t, []float64{9, 10}, cropped.Lons, name)
+		assert.Equal(t, linear(cropped, field), croppedValues, name)
+
+		full, err := NewBilinear(grid, points)
+		require.NoError(t, err)
+		expected, err := full.Apply(values, missing)
+		require.NoError(t, err)
+
+		part, err := NewBilinear(cropped, points)
+		require.NoError(t, err)
+		actual, err := part.Apply(croppedValues, missing)
+		require.NoError(t, err)
+
+		assert.Equal(t, expected, actual, name)
+	}
+
+	// a box larger than the grid leaves it unchanged
+	grid := grids["ascending"]
+	cropped, _, err := grid.Crop(linear(grid, field), 0, 90, -180, 180)
+	require.NoError(t, err)
+	assert.Equal(t, grid, cropped)
+}
+
+func TestSampler(t *testing.T) {
+	grid := Grid{Lats: []float64{45, 44, 43}, Lons: []float64{8, 9, 10, 11}}
+	values := linear(grid, field)
+	values[len(values)-1] = missing
+
+	s, err := NewSampler(grid, values, missing)
+	require.NoError(t, err)
+
+	v, ok := s.At(44.5, 8.25)
+	assert.True(t, ok)
+	assert.InDelta(t, field(44.5, 8.25), v, 1e-4)
+
+	_, ok = s.At(43.5, 10.5)
+	assert.False(t, ok)
+
+	_, ok = s.At(46, 8.5)
+	assert.False(t, ok)
+
+	_, err = NewSampler(grid, values[1:], missing)
+	assert.Error(t, err)
+
+	_, err = NewSampler(Grid{Lats: []float64{40}, Lons: []float64{8, 9}}, nil, missing)
+	assert.Error(t, err)
+}
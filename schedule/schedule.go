@@ -0,0 +1,59 @@
+// Package schedule defines the data assimilation
+// cycles of a WRFDA run.
+package schedule
+
+import (
+	"fmt"
+	"time"
+)
+
+// Cycles describes Count assimilation cycles, Interval apart,
+// ending at the start date of a WRFDA run. Being D the start
+// date, the default 3 cycles every 3 hours are at D-6H, D-3H
+// and D.
+type Cycles struct {
+	Count    int           `yaml:"count"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+// DefaultCycles are 3 cycles every 3 hours.
+var DefaultCycles = Cycles{Count: 3, Interval: 3 * time.Hour}
+
+// OrDefault returns DefaultCycles if c is zero, otherwise c.
+func (c Cycles) OrDefault() Cycles {
+	if c == (Cycles{}) {
+		return DefaultCycles
+	}
+	return c
+}
+
+// Validate checks that c describes at least one cycle,
+// and that cycles are a positive number of hours apart,
+// since files of each cycle are named after its hour.
+func (c Cycles) Validate() error {
+	if c.Count < 1 {
+		return fmt.Errorf("invalid cycles count %d", c.Count)
+	}
+	if c.Count > 1 && c.Interval <= 0 {
+		return fmt.Errorf("invalid cycles interval %s", c.Interval)
+	}
+	if c.Interval%time.Hour != 0 {
+		return fmt.Errorf("cycles interval %s is not a multiple of 1h", c.Interval)
+	}
+	return nil
+}
+
+// Dates returns the dates of all cycles of a run
+// starting at start, oldest first: the date of
+// cycle n is at index n-1, the last one is start.
+func (c Cycles) Dates(start time.Time) []time.Time {
+	dates := make([]time.Time, c.Count)
+	for n := range dates {
+		dates[n] = start.Add(-time.Duration(c.Count-1-n) * c.Interval)
+	}
+	return dates
+}
+
+func (c Cycles) String() string {
+	return fmt.Sprintf("%d cycles every %s", c.Count, c.Interval)
+}
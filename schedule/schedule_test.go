@@ -0,0 +1,43 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDates(t *testing.T) {
+	start := time.Date(2020, 6, 10, 0, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, []time.Time{
+		start.Add(-6 * time.Hour),
+		start.Add(-3 * time.Hour),
+		start,
+	}, DefaultCycles.Dates(start))
+
+	hourly := Cycles{Count: 4, Interval: time.Hour}
+	assert.Equal(t, []time.Time{
+		start.Add(-3 * time.Hour),
+		start.Add(-2 * time.Hour),
+		start.Add(-1 * time.Hour),
+		start,
+	}, hourly.Dates(start))
+
+	assert.Equal(t, []time.Time{start}, Cycles{Count: 1}.Dates(start))
+}
+
+func TestOrDefault(t *testing.T) {
+	assert.Equal(t, DefaultCycles, Cycles{}.OrDefault())
+	assert.Equal(t, Cycles{Count: 1}, Cycles{Count: 1}.OrDefault())
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, DefaultCycles.Validate())
+	assert.NoError(t, Cycles{Count: 1}.Validate())
+	assert.Error(t, Cycles{}.Validate())
+	assert.Error(t, Cycles{Count: 2}.Validate())
+	assert.Error(t, Cycles{Count: 2, Interval: -time.Hour}.Validate())
+	assert.Error(t, Cycles{Count: 2, Interval: 30 * time.Minute}.Validate())
+	assert.Error(t, Cycles{Count: 1, Interval: 90 * time.Minute}.Validate())
+}
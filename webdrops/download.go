@@ -0,0 +1,157 @@
+package webdrops
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Download contains information on
+// a file downloaded by DownloadFile.
+type Download struct {
+	// Path of the downloaded file
+	Path string
+	// Size in bytes of the downloaded file
+	Size int64
+	// SHA256 is the hex encoded checksum of the downloaded file
+	SHA256 string
+}
+
+func (d Download) String() string {
+	return fmt.Sprintf("%s (%d bytes, sha256 %s)", d.Path, d.Size, d.SHA256)
+}
+
+// DoGetTo performs a GET request to url, streaming the
+// response body to w, and returns the number of bytes written.
+// Failed attempts are retried as in DoGet, as long as nothing
+// has been written to w yet.
+func (sess *Session) DoGetTo(ctx context.Context, url string, expectedContentType string, w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	err := sess.withRetry(ctx, url, func() error {
+		_, err := sess.get(ctx, url, expectedContentType, cw)
+		if err != nil && cw.n > 0 {
+			return &permanentError{fmt.Errorf("download interrupted after %d bytes: %w", cw.n, err)}
+		}
+		return err
+	})
+	return cw.n, err
+}
+
+// DownloadFile performs a GET request to url, streaming the
+// response body to a temporary file in the same directory of
+// targetPath, that is renamed to targetPath when the download
+// succeeds. Failed attempts are retried as in DoGet, every time
+// restarting the download from scratch. The directory of targetPath
+// is created if it doesn't exists.
+func (sess *Session) DownloadFile(ctx context.Context, url string, expectedContentType string, targetPath string) (Download, error) {
+	file, err := createAtomicFile(targetPath)
+	if err != nil {
+		return Download{}, err
+	}
+	defer file.Abort()
+
+	err = sess.withRetry(ctx, url, func() error {
+		if err := file.Reset(); err != nil {
+			return &permanentError{err}
+		}
+		_, err := sess.get(ctx, url, expectedContentType, file)
+		return err
+	})
+	if err != nil {
+		return Download{}, err
+	}
+
+	return file.Commit()
+}
+
+// atomicFile is a temporary file that is
+// renamed to its target path on Commit.
+// It computes size and checksum of written content.
+type atomicFile struct {
+	f        *os.File
+	target   string
+	checksum hash.Hash
+	size     int64
+}
+
+func createAtomicFile(targetPath string) (*atomicFile, error) {
+	dir := filepath.Dir(targetPath)
+	if err := os.MkdirAll(dir, os.FileMode(0755)); err != nil {
+		return nil, fmt.Errorf("error creating directory `%s`: %w", dir, err)
+	}
+
+	f, err := os.CreateTemp(dir, "."+filepath.Base(targetPath)+".*.part")
+	if err != nil {
+		return nil, fmt.Errorf("error creating temporary file: %w", err)
+	}
+
+	return &atomicFile{
+		f:        f,
+		target:   targetPath,
+		checksum: sha256.New(),
+	}, nil
+}
+
+func (file *atomicFile) Write(p []byte) (int, error) {
+	n, err := file.f.Write(p)
+	file.checksum.Write(p[:n])
+	file.size += int64(n)
+	return n, err
+}
+
+// Reset discards all content written so far.
+func (file *atomicFile) Reset() error {
+	if _, err := file.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := file.f.Truncate(0); err != nil {
+		return err
+	}
+	file.checksum.Reset()
+	file.size = 0
+	return nil
+}
+
+// Commit closes the temporary file and renames it to its target path.
+func (file *atomicFile) Commit() (Download, error) {
+	tmpPath := file.f.Name()
+	if err := file.f.Close(); err != nil {
+		return Download{}, fmt.Errorf("error saving `%s`: %w", tmpPath, err)
+	}
+	if err := os.Chmod(tmpPath, os.FileMode(0644)); err != nil {
+		return Download{}, fmt.Errorf("error saving `%s`: %w", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, file.target); err != nil {
+		return Download{}, fmt.Errorf("error renaming `%s` to `%s`: %w", tmpPath, file.target, err)
+	}
+
+	return Download{
+		Path:   file.target,
+		Size:   file.size,
+		SHA256: hex.EncodeToString(file.checksum.Sum(nil)),
+	}, nil
+}
+
+// Abort closes and removes the temporary file,
+// if it has not been committed.
+func (file *atomicFile) Abort() {
+	file.f.Close()
+	os.Remove(file.f.Name())
+}
+
+// countingWriter counts bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,73 @@
+package webdrops_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cima-lexis/lexisdn/webdrops/webdropstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var radarDate = time.Date(2020, 6, 10, 0, 5, 0, 0, time.UTC)
+
+func TestDownloadFile(t *testing.T) {
+	expected, err := fs.ReadFile(webdropstest.Fixtures(), "coverages/data.nc")
+	require.NoError(t, err)
+	checksum := sha256.Sum256(expected)
+
+	srv, sess := newRetrySession(t, fastRetry)
+	srv.Fail(1, http.StatusServiceUnavailable, nil)
+
+	target := filepath.Join(t.TempDir(), "radars", "CAPPI2.nc")
+	download, err := sess.RadarData(context.Background(), radarDate, "CAPPI2", target)
+	require.NoError(t, err)
+
+	assert.Equal(t, target, download.Path)
+	assert.Equal(t, int64(len(expected)), download.Size)
+	assert.Equal(t, hex.EncodeToString(checksum[:]), download.SHA256)
+
+	actual, err := ioutil.ReadFile(target)
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+
+	// no temporary files are left behind
+	entries, err := ioutil.ReadDir(filepath.Dir(target))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestFailedDownloadLeavesNoFile(t *testing.T) {
+	srv, sess := newRetrySession(t, fastRetry)
+	srv.Fail(3, http.StatusServiceUnavailable, nil)
+
+	dir := t.TempDir()
+	_, err := sess.RadarData(context.Background(), radarDate, "CAPPI2", filepath.Join(dir, "CAPPI2.nc"))
+	assert.Error(t, err)
+
+	entries, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 0)
+}
+
+func TestDoGetTo(t *testing.T) {
+	expected, err := fs.ReadFile(webdropstest.Fixtures(), "sensors/map/TERMOMETRO.nc")
+	require.NoError(t, err)
+
+	srv, sess := newRetrySession(t, fastRetry)
+
+	var buf bytes.Buffer
+	url := srv.URL + "/sensors/map/TERMOMETRO/?from=202006090000&to=202006091200"
+	n, err := sess.DoGetTo(context.Background(), url, "application/octet-stream", &buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(expected)), n)
+	assert.Equal(t, expected, buf.Bytes())
+}
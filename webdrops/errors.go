@@ -0,0 +1,146 @@
+package webdrops
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	// ErrUnauthorized is matched by errors caused by
+	// wrong credentials or invalid tokens (HTTP 401 and 403).
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrNotFound is matched by errors caused by
+	// a resource not found on the server (HTTP 404), e.g.
+	// a radar not published yet.
+	ErrNotFound = errors.New("not found")
+)
+
+// HTTPError is returned when the server
+// responds with a status different from 200.
+type HTTPError struct {
+	StatusCode int
+	Status     string
+	Body       string
+	// RetryAfter contains the value of the Retry-After
+	// response header, or zero if the header is missing.
+	RetryAfter time.Duration
+}
+
+func newHTTPError(res *http.Response) *HTTPError {
+	body, _ := io.ReadAll(res.Body)
+	return &HTTPError{
+		StatusCode: res.StatusCode,
+		Status:     res.Status,
+		Body:       string(body),
+		RetryAfter: parseRetryAfter(res.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("error in response: HTTP status: %s\nResponse Body:\n%s", e.Status, e.Body)
+}
+
+// Is allows to match e with ErrUnauthorized
+// and ErrNotFound using errors.Is
+func (e *HTTPError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	}
+	return false
+}
+
+// Temporary returns true if the request
+// that caused e could succeed if repeated later.
+func (e *HTTPError) Temporary() bool {
+	return e.StatusCode >= 500 ||
+		e.StatusCode == http.StatusTooManyRequests ||
+		e.StatusCode == http.StatusRequestTimeout
+}
+
+// ContentTypeError is returned when the server responds
+// with status 200, but with an unexpected content type.
+type ContentTypeError struct {
+	Expected string
+	Got      string
+	Body     string
+}
+
+func (e *ContentTypeError) Error() string {
+	return fmt.Sprintf("Response with status 200, but content type different than expected.\n expecting `%s`, got `%s`\nResponse Body:\n%s", e.Expected, e.Got, e.Body)
+}
+
+// permanentError wraps an error that
+// must not be retried.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// parseRetryAfter parses the value of a Retry-After
+// header, that can contain either a number of seconds
+// or an HTTP date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// isRetryable returns true if err is caused by
+// a timeout, a network failure, or a temporary HTTP error.
+// Wrong credentials, not found resources, content type
+// mismatches and canceled contexts are considered permanent.
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var permanentErr *permanentError
+	if errors.As(err, &permanentErr) {
+		return false
+	}
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.Temporary()
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	return errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// retryAfter returns the delay requested
+// by the server in err, if any.
+func retryAfter(err error) time.Duration {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.RetryAfter
+	}
+	return 0
+}
@@ -2,161 +2,191 @@ package webdrops
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
-	"time"
+	"strconv"
+	"strings"
 )
 
-const maxRetry = 5
+// DoGet performs a GET request to url, retrying it
+// accordingly to the session RetryPolicy. Only timeouts,
+// network failures and temporary HTTP errors are retried.
+// Returned errors can be matched against ErrUnauthorized and
+// ErrNotFound, or inspected as *HTTPError and *ContentTypeError.
+func (sess *Session) DoGet(ctx context.Context, url string, expectedContentType string) ([]byte, error) {
+	var body bytes.Buffer
+	err := sess.withRetry(ctx, url, func() error {
+		body.Reset()
+		_, err := sess.get(ctx, url, expectedContentType, &body)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return body.Bytes(), nil
+}
 
-// DoGet ...
-func (sess *Session) DoGet(url string, expectedContentType string) (res []byte, err error) {
-	//fmt.Println("GET", url)
-	for i := time.Duration(0); i < maxRetry; i++ {
-		err = sess.refresh()
-		if err != nil {
-			time.Sleep(i * 1 * time.Second)
-			continue
-		}
+// withRetry calls attempt until it succeeds, retrying
+// it accordingly to the session RetryPolicy. The token is
+// refreshed, if needed, before every attempt.
+func (sess *Session) withRetry(ctx context.Context, url string, attempt func() error) error {
+	policy := sess.retry
+	start := sess.now()
 
-		res, err = sess.get(url, expectedContentType)
+	for n := 1; ; n++ {
+		err := sess.refresh(ctx)
 		if err == nil {
-			return
+			err = attempt()
+			if err == nil {
+				return nil
+			}
 		}
-		fmt.Fprintf(os.Stderr, "An error occurred while getting from %s:%s\n", url, err.Error())
-		time.Sleep(i * 1 * time.Second)
 
-	}
+		if ctx.Err() != nil || !isRetryable(err) {
+			return err
+		}
 
-	return
-}
+		if n >= policy.MaxAttempts {
+			return fmt.Errorf("giving up after %d attempts: %w", n, err)
+		}
 
-/*
-// DoPost ...
-func (sess *Session) DoPost(url string, body interface{}) (res []byte, err error) {
-	//fmt.Println("POST", url)
-	for i := time.Duration(0); i < maxRetry; i++ {
-		err = sess.refresh()
-		if err != nil {
-			time.Sleep(i * 1 * time.Second)
-			continue
+		wait := policy.backoff(n, retryAfter(err))
+		if policy.Deadline > 0 && sess.now().Add(wait).Sub(start) > policy.Deadline {
+			return fmt.Errorf("giving up after %d attempts, deadline of %s exceeded: %w", n, policy.Deadline, err)
 		}
 
-		res, err = sess.post(url, body)
-		if err == nil {
-			return
+		fmt.Fprintf(os.Stderr, "An error occurred while requesting %s:%s\nRetrying in %s\n", url, err.Error(), wait)
+		if err := sleep(ctx, wait); err != nil {
+			return err
 		}
+	}
+}
 
-		fmt.Fprintf(os.Stderr, "an error occurred while posting to %s:%s\n", url, err.Error())
-		time.Sleep(i * 1 * time.Second)
+// DoPost performs a POST request to url, with body
+// encoded as JSON, retrying it as DoGet does.
+func (sess *Session) DoPost(ctx context.Context, url string, body interface{}, expectedContentType string) ([]byte, error) {
+	bodyJ, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("error converting body to JSON: %w", err)
+	}
 
+	var resp bytes.Buffer
+	err = sess.withRetry(ctx, url, func() error {
+		resp.Reset()
+		_, err := sess.do(ctx, "POST", url, bodyJ, expectedContentType, &resp)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
+	return resp.Bytes(), nil
+}
 
-	return
+// get performs a single GET request to url,
+// and copies the response body to w.
+func (sess *Session) get(ctx context.Context, url string, expectedContentType string, w io.Writer) (int64, error) {
+	return sess.do(ctx, "GET", url, nil, expectedContentType, w)
 }
-*/
-//"application/json"
 
-func (sess *Session) get(url string, expectedContentType string) ([]byte, error) {
-	req, err := http.NewRequest("GET", url, nil)
+// do performs a single request to url, with an optional
+// JSON body, and copies the response body to w.
+func (sess *Session) do(ctx context.Context, method, url string, body []byte, expectedContentType string, w io.Writer) (int64, error) {
+	var bodyR io.Reader
+	if body != nil {
+		bodyR = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, bodyR)
 	if err != nil {
-		return nil, fmt.Errorf("error creating HTTP request: %w", err)
+		return 0, fmt.Errorf("error creating HTTP request: %w", err)
+	}
+	req.Header.Add("Authorization", "Bearer "+sess.accessToken())
+	if body != nil {
+		req.Header.Add("Content-Type", "application/json")
 	}
-	req.Header.Add("Authorization", "Bearer "+sess.Token)
 	//req.Header.Set("Accept-Encoding", "gzip, deflate")
 
 	res, err := sess.client.Do(req)
 	if err != nil {
-		sbody := "<EMPTY>"
-		if res != nil && res.Body != nil {
-			defer res.Body.Close()
-			body, _ := ioutil.ReadAll(res.Body)
-			sbody = string(body)
-		}
-		return nil, fmt.Errorf("error submitting HTTP request: %w\nResponse Body:\n%s", err, sbody)
+		return 0, fmt.Errorf("error submitting HTTP request: %w", err)
 	}
-	if res.StatusCode != http.StatusOK {
-		defer res.Body.Close()
-		body, _ := ioutil.ReadAll(res.Body)
-		//fmt.Println(string(body))
+	defer res.Body.Close()
 
-		return nil, fmt.Errorf("error in response: HTTP status: %s\nResponse Body:\n%s", res.Status, string(body))
+	if res.StatusCode != http.StatusOK {
+		return 0, newHTTPError(res)
 	}
 	encoding := res.Header.Get("Content-Type")
 	if encoding != expectedContentType {
 		var body string
 		if encoding == "plain/text" || encoding == "text/html" || encoding == "application/json" {
-			defer res.Body.Close()
 			b, _ := ioutil.ReadAll(res.Body)
 			body = string(b)
 		}
-		return nil, fmt.Errorf("Response with status 200, but content type different than expected.\n expecting `%s`, got `%s`\nResponse Body:\n%s", expectedContentType, encoding, body)
+		return 0, &ContentTypeError{
+			Expected: expectedContentType,
+			Got:      encoding,
+			Body:     body,
+		}
 	}
 
 	bodybuf := bufio.NewReaderSize(res.Body, 10*1024)
 
-	defer res.Body.Close()
-	/*
-		respWriter := bytes.NewBuffer([]byte{})
-		bodyResp := bufio.NewWriterSize(respWriter, 10*1024)
-		_, err = io.Copy(bodyResp, bodybuf)
-		body := respWriter.Bytes()
-	*/
-	body, err := io.ReadAll(bodybuf)
-
+	n, err := io.Copy(w, bodybuf)
 	if err != nil {
-		return nil, fmt.Errorf("error downloading HTTP response: %w", err)
+		return n, fmt.Errorf("error downloading HTTP response: %w", err)
 	}
-	return body, nil
+	return n, nil
 }
 
-/*
-func (sess *Session) post(url string, body interface{}) ([]byte, error) {
-	bodyJ, err := json.Marshal(body)
-	if err != nil {
-		return nil, fmt.Errorf("error converting body to JSON: %w", err)
-	}
-	bodyR := bytes.NewBuffer(bodyJ)
+// Domain is
+type Domain struct {
+	MinLat, MinLon, MaxLat, MaxLon float64
+}
 
-	req, err := http.NewRequest("POST", url, bodyR)
-	if err != nil {
-		return nil, fmt.Errorf("error creating HTTP request: %w", err)
+// ParseDomain returns the Domain described by s,
+// that must contains MinLat,MaxLat,MinLon,MaxLon values,
+// in that sequence, separated by commas and
+// represented as floats.
+func ParseDomain(s string) (Domain, error) {
+	coords := strings.Split(s, ",")
+	if len(coords) != 4 {
+		return Domain{}, fmt.Errorf("invalid domain `%s`: expected MinLat,MaxLat,MinLon,MaxLon", s)
 	}
-	req.Header.Add("Authorization", "Bearer "+sess.Token)
-	req.Header.Add("Content-Type", "application/json")
-	//req.Header.Set("Accept-Encoding", "gzip, deflate")
 
-	res, err := sess.client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("error submitting HTTP request: %w", err)
-	}
-	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("error submitting request: HTTP status: %s", res.Status)
+	var values [4]float64
+	for i, coord := range coords {
+		value, err := strconv.ParseFloat(strings.TrimSpace(coord), 64)
+		if err != nil {
+			return Domain{}, fmt.Errorf("invalid domain `%s`: %w", s, err)
+		}
+		values[i] = value
 	}
-	//fmt.Println("Content-Encoding: ", res.Header.Get("Content-Encoding"))
 
-	bodybuf := bufio.NewReaderSize(res.Body, 10*1024)
-
-	defer res.Body.Close()
-	// respWriter := bytes.NewBuffer([]byte{})
-	// bodyRespW := bufio.NewWriterSize(respWriter, 10*1024)
-	// _, err = io.Copy(bodyRespW, bodybuf)
-	// bodyResp := respWriter.Bytes()
-	bodyResp, err := io.ReadAll(bodybuf)
+	return Domain{
+		MinLat: values[0],
+		MaxLat: values[1],
+		MinLon: values[2],
+		MaxLon: values[3],
+	}, nil
+}
 
-	if err != nil {
-		return nil, fmt.Errorf("error downloading: HTTP response: %w", err)
-	}
-	return bodyResp , nil
+// String returns the domain in the same
+// format accepted by ParseDomain.
+func (d Domain) String() string {
+	return fmt.Sprintf("%g,%g,%g,%g", d.MinLat, d.MaxLat, d.MinLon, d.MaxLon)
 }
-*/
 
-// Domain is
-type Domain struct {
-	MinLat, MinLon, MaxLat, MaxLon float64
+// Contains returns true if the point at
+// given coordinates falls inside the domain.
+func (d Domain) Contains(lat, lng float64) bool {
+	return lat >= d.MinLat && lat <= d.MaxLat &&
+		lng >= d.MinLon && lng <= d.MaxLon
 }
 
 /*// ItalyDomain ...
@@ -1,94 +1,206 @@
 package webdrops
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"math"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cima-lexis/lexisdn/config"
 )
 
-// Session ...
+// Session is an authenticated session on webdrops server.
+// A Session is safe for concurrent use by multiple goroutines:
+// tokens are refreshed under a mutex, so that concurrent
+// requests share a single login and never race on refresh.
+// A Session must not be copied after first use.
 type Session struct {
-	Token        string `json:"access_token"`
-	RefreshToken string `json:"refresh_token"`
-	ExpiresIn    uint64 `json:"expires_in"`
-	ClientID     string
-	RefreshedAt  time.Time
-	client       *http.Client
+	Token        string
+	RefreshToken string
+	// ExpiresIn is the lifetime in seconds of Token
+	ExpiresIn uint64
+	// RefreshExpiresIn is the lifetime in seconds of RefreshToken.
+	// Zero means the refresh token never expires.
+	RefreshExpiresIn uint64
+	ClientID         string
+	// RefreshedAt is the instant Token and RefreshToken were issued.
+	RefreshedAt      time.Time
+	client           *http.Client
+	clock            func() time.Time
+	retry            RetryPolicy
+	sensorsBatchSize int
+	url              string
+	authURL          string
+	user             string
+	password         string
+	mu               sync.Mutex
 }
 
-// Login ...
-func (sess *Session) Login() error {
+// SessionOptions contains all settings
+// used by NewSession to build a Session.
+type SessionOptions struct {
+	// Transport is the http.RoundTripper used
+	// for every request of the session. When nil,
+	// a default transport is used.
+	Transport http.RoundTripper
+	// URL is the base URL for all webdrops endpoints.
+	URL string
+	// AuthURL is the URL for KeyCloak authentication.
+	AuthURL  string
+	ClientID string
+	User     string
+	Password string
+	// Clock returns the current time. It's used to check
+	// tokens expiration. When nil, time.Now is used.
+	Clock func() time.Time
+	// Retry is the policy used to retry failed requests.
+	// When MaxAttempts is zero, DefaultRetryPolicy is used.
+	Retry RetryPolicy
+	// SensorsBatchSize is the maximum number of sensors IDs
+	// posted with a single request by SensorsData.
+	// When zero, DefaultSensorsBatchSize is used.
+	SensorsBatchSize int
+}
 
-	data := url.Values{}
-	data.Set("client_id", config.Config.ClientID)
-	data.Set("grant_type", "password")
-	data.Set("password", config.Config.Password)
-	data.Set("username", config.Config.User)
-
-	t := &http.Transport{
-		Dial: (&net.Dialer{
-			Timeout:   60 * time.Minute,
-			KeepAlive: 30 * time.Minute,
-		}).Dial,
-		TLSHandshakeTimeout: 6 * time.Minute,
-	}
-	c := &http.Client{
-		Transport: t,
+// DefaultSessionOptions returns a SessionOptions
+// filled with values read from config.Config and
+// with a nil Transport.
+func DefaultSessionOptions() SessionOptions {
+	return SessionOptions{
+		URL:      config.Config.URL,
+		AuthURL:  config.Config.AuthURL,
+		ClientID: config.Config.ClientID,
+		User:     config.Config.User,
+		Password: config.Config.Password,
 	}
+}
 
-	sess.client = c
-	req, err := http.NewRequest("POST", config.Config.AuthURL, strings.NewReader(data.Encode()))
-	if err != nil {
-		return fmt.Errorf("error creating HTTP request: %w", err)
-	}
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+// NewSession returns a new Session configured
+// with given options. The session is not logged
+// in: call Login before using it.
+func NewSession(opts SessionOptions) *Session {
+	sess := &Session{}
+	sess.configure(opts)
+	return sess
+}
 
-	res, err := sess.client.Do(req)
-	if err != nil {
-		return fmt.Errorf("error submitting HTTP request: %w", err)
+func (sess *Session) configure(opts SessionOptions) {
+	t := opts.Transport
+	if t == nil {
+		// downloads can last long, so there's no overall timeout:
+		// requests are canceled through their context.
+		t = &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			TLSHandshakeTimeout: 30 * time.Second,
+		}
 	}
 
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("HTTP error: %s", res.Status)
+	sess.client = &http.Client{
+		Transport: t,
 	}
+	sess.url = opts.URL
+	sess.authURL = opts.AuthURL
+	sess.ClientID = opts.ClientID
+	sess.user = opts.User
+	sess.password = opts.Password
+	sess.clock = opts.Clock
+	sess.retry = opts.Retry
+	if sess.retry.MaxAttempts == 0 {
+		sess.retry = DefaultRetryPolicy
+	}
+	sess.sensorsBatchSize = opts.SensorsBatchSize
+}
 
-	defer res.Body.Close()
+// Login performs a password login and
+// sets the session tokens accordingly.
+func (sess *Session) Login(ctx context.Context) error {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.login(ctx)
+}
 
-	body, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return fmt.Errorf("error downloading HTTP response: %w", err)
+func (sess *Session) login(ctx context.Context) error {
+	if sess.client == nil {
+		// zero value Session: use global configuration
+		sess.configure(DefaultSessionOptions())
 	}
-	sess.ClientID = config.Config.ClientID
-	err = json.Unmarshal(body, sess)
-	if err != nil {
-		return fmt.Errorf("error parsing HTTP JSON response: %w", err)
-	}
-	sess.RefreshedAt = time.Now()
-	sess.ExpiresIn = 30
-	return err
+
+	data := url.Values{}
+	data.Set("client_id", sess.ClientID)
+	data.Set("grant_type", "password")
+	data.Set("password", sess.password)
+	data.Set("username", sess.user)
+
+	return sess.requestToken(ctx, data)
 }
 
-func (sess *Session) refresh() error {
-	secondsPassedFromToken := uint64(math.Floor(time.Since(sess.RefreshedAt).Seconds()))
-	//fmt.Println("passed", secondsPassedFromToken, "of", sess.ExpiresIn)
-	if secondsPassedFromToken < sess.ExpiresIn/2 {
+// refresh renews the access token when more than half of
+// its lifetime is passed. It uses the refresh token
+// if it's not expired, and falls back to a password login
+// otherwise or if the server reject the refresh token.
+func (sess *Session) refresh(ctx context.Context) error {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	if sess.client == nil {
+		return sess.login(ctx)
+	}
+
+	passed := sess.now().Sub(sess.RefreshedAt)
+	if passed < time.Duration(sess.ExpiresIn)*time.Second/2 {
 		return nil
 	}
 
+	// a RefreshExpiresIn of zero means the refresh token never expires
+	refreshExpired := sess.RefreshExpiresIn > 0 &&
+		passed >= time.Duration(sess.RefreshExpiresIn)*time.Second
+	if sess.RefreshToken == "" || refreshExpired {
+		return sess.login(ctx)
+	}
+
 	data := url.Values{}
-	data.Set("client_id", config.Config.ClientID)
+	data.Set("client_id", sess.ClientID)
 	data.Set("grant_type", "refresh_token")
 	data.Set("refresh_token", sess.RefreshToken)
 
-	req, err := http.NewRequest("POST", config.Config.AuthURL, strings.NewReader(data.Encode()))
+	if err := sess.requestToken(ctx, data); err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Token refresh failed, logging in again: %s\n", err.Error())
+		return sess.login(ctx)
+	}
+
+	return nil
+}
+
+// tokenResponse is the body of KeyCloak token endpoint responses.
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	RefreshToken     string `json:"refresh_token"`
+	ExpiresIn        uint64 `json:"expires_in"`
+	RefreshExpiresIn uint64 `json:"refresh_expires_in"`
+}
+
+// requestToken posts data to the KeyCloak token endpoint
+// and updates the session with tokens received.
+func (sess *Session) requestToken(ctx context.Context, data url.Values) error {
+	// the lifetime of tokens starts before the request is sent,
+	// so that network latency can only make them look older.
+	requestedAt := sess.now()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", sess.authURL, strings.NewReader(data.Encode()))
 	if err != nil {
 		return fmt.Errorf("error creating HTTP request: %w", err)
 	}
@@ -99,22 +211,42 @@ func (sess *Session) refresh() error {
 		return fmt.Errorf("error submitting HTTP request: %w", err)
 	}
 
+	defer res.Body.Close()
+
 	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("HTTP error: %s", res.Status)
+		return newHTTPError(res)
 	}
 
-	defer res.Body.Close()
-
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
 		return fmt.Errorf("error downloading HTTP response: %w", err)
 	}
-	//fmt.Printf("Before: \nTk:%s\nRefTk:%s\n", sess.Token, sess.RefreshToken)
-	ret := json.Unmarshal(body, sess)
-	//fmt.Printf("After: \nTk:%s\nRefTk:%s\n", sess.Token, sess.RefreshToken)
-	sess.RefreshedAt = time.Now()
-	sess.ExpiresIn = 30
 
-	return ret
+	var token tokenResponse
+	err = json.Unmarshal(body, &token)
+	if err != nil {
+		return fmt.Errorf("error parsing HTTP JSON response: %w", err)
+	}
 
+	sess.Token = token.AccessToken
+	sess.RefreshToken = token.RefreshToken
+	sess.ExpiresIn = token.ExpiresIn
+	sess.RefreshExpiresIn = token.RefreshExpiresIn
+	sess.RefreshedAt = requestedAt
+
+	return nil
+}
+
+// accessToken returns the current access token.
+func (sess *Session) accessToken() string {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.Token
+}
+
+func (sess *Session) now() time.Time {
+	if sess.clock == nil {
+		return time.Now()
+	}
+	return sess.clock()
 }
@@ -0,0 +1,134 @@
+package webdrops_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cima-lexis/lexisdn/webdrops"
+	"github.com/cima-lexis/lexisdn/webdrops/webdropstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a manually advanced clock.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestSession(t *testing.T) (*webdropstest.Server, *webdrops.Session, *fakeClock) {
+	srv := webdropstest.NewServer(nil)
+	t.Cleanup(srv.Close)
+
+	clock := &fakeClock{now: time.Date(2020, 6, 10, 0, 0, 0, 0, time.UTC)}
+	opts := srv.SessionOptions()
+	opts.Clock = clock.Now
+
+	sess := webdrops.NewSession(opts)
+	require.NoError(t, sess.Login(context.Background()))
+	return srv, sess, clock
+}
+
+func TestLoginReadsTokenLifetime(t *testing.T) {
+	srv, sess, clock := newTestSession(t)
+
+	assert.Equal(t, 1, srv.PasswordLogins())
+	assert.Equal(t, uint64(300), sess.ExpiresIn)
+	assert.Equal(t, uint64(1800), sess.RefreshExpiresIn)
+	assert.Equal(t, clock.Now(), sess.RefreshedAt)
+}
+
+func TestValidTokenIsNotRefreshed(t *testing.T) {
+	srv, sess, clock := newTestSession(t)
+
+	clock.Advance(149 * time.Second)
+	_, err := sess.SensorsList(context.Background(), "TERMOMETRO", webdrops.GroupDPC)
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, srv.PasswordLogins())
+	assert.Equal(t, 0, srv.Refreshes())
+}
+
+func TestTokenRefreshedAfterHalfLifetime(t *testing.T) {
+	srv, sess, clock := newTestSession(t)
+	token := sess.Token
+
+	clock.Advance(150 * time.Second)
+	_, err := sess.SensorsList(context.Background(), "TERMOMETRO", webdrops.GroupDPC)
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, srv.PasswordLogins())
+	assert.Equal(t, 1, srv.Refreshes())
+	assert.NotEqual(t, token, sess.Token)
+	assert.Equal(t, clock.Now(), sess.RefreshedAt)
+}
+
+func TestExpiredRefreshTokenFallsBackToLogin(t *testing.T) {
+	srv, sess, clock := newTestSession(t)
+
+	clock.Advance(1800 * time.Second)
+	_, err := sess.SensorsList(context.Background(), "TERMOMETRO", webdrops.GroupDPC)
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, srv.PasswordLogins())
+	assert.Equal(t, 0, srv.Refreshes())
+}
+
+func TestRejectedRefreshTokenFallsBackToLogin(t *testing.T) {
+	srv, sess, clock := newTestSession(t)
+
+	srv.RevokeTokens()
+	clock.Advance(200 * time.Second)
+	_, err := sess.SensorsList(context.Background(), "TERMOMETRO", webdrops.GroupDPC)
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, srv.PasswordLogins())
+	assert.Equal(t, 0, srv.Refreshes())
+}
+
+func TestRefreshTokenWithoutExpiration(t *testing.T) {
+	srv := webdropstest.NewServer(nil)
+	defer srv.Close()
+	srv.RefreshExpiresIn = 0
+
+	clock := &fakeClock{now: time.Date(2020, 6, 10, 0, 0, 0, 0, time.UTC)}
+	opts := srv.SessionOptions()
+	opts.Clock = clock.Now
+	sess := webdrops.NewSession(opts)
+	require.NoError(t, sess.Login(context.Background()))
+
+	clock.Advance(24 * time.Hour)
+	_, err := sess.SensorsList(context.Background(), "TERMOMETRO", webdrops.GroupDPC)
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, srv.PasswordLogins())
+	assert.Equal(t, 1, srv.Refreshes())
+}
+
+func TestConcurrentRefreshesShareOneToken(t *testing.T) {
+	srv, sess, clock := newTestSession(t)
+
+	clock.Advance(200 * time.Second)
+
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		go func() {
+			_, err := sess.SensorsList(context.Background(), "TERMOMETRO", webdrops.GroupDPC)
+			errs <- err
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, <-errs)
+	}
+
+	assert.Equal(t, 1, srv.PasswordLogins())
+	assert.Equal(t, 1, srv.Refreshes())
+}
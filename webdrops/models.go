@@ -0,0 +1,216 @@
+package webdrops
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Sensor is a station of a sensors registry,
+// as returned by the sensors/list endpoint.
+type Sensor struct {
+	ID           string  `json:"id"`
+	Name         string  `json:"name"`
+	Lat          float64 `json:"lat"`
+	Lng          float64 `json:"lng"`
+	Municipality string  `json:"municipality,omitempty"`
+	// MeasureUnit is the unit of measure of
+	// the observations of the sensor.
+	MeasureUnit string `json:"mu"`
+}
+
+// SensorRegistry contains all stations
+// available for a sensor class.
+type SensorRegistry []Sensor
+
+// ParseSensorRegistry parses a sensors registry in JSON format.
+func ParseSensorRegistry(data []byte) (SensorRegistry, error) {
+	var registry SensorRegistry
+	if err := json.Unmarshal(data, &registry); err != nil {
+		return nil, fmt.Errorf("error parsing JSON: %w", err)
+	}
+	return registry, nil
+}
+
+// ReadSensorRegistry reads a sensors registry in JSON format from r.
+func ReadSensorRegistry(r io.Reader) (SensorRegistry, error) {
+	var registry SensorRegistry
+	if err := json.NewDecoder(r).Decode(&registry); err != nil {
+		return nil, fmt.Errorf("error parsing JSON: %w", err)
+	}
+	return registry, nil
+}
+
+// LoadSensorRegistry reads a sensors registry from a JSON file.
+func LoadSensorRegistry(path string) (SensorRegistry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	registry, err := ReadSensorRegistry(f)
+	if err != nil {
+		return nil, fmt.Errorf("error reading `%s`: %w", path, err)
+	}
+	return registry, nil
+}
+
+// InDomain returns the sensors of registry
+// whose coordinates fall inside domain.
+func (registry SensorRegistry) InDomain(domain Domain) SensorRegistry {
+	result := SensorRegistry{}
+	for _, sensor := range registry {
+		if domain.Contains(sensor.Lat, sensor.Lng) {
+			result = append(result, sensor)
+		}
+	}
+	return result
+}
+
+// IDs returns the IDs of all sensors in registry.
+func (registry SensorRegistry) IDs() []string {
+	ids := make([]string, len(registry))
+	for i, sensor := range registry {
+		ids[i] = sensor.ID
+	}
+	return ids
+}
+
+// ByID returns a map of the sensors in registry, indexed by ID.
+func (registry SensorRegistry) ByID() map[string]Sensor {
+	result := make(map[string]Sensor, len(registry))
+	for _, sensor := range registry {
+		result[sensor.ID] = sensor
+	}
+	return result
+}
+
+// Observation is a value observed by a sensor at an instant.
+type Observation struct {
+	Time  time.Time
+	Value float64
+}
+
+// ObservationSeries contains all observations of
+// a sensor, as returned by the sensors/data endpoint.
+type ObservationSeries struct {
+	SensorID     string
+	Observations []Observation
+}
+
+// observationSeriesJSON is the JSON representation of an ObservationSeries.
+// Null values are used by the server for missing observations.
+type observationSeriesJSON struct {
+	SensorID string     `json:"sensorId"`
+	Timeline []string   `json:"timeline"`
+	Values   []*float64 `json:"values"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+// Observations with null values are skipped.
+func (series *ObservationSeries) UnmarshalJSON(data []byte) error {
+	var raw observationSeriesJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	if len(raw.Timeline) != len(raw.Values) {
+		return fmt.Errorf(
+			"sensor %s: timeline has %d instants, but there are %d values",
+			raw.SensorID, len(raw.Timeline), len(raw.Values),
+		)
+	}
+
+	series.SensorID = raw.SensorID
+	series.Observations = make([]Observation, 0, len(raw.Values))
+	for i, instantS := range raw.Timeline {
+		if raw.Values[i] == nil {
+			continue
+		}
+		instant, err := time.Parse("200601021504", instantS)
+		if err != nil {
+			return fmt.Errorf("sensor %s: error parsing timeline: %w", raw.SensorID, err)
+		}
+		series.Observations = append(series.Observations, Observation{
+			Time:  instant,
+			Value: *raw.Values[i],
+		})
+	}
+
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (series ObservationSeries) MarshalJSON() ([]byte, error) {
+	raw := observationSeriesJSON{
+		SensorID: series.SensorID,
+		Timeline: make([]string, len(series.Observations)),
+		Values:   make([]*float64, len(series.Observations)),
+	}
+	for i := range series.Observations {
+		raw.Timeline[i] = series.Observations[i].Time.Format("200601021504")
+		raw.Values[i] = &series.Observations[i].Value
+	}
+	return json.Marshal(raw)
+}
+
+// Nearest returns the observation of series nearest
+// to instant, if it's at most maxOffset far from it.
+func (series ObservationSeries) Nearest(instant time.Time, maxOffset time.Duration) (Observation, bool) {
+	var best Observation
+	found := false
+	var bestOffset time.Duration
+
+	for _, obs := range series.Observations {
+		offset := obs.Time.Sub(instant)
+		if offset < 0 {
+			offset = -offset
+		}
+		if offset > maxOffset {
+			continue
+		}
+		if !found || offset < bestOffset {
+			best = obs
+			bestOffset = offset
+			found = true
+		}
+	}
+
+	return best, found
+}
+
+// ParseObservations parses observations in JSON format.
+func ParseObservations(data []byte) ([]ObservationSeries, error) {
+	var series []ObservationSeries
+	if err := json.Unmarshal(data, &series); err != nil {
+		return nil, fmt.Errorf("error parsing JSON: %w", err)
+	}
+	return series, nil
+}
+
+// ReadObservations reads observations in JSON format from r.
+func ReadObservations(r io.Reader) ([]ObservationSeries, error) {
+	var series []ObservationSeries
+	if err := json.NewDecoder(r).Decode(&series); err != nil {
+		return nil, fmt.Errorf("error parsing JSON: %w", err)
+	}
+	return series, nil
+}
+
+// LoadObservations reads observations from a JSON file.
+func LoadObservations(path string) ([]ObservationSeries, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	series, err := ReadObservations(f)
+	if err != nil {
+		return nil, fmt.Errorf("error reading `%s`: %w", path, err)
+	}
+	return series, nil
+}
@@ -0,0 +1,79 @@
+package webdrops_test
+
+import (
+	"encoding/json"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/cima-lexis/lexisdn/webdrops"
+	"github.com/cima-lexis/lexisdn/webdrops/webdropstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSensorRegistry(t *testing.T) {
+	content, err := fs.ReadFile(webdropstest.Fixtures(), "sensors/list/TERMOMETRO.json")
+	require.NoError(t, err)
+
+	registry, err := webdrops.ParseSensorRegistry(content)
+	require.NoError(t, err)
+	require.Len(t, registry, 5)
+
+	assert.Equal(t, webdrops.Sensor{
+		ID:           "-1937152789_2",
+		Name:         "Giardino Botanico Celle",
+		Lat:          44.343433,
+		Lng:          8.54158,
+		Municipality: "Celle Ligure",
+		MeasureUnit:  "C",
+	}, registry[0])
+
+	ligury := webdrops.Domain{MinLat: 43.5, MaxLat: 45, MinLon: 7.5, MaxLon: 10}
+	assert.Equal(t,
+		[]string{"-1937152789_2", "-1937157087_2", "-1937156901_2"},
+		registry.InDomain(ligury).IDs(),
+	)
+	assert.Equal(t, "Nice Cimiez", registry.ByID()["7272_2"].Name)
+}
+
+func TestParseObservations(t *testing.T) {
+	content, err := fs.ReadFile(webdropstest.Fixtures(), "sensors/data/TERMOMETRO.json")
+	require.NoError(t, err)
+
+	series, err := webdrops.ParseObservations(content)
+	require.NoError(t, err)
+	require.Len(t, series, 5)
+
+	assert.Equal(t, "-1937152789_2", series[0].SensorID)
+	assert.Equal(t, []webdrops.Observation{
+		{Time: time.Date(2020, 6, 9, 18, 0, 0, 0, time.UTC), Value: 21.5},
+		{Time: time.Date(2020, 6, 9, 21, 0, 0, 0, time.UTC), Value: 18.2},
+		{Time: time.Date(2020, 6, 10, 0, 0, 0, 0, time.UTC), Value: 16.9},
+	}, series[0].Observations)
+	assert.Empty(t, series[2].Observations)
+
+	obs, found := series[0].Nearest(time.Date(2020, 6, 9, 21, 4, 0, 0, time.UTC), 5*time.Minute)
+	assert.True(t, found)
+	assert.Equal(t, 18.2, obs.Value)
+
+	_, found = series[0].Nearest(time.Date(2020, 6, 9, 21, 30, 0, 0, time.UTC), 5*time.Minute)
+	assert.False(t, found)
+}
+
+func TestObservationsRoundTrip(t *testing.T) {
+	source := `[{"sensorId":"7272_2","timeline":["202006100000","202006100100","202006100200"],"values":[1.5,null,2]}]`
+
+	series, err := webdrops.ParseObservations([]byte(source))
+	require.NoError(t, err)
+	require.Len(t, series[0].Observations, 2)
+
+	encoded, err := json.Marshal(series)
+	require.NoError(t, err)
+	assert.JSONEq(t, `[{"sensorId":"7272_2","timeline":["202006100000","202006100200"],"values":[1.5,2]}]`, string(encoded))
+}
+
+func TestInconsistentObservations(t *testing.T) {
+	_, err := webdrops.ParseObservations([]byte(`[{"sensorId":"7272_2","timeline":["202006100000"],"values":[]}]`))
+	assert.Error(t, err)
+}
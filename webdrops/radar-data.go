@@ -1,27 +1,44 @@
 package webdrops
 
 import (
+	"context"
 	"fmt"
+	"strings"
 	"time"
-
-	"github.com/cima-lexis/lexisdn/config"
 )
 
-// RadarData ...
-func (sess *Session) RadarData(date time.Time, varName string) ([]byte, error) {
+// DefaultRadarDataset is the name of the webdrops
+// datasets of italian radar variables.
+const DefaultRadarDataset = "RADAR_DPC_HDF5_{var}"
+
+// RadarDatasetName returns the name of the dataset of variable
+// varName, replacing {var} in the dataset name template.
+func RadarDatasetName(dataset, varName string) string {
+	return strings.ReplaceAll(dataset, "{var}", varName)
+}
+
+// RadarData downloads the radar variable varName
+// at given date, streaming it to targetPath.
+func (sess *Session) RadarData(ctx context.Context, date time.Time, varName string, targetPath string) (Download, error) {
+	return sess.RadarDatasetData(ctx, DefaultRadarDataset, date, varName, targetPath)
+}
+
+// RadarDatasetData works as RadarData, downloading
+// the variable from the datasets named by dataset.
+func (sess *Session) RadarDatasetData(ctx context.Context, dataset string, date time.Time, varName string, targetPath string) (Download, error) {
 
 	url := fmt.Sprintf(
-		"%scoverages/RADAR_DPC_HDF5_%s/%s/%s/-/all",
-		config.Config.URL,
-		varName,
+		"%scoverages/%s/%s/%s/-/all",
+		sess.url,
+		RadarDatasetName(dataset, varName),
 		date.Format("200601021504"),
 		varName,
 	)
 
-	bodyResp, err := sess.DoGet(url, "application/octet-stream")
+	download, err := sess.DownloadFile(ctx, url, "application/octet-stream", targetPath)
 	if err != nil {
-		return nil, fmt.Errorf("error performing Post: %w", err)
+		return Download{}, fmt.Errorf("error performing GET: %w", err)
 	}
 
-	return bodyResp, nil
+	return download, nil
 }
@@ -0,0 +1,111 @@
+package webdrops
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// RadarManifest records the radar variables retrieved
+// for an assimilation cycle, and the instants they are
+// taken at. Conversion reads it to know which variables
+// are available.
+type RadarManifest struct {
+	// Cycle is the date of the assimilation cycle.
+	Cycle time.Time `json:"cycle"`
+	// Policy and MaxOffset are the selection
+	// used to choose the instants of Vars.
+	Policy    RadarPolicy `json:"policy"`
+	MaxOffset string      `json:"maxOffset"`
+	// Vars are the variables retrieved, in the order they
+	// were requested.
+	Vars []RadarManifestVar `json:"vars"`
+	// Missing are the variables requested, but
+	// not available at any acceptable instant.
+	Missing []string `json:"missing,omitempty"`
+}
+
+// RadarManifestVar is the instant a radar variable
+// is taken at, and its offset from the cycle.
+type RadarManifestVar struct {
+	Var     string    `json:"var"`
+	Instant time.Time `json:"instant"`
+	Offset  string    `json:"offset"`
+}
+
+// NewRadarManifest returns the manifest of the
+// radar instants chosen by sel for cycle.
+func NewRadarManifest(cycle time.Time, vars []string, sel RadarSelection, instants RadarInstants) RadarManifest {
+	manifest := RadarManifest{
+		Cycle:     cycle,
+		Policy:    sel.Policy,
+		MaxOffset: sel.MaxOffset.String(),
+		Vars:      []RadarManifestVar{},
+	}
+	for _, varName := range vars {
+		instant, ok := instants[varName]
+		if !ok {
+			manifest.Missing = append(manifest.Missing, varName)
+			continue
+		}
+		manifest.Vars = append(manifest.Vars, RadarManifestVar{
+			Var:     varName,
+			Instant: instant,
+			Offset:  instant.Sub(cycle).String(),
+		})
+	}
+	return manifest
+}
+
+// Has returns whether varName was retrieved.
+func (manifest RadarManifest) Has(varName string) bool {
+	for _, v := range manifest.Vars {
+		if v.Var == varName {
+			return true
+		}
+	}
+	return false
+}
+
+// RadarManifestPath returns the path of the manifest of
+// cycle in dir, <dir>/ob.radar.<CYCLE>.manifest.json: it's
+// saved next to the ob.radar files, out of the directories
+// removed by profile cleanups.
+func RadarManifestPath(dir string, cycle time.Time) string {
+	return filepath.Join(dir, fmt.Sprintf("ob.radar.%s.manifest.json", cycle.Format("2006010215")))
+}
+
+// WriteRadarManifest saves manifest to
+// its RadarManifestPath under dir.
+func WriteRadarManifest(dir string, manifest RadarManifest) error {
+	path := RadarManifestPath(dir, manifest.Cycle)
+	if err := os.MkdirAll(filepath.Dir(path), os.FileMode(0755)); err != nil {
+		return fmt.Errorf("error creating directory `%s`: %w", filepath.Dir(path), err)
+	}
+
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding radar manifest: %w", err)
+	}
+	if err := ioutil.WriteFile(path, content, os.FileMode(0644)); err != nil {
+		return fmt.Errorf("error writing radar manifest: %w", err)
+	}
+	return nil
+}
+
+// ReadRadarManifest reads the manifest of cycle from dir.
+func ReadRadarManifest(dir string, cycle time.Time) (RadarManifest, error) {
+	path := RadarManifestPath(dir, cycle)
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return RadarManifest{}, fmt.Errorf("error reading radar manifest: %w", err)
+	}
+	var manifest RadarManifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return RadarManifest{}, fmt.Errorf("error decoding radar manifest `%s`: %w", path, err)
+	}
+	return manifest, nil
+}
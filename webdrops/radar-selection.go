@@ -0,0 +1,149 @@
+package webdrops
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultRadarMaxOffset is the default maximum distance
+// of radar instants from the date they are used for.
+const DefaultRadarMaxOffset = 30 * time.Minute
+
+// RadarPolicy tells how the instants of radar
+// variables are chosen by SelectRadarInstants.
+type RadarPolicy string
+
+// Radar selection policies.
+const (
+	// RadarCommon takes all variables at the nearest instant
+	// at which all of them are available, falling back to
+	// farther instants when the nearest ones are incomplete.
+	RadarCommon RadarPolicy = "common"
+	// RadarPartial works as RadarCommon, but when no instant
+	// has all variables it accepts the nearest instant with
+	// the most of them. Other variables are left out.
+	RadarPartial RadarPolicy = "partial"
+	// RadarPerVariable takes every variable at its own
+	// nearest instant. Variables never available are left out.
+	RadarPerVariable RadarPolicy = "per_variable"
+)
+
+// RadarSelection configures SelectRadarInstants.
+type RadarSelection struct {
+	// MaxOffset is the maximum distance of chosen instants from
+	// the requested date. When zero, DefaultRadarMaxOffset is used.
+	MaxOffset time.Duration
+	// Policy is how instants are chosen.
+	// When empty, RadarCommon is used.
+	Policy RadarPolicy
+}
+
+// OrDefault returns sel with zero fields set to their defaults.
+func (sel RadarSelection) OrDefault() RadarSelection {
+	if sel.MaxOffset == 0 {
+		sel.MaxOffset = DefaultRadarMaxOffset
+	}
+	if sel.Policy == "" {
+		sel.Policy = RadarCommon
+	}
+	return sel
+}
+
+// Validate returns an error if sel has an unknown
+// policy or a negative maximum offset.
+func (sel RadarSelection) Validate() error {
+	if sel.MaxOffset < 0 {
+		return fmt.Errorf("negative max offset %s", sel.MaxOffset)
+	}
+	switch sel.OrDefault().Policy {
+	case RadarCommon, RadarPartial, RadarPerVariable:
+		return nil
+	}
+	return fmt.Errorf("unknown radar policy `%s`", sel.Policy)
+}
+
+// RadarInstants maps radar variables
+// to the instant they are taken at.
+type RadarInstants map[string]time.Time
+
+// SelectRadarInstants chooses, following sel, the instants at which
+// variables vars are taken for date, given the timelines of instants
+// at which every variable is available. Instants farther than
+// sel.MaxOffset from date are never chosen, and when two instants
+// are equally far from date the earlier one is preferred. Chosen
+// instants are returned in UTC.
+func SelectRadarInstants(timelines map[string][]time.Time, vars []string, date time.Time, sel RadarSelection) (RadarInstants, error) {
+	if err := sel.Validate(); err != nil {
+		return nil, err
+	}
+	sel = sel.OrDefault()
+
+	// instants are keyed by Unix time, so that equal instants
+	// in different locations or repeated in a timeline
+	// count once, together with the set of their variables.
+	available := map[int64]map[string]bool{}
+	for _, varName := range vars {
+		for _, instant := range timelines[varName] {
+			if absDuration(instant.Sub(date)) <= sel.MaxOffset {
+				key := instant.Unix()
+				if available[key] == nil {
+					available[key] = map[string]bool{}
+				}
+				available[key][varName] = true
+			}
+		}
+	}
+	wanted := map[string]bool{}
+	for _, varName := range vars {
+		wanted[varName] = true
+	}
+
+	instants := RadarInstants{}
+	switch sel.Policy {
+	case RadarPerVariable:
+		for key, instantVars := range available {
+			instant := time.Unix(key, 0).UTC()
+			for varName := range instantVars {
+				if best, ok := instants[varName]; !ok || nearer(instant, best, date) {
+					instants[varName] = instant
+				}
+			}
+		}
+	default:
+		var best int64
+		found := false
+		for key, instantVars := range available {
+			if len(instantVars) < len(wanted) && sel.Policy == RadarCommon {
+				continue
+			}
+			if !found ||
+				len(instantVars) > len(available[best]) ||
+				len(instantVars) == len(available[best]) && nearer(time.Unix(key, 0), time.Unix(best, 0), date) {
+				best = key
+				found = true
+			}
+		}
+		for varName := range available[best] {
+			instants[varName] = time.Unix(best, 0).UTC()
+		}
+	}
+
+	if len(instants) == 0 {
+		return nil, fmt.Errorf("no radar found for %s within %s", date.Format("200601021504"), sel.MaxOffset)
+	}
+	return instants, nil
+}
+
+// nearer returns whether a is nearer to date than b,
+// or as near as b but earlier.
+func nearer(a, b, date time.Time) bool {
+	da, db := absDuration(a.Sub(date)), absDuration(b.Sub(date))
+	return da < db || da == db && a.Before(b)
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
@@ -1,104 +1,78 @@
 package webdrops
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"math"
+	"os"
 	"sort"
 	"time"
-
-	"github.com/cima-lexis/lexisdn/config"
 )
 
-func (sess *Session) timelineForVar(date time.Time, cappivar int) ([]string, error) {
-	from := date.Add(-30 * time.Minute)
-	to := date.Add(30 * time.Minute)
+// DefaultRadarVars are the radar variables whose
+// timelines are intersected by RadarTimeline.
+var DefaultRadarVars = []string{"CAPPI2", "CAPPI3", "CAPPI4", "CAPPI5"}
+
+// RadarVarTimeline returns, sorted, the instants at most maxOffset
+// far from date at which variable varName of the datasets named
+// by dataset is available.
+func (sess *Session) RadarVarTimeline(ctx context.Context, dataset string, varName string, date time.Time, maxOffset time.Duration) ([]time.Time, error) {
+	from := date.Add(-maxOffset)
+	to := date.Add(maxOffset)
 
 	fromS := from.Format("200601021504")
 	toS := to.Format("200601021504")
-	urlFormat := "%scoverages/RADAR_DPC_HDF5_CAPPI%d/?from=%s&to=%s"
+	urlFormat := "%scoverages/%s/?from=%s&to=%s"
 
-	url := fmt.Sprintf(urlFormat, config.Config.URL, cappivar, fromS, toS)
+	url := fmt.Sprintf(urlFormat, sess.url, RadarDatasetName(dataset, varName), fromS, toS)
 
-	body, err := sess.DoGet(url, "application/json")
+	body, err := sess.DoGet(ctx, url, "application/json")
 	if err != nil {
 		return nil, fmt.Errorf("error performing get: %w", err)
 	}
 
-	var timeline []string
-	err = json.Unmarshal(body, &timeline)
+	var timelineS []string
+	err = json.Unmarshal(body, &timelineS)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing JSON: %w", err)
 	}
 
-	sort.Strings(timeline)
-	fmt.Printf("Radar availability for date %s, variable CAPPI%d: %v\n", date.Format("2006-01-02-15-04"), cappivar, timeline)
-
-	return timeline, nil
-}
-
-// RadarTimeline ...
-func (sess *Session) RadarTimeline(date time.Time, log bool) (time.Time, error) {
-
-	var timelines [4][]string
-	var err error
-
-	timelines[0], err = sess.timelineForVar(date, 2)
-	if err != nil {
-		return time.Time{}, fmt.Errorf("error getting timeline: %w", err)
-	}
-	timelines[1], err = sess.timelineForVar(date, 3)
-	if err != nil {
-		return time.Time{}, fmt.Errorf("error getting timeline: %w", err)
-	}
-	timelines[2], err = sess.timelineForVar(date, 4)
-	if err != nil {
-		return time.Time{}, fmt.Errorf("error getting timeline: %w", err)
-	}
-	timelines[3], err = sess.timelineForVar(date, 5)
-	if err != nil {
-		return time.Time{}, fmt.Errorf("error getting timeline: %w", err)
-	}
-
-	commonInstants := intersect(timelines[0], timelines[1])
-	commonInstants = intersect(timelines[2], commonInstants)
-	commonInstants = intersect(timelines[3], commonInstants)
-
-	if len(commonInstants) == 0 {
-		return time.Time{}, fmt.Errorf("no radar found for %s", date.Format("200601021504"))
-	}
+	sort.Strings(timelineS)
+	fmt.Fprintf(os.Stderr, "Radar availability for date %s, variable %s: %v\n", date.Format("2006-01-02-15-04"), varName, timelineS)
 
-	bestFound := time.Time{}
-	for _, instantS := range commonInstants {
-		instant, err := time.Parse("200601021504", instantS)
+	timeline := make([]time.Time, len(timelineS))
+	for i, instantS := range timelineS {
+		timeline[i], err = time.Parse("200601021504", instantS)
 		if err != nil {
-			return time.Time{}, fmt.Errorf("error parsing timeline: %w", err)
+			return nil, fmt.Errorf("error parsing timeline: %w", err)
 		}
-
-		if instant == date {
-			return instant, nil
-		}
-
-		if math.Abs(instant.Sub(date).Seconds()) <
-			math.Abs(bestFound.Sub(date).Seconds()) {
-			bestFound = instant
-
-		}
-
 	}
 
-	return bestFound, nil
+	return timeline, nil
 }
 
-func intersect(a []string, b []string) []string {
-	set := make([]string, 0)
+// RadarTimeline returns the instant nearest to date, at most
+// 30 minutes far from it, at which all DefaultRadarVars of
+// the DefaultRadarDataset are available.
+func (sess *Session) RadarTimeline(ctx context.Context, date time.Time, log bool) (time.Time, error) {
+	instants, err := sess.RadarDatasetTimeline(ctx, DefaultRadarDataset, DefaultRadarVars, date, RadarSelection{})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return instants[DefaultRadarVars[0]], nil
+}
 
-	for _, item := range a {
-		idx := sort.SearchStrings(b, item)
-		if idx < len(b) && b[idx] == item {
-			set = append(set, item)
+// RadarDatasetTimeline returns the instants at which variables vars
+// of the datasets named by dataset are taken for date, chosen by sel.
+func (sess *Session) RadarDatasetTimeline(ctx context.Context, dataset string, vars []string, date time.Time, sel RadarSelection) (RadarInstants, error) {
+	timelines := map[string][]time.Time{}
+	for _, varName := range vars {
+		timeline, err := sess.RadarVarTimeline(ctx, dataset, varName, date, sel.OrDefault().MaxOffset)
+		if err != nil {
+			return nil, fmt.Errorf("error getting timeline: %w", err)
 		}
+		timelines[varName] = timeline
 	}
 
-	return set
+	return SelectRadarInstants(timelines, vars, date, sel)
 }
@@ -0,0 +1,30 @@
+package webdrops_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/cima-lexis/lexisdn/webdrops"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRadarManifest(t *testing.T) {
+	dir := t.TempDir()
+	sel := webdrops.RadarSelection{Policy: webdrops.RadarPartial}.OrDefault()
+	instants := webdrops.RadarInstants{"CAPPI2": at(10), "CAPPI4": at(10)}
+	manifest := webdrops.NewRadarManifest(cycleDate, []string{"CAPPI2", "CAPPI3", "CAPPI4"}, sel, instants)
+
+	require.NoError(t, webdrops.WriteRadarManifest(dir, manifest))
+	assert.FileExists(t, filepath.Join(dir, "ob.radar.2020061000.manifest.json"))
+
+	read, err := webdrops.ReadRadarManifest(dir, cycleDate)
+	require.NoError(t, err)
+	assert.Equal(t, manifest, read)
+	assert.True(t, read.Has("CAPPI2"))
+	assert.False(t, read.Has("CAPPI3"))
+	assert.Equal(t, []string{"CAPPI3"}, read.Missing)
+
+	_, err = webdrops.ReadRadarManifest(dir, at(180))
+	assert.Error(t, err)
+}
@@ -0,0 +1,87 @@
+package webdrops_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cima-lexis/lexisdn/webdrops"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var cycleDate = time.Date(2020, 6, 10, 0, 0, 0, 0, time.UTC)
+
+// at returns cycleDate moved by minutes.
+func at(minutes int) time.Time {
+	return cycleDate.Add(time.Duration(minutes) * time.Minute)
+}
+
+func TestSelectRadarInstants(t *testing.T) {
+	vars := []string{"CAPPI2", "CAPPI3", "CAPPI4"}
+	timelines := map[string][]time.Time{
+		"CAPPI2": {at(-40), at(-10), at(5), at(15)},
+		"CAPPI3": {at(-40), at(-10), at(15)},
+		"CAPPI4": {at(-40), at(-5), at(15), at(40)},
+	}
+
+	tests := []struct {
+		name     string
+		sel      webdrops.RadarSelection
+		expected webdrops.RadarInstants
+	}{{
+		name:     "common",
+		sel:      webdrops.RadarSelection{},
+		expected: webdrops.RadarInstants{"CAPPI2": at(15), "CAPPI3": at(15), "CAPPI4": at(15)},
+	}, {
+		name:     "common with larger offset takes the nearest complete instant",
+		sel:      webdrops.RadarSelection{MaxOffset: time.Hour, Policy: webdrops.RadarCommon},
+		expected: webdrops.RadarInstants{"CAPPI2": at(15), "CAPPI3": at(15), "CAPPI4": at(15)},
+	}, {
+		name:     "partial",
+		sel:      webdrops.RadarSelection{MaxOffset: 12 * time.Minute, Policy: webdrops.RadarPartial},
+		expected: webdrops.RadarInstants{"CAPPI2": at(-10), "CAPPI3": at(-10)},
+	}, {
+		name:     "per variable",
+		sel:      webdrops.RadarSelection{Policy: webdrops.RadarPerVariable},
+		expected: webdrops.RadarInstants{"CAPPI2": at(5), "CAPPI3": at(-10), "CAPPI4": at(-5)},
+	}}
+
+	for _, test := range tests {
+		instants, err := webdrops.SelectRadarInstants(timelines, vars, cycleDate, test.sel)
+		require.NoError(t, err, test.name)
+		assert.Equal(t, test.expected, instants, test.name)
+	}
+
+	_, err := webdrops.SelectRadarInstants(timelines, vars, cycleDate, webdrops.RadarSelection{MaxOffset: 12 * time.Minute})
+	assert.EqualError(t, err, "no radar found for 202006100000 within 12m0s")
+
+	_, err = webdrops.SelectRadarInstants(timelines, vars, cycleDate, webdrops.RadarSelection{Policy: "any"})
+	assert.Error(t, err)
+}
+
+func TestSelectRadarInstantsTies(t *testing.T) {
+	vars := []string{"CAPPI2"}
+	timelines := map[string][]time.Time{"CAPPI2": {at(10), at(-10)}}
+
+	for _, policy := range []webdrops.RadarPolicy{webdrops.RadarCommon, webdrops.RadarPartial, webdrops.RadarPerVariable} {
+		instants, err := webdrops.SelectRadarInstants(timelines, vars, cycleDate, webdrops.RadarSelection{Policy: policy})
+		require.NoError(t, err, policy)
+		assert.Equal(t, webdrops.RadarInstants{"CAPPI2": at(-10)}, instants, policy)
+	}
+}
+
+func TestSelectRadarInstantsRepeated(t *testing.T) {
+	vars := []string{"CAPPI2", "CAPPI3", "CAPPI4"}
+	rome := time.FixedZone("CEST", 2*60*60)
+	timelines := map[string][]time.Time{
+		// repeated instants don't make at(5) complete
+		"CAPPI2": {at(5), at(5), at(5), at(15)},
+		// the same instant in another location
+		"CAPPI3": {at(15).In(rome)},
+		"CAPPI4": {at(15)},
+	}
+
+	instants, err := webdrops.SelectRadarInstants(timelines, vars, cycleDate, webdrops.RadarSelection{})
+	require.NoError(t, err)
+	assert.Equal(t, webdrops.RadarInstants{"CAPPI2": at(15), "CAPPI3": at(15), "CAPPI4": at(15)}, instants)
+}
@@ -0,0 +1,72 @@
+package webdrops
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy configures how a Session
+// retries failed requests.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of
+	// attempts for every request, including the first one.
+	MaxAttempts int
+	// InitialBackoff is the wait time after the first failed attempt.
+	// It doubles on every subsequent failure.
+	InitialBackoff time.Duration
+	// MaxBackoff is the maximum wait time between two attempts.
+	MaxBackoff time.Duration
+	// Jitter is the fraction of every wait time that
+	// is randomized, between 0 and 1.
+	Jitter float64
+	// Deadline is the maximum overall time spent in
+	// attempts of a single request. Zero means no deadline.
+	Deadline time.Duration
+}
+
+// DefaultRetryPolicy is the RetryPolicy used
+// by sessions that don't specify one.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 1 * time.Second,
+	MaxBackoff:     30 * time.Second,
+	Jitter:         0.2,
+	Deadline:       10 * time.Minute,
+}
+
+// sleep waits for d to elapse, returning
+// early with an error if ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// backoff returns the time to wait after
+// given failed attempt, starting from 1.
+// The wait is never less than minWait.
+func (p RetryPolicy) backoff(attempt int, minWait time.Duration) time.Duration {
+	wait := p.InitialBackoff
+	for i := 1; i < attempt && wait < p.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if p.MaxBackoff > 0 && wait > p.MaxBackoff {
+		wait = p.MaxBackoff
+	}
+
+	if p.Jitter > 0 {
+		delta := p.Jitter * float64(wait)
+		wait += time.Duration(delta * (2*rand.Float64() - 1))
+	}
+
+	if wait < minWait {
+		wait = minWait
+	}
+	return wait
+}
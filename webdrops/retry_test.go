@@ -0,0 +1,121 @@
+package webdrops_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cima-lexis/lexisdn/webdrops"
+	"github.com/cima-lexis/lexisdn/webdrops/webdropstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var fastRetry = webdrops.RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     10 * time.Millisecond,
+}
+
+func newRetrySession(t *testing.T, policy webdrops.RetryPolicy) (*webdropstest.Server, *webdrops.Session) {
+	srv := webdropstest.NewServer(nil)
+	t.Cleanup(srv.Close)
+
+	opts := srv.SessionOptions()
+	opts.Retry = policy
+	sess := webdrops.NewSession(opts)
+	require.NoError(t, sess.Login(context.Background()))
+	return srv, sess
+}
+
+func TestServerErrorsAreRetried(t *testing.T) {
+	srv, sess := newRetrySession(t, fastRetry)
+	srv.Fail(2, http.StatusServiceUnavailable, nil)
+
+	_, err := sess.SensorsList(context.Background(), "TERMOMETRO", webdrops.GroupDPC)
+	require.NoError(t, err)
+	assert.Len(t, srv.Requests(), 3)
+}
+
+func TestRetriesStopAtMaxAttempts(t *testing.T) {
+	srv, sess := newRetrySession(t, fastRetry)
+	srv.Fail(3, http.StatusBadGateway, nil)
+
+	_, err := sess.SensorsList(context.Background(), "TERMOMETRO", webdrops.GroupDPC)
+	var httpErr *webdrops.HTTPError
+	require.True(t, errors.As(err, &httpErr))
+	assert.Equal(t, http.StatusBadGateway, httpErr.StatusCode)
+	assert.Len(t, srv.Requests(), 3)
+}
+
+func TestNotFoundIsPermanent(t *testing.T) {
+	srv, sess := newRetrySession(t, fastRetry)
+
+	_, err := sess.SensorsList(context.Background(), "NOTACLASS", webdrops.GroupDPC)
+	assert.True(t, errors.Is(err, webdrops.ErrNotFound))
+	assert.False(t, errors.Is(err, webdrops.ErrUnauthorized))
+	assert.Len(t, srv.Requests(), 1)
+}
+
+func TestUnexpectedContentTypeIsPermanent(t *testing.T) {
+	srv, sess := newRetrySession(t, fastRetry)
+
+	_, err := sess.DoGet(context.Background(), srv.URL+"/sensors/list/TERMOMETRO", "application/octet-stream")
+	var ctErr *webdrops.ContentTypeError
+	require.True(t, errors.As(err, &ctErr))
+	assert.Equal(t, "application/json", ctErr.Got)
+	assert.Len(t, srv.Requests(), 1)
+}
+
+func TestWrongCredentials(t *testing.T) {
+	srv := webdropstest.NewServer(nil)
+	defer srv.Close()
+
+	opts := srv.SessionOptions()
+	opts.Password = "wrong"
+	err := webdrops.NewSession(opts).Login(context.Background())
+	assert.True(t, errors.Is(err, webdrops.ErrUnauthorized))
+}
+
+func TestRetryAfterIsHonoured(t *testing.T) {
+	srv, sess := newRetrySession(t, fastRetry)
+	srv.Fail(1, http.StatusTooManyRequests, http.Header{"Retry-After": []string{"1"}})
+
+	start := time.Now()
+	_, err := sess.SensorsList(context.Background(), "TERMOMETRO", webdrops.GroupDPC)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(time.Second))
+}
+
+func TestRetryDeadline(t *testing.T) {
+	policy := fastRetry
+	policy.MaxAttempts = 10
+	policy.InitialBackoff = 50 * time.Millisecond
+	policy.MaxBackoff = 50 * time.Millisecond
+	policy.Deadline = 120 * time.Millisecond
+
+	srv, sess := newRetrySession(t, policy)
+	srv.Fail(10, http.StatusInternalServerError, nil)
+
+	_, err := sess.SensorsList(context.Background(), "TERMOMETRO", webdrops.GroupDPC)
+	assert.Error(t, err)
+	assert.Len(t, srv.Requests(), 3)
+}
+
+func TestCanceledContextStopsRetries(t *testing.T) {
+	policy := fastRetry
+	policy.InitialBackoff = time.Hour
+	policy.MaxBackoff = time.Hour
+
+	srv, sess := newRetrySession(t, policy)
+	srv.Fail(1, http.StatusServiceUnavailable, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := sess.SensorsList(ctx, "TERMOMETRO", webdrops.GroupDPC)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Len(t, srv.Requests(), 1)
+}
@@ -1,34 +1,112 @@
 package webdrops
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"time"
-
-	"github.com/cima-lexis/lexisdn/config"
 )
 
-// SensorsData ...
-func (sess *Session) SensorsData(class string, from, to time.Time, aggregation int, collection SensorGroup) ([]byte, error) {
+// DefaultSensorsBatchSize is the number of sensors IDs
+// requested with a single POST by sessions that
+// don't specify SessionOptions.SensorsBatchSize.
+const DefaultSensorsBatchSize = 500
+
+// SensorsData downloads observations of sensors of given class,
+// saving them to targetPath as a JSON array.
+//
+// When ids is nil, observations of all sensors in collection are
+// downloaded with a single GET request. Otherwise, only observations
+// of sensors with given ids are downloaded, posting their IDs in
+// batches of at most the session sensors batch size, and the
+// results are merged in a single array.
+func (sess *Session) SensorsData(ctx context.Context, class string, ids []string, from, to time.Time, aggregation int, collection SensorGroup, targetPath string) (Download, error) {
 	fromS := from.Format("200601021504")
 	toS := to.Format("200601021504")
 
+	if ids != nil {
+		url := fmt.Sprintf(
+			"%ssensors/data/%s/?from=%s&to=%s&aggr=%d",
+			sess.url,
+			class,
+			fromS,
+			toS,
+			aggregation,
+		)
+		return sess.sensorsDataByID(ctx, url, ids, targetPath)
+	}
+
 	url := fmt.Sprintf(
 		"%ssensors/data/%s/%s?from=%s&to=%s&aggr=%d",
-		config.Config.URL,
+		sess.url,
 		class,
 		collection.String(),
 		fromS,
 		toS,
 		aggregation,
 	)
-	/*body := map[string][]string{
-		"sensors": ids,
-	}*/
 
-	bodyResp, err := sess.DoGet(url, "application/json" /*, body*/)
+	download, err := sess.DownloadFile(ctx, url, "application/json", targetPath)
+	if err != nil {
+		return Download{}, fmt.Errorf("error performing GET: %w", err)
+	}
+
+	return download, nil
+}
+
+func (sess *Session) sensorsDataByID(ctx context.Context, url string, ids []string, targetPath string) (Download, error) {
+	batchSize := sess.sensorsBatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultSensorsBatchSize
+	}
+
+	file, err := createAtomicFile(targetPath)
 	if err != nil {
-		return nil, fmt.Errorf("error performing Post: %w", err)
+		return Download{}, err
+	}
+	defer file.Abort()
+
+	if _, err := file.Write([]byte("[")); err != nil {
+		return Download{}, fmt.Errorf("error saving sensors data: %w", err)
+	}
+
+	written := 0
+	for start := 0; start < len(ids); start += batchSize {
+		end := start + batchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		body := map[string][]string{
+			"sensors": ids[start:end],
+		}
+
+		bodyResp, err := sess.DoPost(ctx, url, body, "application/json")
+		if err != nil {
+			return Download{}, fmt.Errorf("error performing POST: %w", err)
+		}
+
+		var series []json.RawMessage
+		if err := json.Unmarshal(bodyResp, &series); err != nil {
+			return Download{}, fmt.Errorf("error parsing JSON: %w", err)
+		}
+
+		for _, s := range series {
+			if written > 0 {
+				if _, err := file.Write([]byte(",")); err != nil {
+					return Download{}, fmt.Errorf("error saving sensors data: %w", err)
+				}
+			}
+			if _, err := file.Write(s); err != nil {
+				return Download{}, fmt.Errorf("error saving sensors data: %w", err)
+			}
+			written++
+		}
+	}
+
+	if _, err := file.Write([]byte("]\n")); err != nil {
+		return Download{}, fmt.Errorf("error saving sensors data: %w", err)
 	}
 
-	return bodyResp, nil
+	return file.Commit()
 }
@@ -1,42 +1,20 @@
 package webdrops
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"net/url"
-
-	"github.com/cima-lexis/lexisdn/config"
 )
 
-// IDFromSensorsList ...
+// IDFromSensorsList returns the IDs of all sensors in
+// the JSON sensorList that fall inside filter domain.
 func (sess *Session) IDFromSensorsList(sensorList []byte, filter Domain) ([]string, error) {
-	var sensors = []struct {
-		ID  string
-		Lng float64
-		Lat float64
-	}{}
-	err := json.Unmarshal(sensorList, &sensors)
+	registry, err := ParseSensorRegistry(sensorList)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing JSON: %w", err)
+		return nil, err
 	}
 
-	result := []string{}
-	for _, sensor := range sensors {
-		if sensor.Lat < filter.MinLat || sensor.Lat > filter.MaxLat {
-			continue
-		}
-		if sensor.Lng < filter.MinLon || sensor.Lng > filter.MaxLon {
-			continue
-		}
-
-		result = append(result, sensor.ID)
-	}
-	/*
-		if len(result) > 1000 {
-			result = result[:1000]
-		}
-	*/
-	return result, nil
+	return registry.InDomain(filter).IDs(), nil
 }
 
 // SensorGroup ...
@@ -61,8 +39,28 @@ func (g SensorGroup) String() string {
 	panic(fmt.Sprintf("Unknown group %d", g))
 }
 
+// ParseSensorGroup returns the SensorGroup
+// with given name, either WUNDERGROUND or DPC.
+func ParseSensorGroup(name string) (SensorGroup, error) {
+	switch name {
+	case "WUNDERGROUND":
+		return GroupWunderground, nil
+	case "DPC":
+		return GroupDPC, nil
+	}
+	return 0, fmt.Errorf("unknown sensor group `%s`", name)
+}
+
+// Name returns the name of g accepted by ParseSensorGroup.
+func (g SensorGroup) Name() string {
+	if g == GroupDPC {
+		return "DPC"
+	}
+	return "WUNDERGROUND"
+}
+
 // SensorsList ...
-func (sess *Session) SensorsList(class string, group SensorGroup) ([]byte, error) {
-	url := fmt.Sprintf("%ssensors/list/%s?stationgroup=%s", config.Config.URL, class, group.String())
-	return sess.DoGet(url, "application/json")
+func (sess *Session) SensorsList(ctx context.Context, class string, group SensorGroup) ([]byte, error) {
+	url := fmt.Sprintf("%ssensors/list/%s?stationgroup=%s", sess.url, class, group.String())
+	return sess.DoGet(ctx, url, "application/json")
 }
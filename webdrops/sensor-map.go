@@ -1,31 +1,31 @@
 package webdrops
 
 import (
+	"context"
 	"fmt"
 	"time"
-
-	"github.com/cima-lexis/lexisdn/config"
 )
 
-// SensorsMap ...
-func (sess *Session) SensorsMap(class string, from, to time.Time, group SensorGroup) ([]byte, error) {
+// SensorsMap downloads the map of observations for given
+// sensor class and interval, streaming it to targetPath.
+func (sess *Session) SensorsMap(ctx context.Context, class string, from, to time.Time, group SensorGroup, targetPath string) (Download, error) {
 	fromS := from.Format("200601021504")
 	toS := to.Format("200601021504")
 
 	url := fmt.Sprintf(
 		"%ssensors/map/%s/?from=%s&to=%s&stationgroup=%s",
-		config.Config.URL,
+		sess.url,
 		class,
 		fromS,
 		toS,
 		group.String(),
 	)
 	//fmt.Println(url)
-	bodyResp, err := sess.DoGet(url, "application/octet-stream")
+	download, err := sess.DownloadFile(ctx, url, "application/octet-stream", targetPath)
 	if err != nil {
-		return nil, fmt.Errorf("error performing GET: %w", err)
+		return Download{}, fmt.Errorf("error performing GET: %w", err)
 
 	}
 
-	return bodyResp, nil
+	return download, nil
 }
@@ -0,0 +1 @@
+CDFfake radar coverage
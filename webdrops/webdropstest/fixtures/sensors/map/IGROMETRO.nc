@@ -0,0 +1 @@
+CDFfake IGROMETRO map
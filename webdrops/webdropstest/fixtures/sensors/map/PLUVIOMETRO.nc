@@ -0,0 +1 @@
+CDFfake PLUVIOMETRO map
@@ -0,0 +1 @@
+CDFfake TERMOMETRO map
@@ -0,0 +1,403 @@
+// Package webdropstest implements an in-process fake
+// WEBDROPS server, to be used in end to end tests of
+// webdrops and fetcher packages without network access.
+package webdropstest
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cima-lexis/lexisdn/webdrops"
+)
+
+//go:embed fixtures
+var defaultFixtures embed.FS
+
+// Fixtures returns the file system containing
+// the fixtures served by default by a Server.
+func Fixtures() fs.FS {
+	sub, err := fs.Sub(defaultFixtures, "fixtures")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+// Credentials accepted by a Server for password logins.
+const (
+	ClientID = "webdrops"
+	User     = "user@example.com"
+	Password = "secret"
+)
+
+// Server is a fake WEBDROPS server. It serves
+// the KeyCloak token endpoint under /auth/token
+// and all webdrops endpoints under the root path,
+// reading responses from a fixtures file system.
+//
+// Fixtures are looked up with the following paths:
+//   - sensors/list/<CLASS>.json
+//   - sensors/data/<CLASS>.json, filtered by sensors IDs for POST requests
+//   - sensors/map/<CLASS>.nc
+//   - coverages/<DATASET>/timeline.json, falling back to coverages/timeline.json
+//   - coverages/<DATASET>/<VARNAME>.nc, falling back to coverages/data.nc
+type Server struct {
+	*httptest.Server
+
+	// ExpiresIn is the lifetime, in seconds, of issued access tokens.
+	ExpiresIn uint64
+	// RefreshExpiresIn is the lifetime, in seconds, of issued refresh tokens.
+	RefreshExpiresIn uint64
+	// MaxSensorsPerRequest is the maximum number of sensors
+	// IDs accepted in the body of a sensors data POST.
+	MaxSensorsPerRequest int
+
+	fixtures fs.FS
+
+	mu             sync.Mutex
+	tokenSeq       int
+	accessTokens   map[string]bool
+	refreshTokens  map[string]bool
+	passwordLogins int
+	refreshes      int
+	requests       []string
+	failures       []failure
+}
+
+// failure is an error response scheduled with Fail.
+type failure struct {
+	status int
+	header http.Header
+}
+
+// NewServer starts and returns a new Server that serves
+// responses read from fixtures. When fixtures is nil, the
+// default fixtures returned by Fixtures are used.
+// The caller should call Close when finished, to shut it down.
+func NewServer(fixtures fs.FS) *Server {
+	if fixtures == nil {
+		fixtures = Fixtures()
+	}
+	srv := &Server{
+		ExpiresIn:            300,
+		RefreshExpiresIn:     1800,
+		MaxSensorsPerRequest: webdrops.DefaultSensorsBatchSize,
+		fixtures:             fixtures,
+		accessTokens:         map[string]bool{},
+		refreshTokens:        map[string]bool{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/auth/token", srv.token)
+	mux.HandleFunc("/sensors/list/", srv.authorized(srv.sensorsList))
+	mux.HandleFunc("/sensors/data/", srv.authorized(srv.sensorsData))
+	mux.HandleFunc("/sensors/map/", srv.authorized(srv.sensorsMap))
+	mux.HandleFunc("/coverages/", srv.authorized(srv.coverages))
+
+	srv.Server = httptest.NewServer(mux)
+	return srv
+}
+
+// SessionOptions returns options suitable to create
+// a webdrops.Session that connects to this server.
+func (srv *Server) SessionOptions() webdrops.SessionOptions {
+	return webdrops.SessionOptions{
+		Transport: srv.Client().Transport,
+		URL:       srv.URL + "/",
+		AuthURL:   srv.URL + "/auth/token",
+		ClientID:  ClientID,
+		User:      User,
+		Password:  Password,
+	}
+}
+
+// PasswordLogins returns the number of successful
+// logins performed with grant_type password.
+func (srv *Server) PasswordLogins() int {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.passwordLogins
+}
+
+// Refreshes returns the number of successful
+// token refreshes performed with grant_type refresh_token.
+func (srv *Server) Refreshes() int {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.refreshes
+}
+
+// Requests returns the paths of all authorized
+// requests received by the server, in order of arrival.
+func (srv *Server) Requests() []string {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return append([]string{}, srv.requests...)
+}
+
+func (srv *Server) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.PostForm.Get("client_id") != ClientID {
+		http.Error(w, "invalid client", http.StatusUnauthorized)
+		return
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	switch r.PostForm.Get("grant_type") {
+	case "password":
+		if r.PostForm.Get("username") != User || r.PostForm.Get("password") != Password {
+			http.Error(w, "invalid user credentials", http.StatusUnauthorized)
+			return
+		}
+		srv.passwordLogins++
+	case "refresh_token":
+		refreshToken := r.PostForm.Get("refresh_token")
+		if !srv.refreshTokens[refreshToken] {
+			http.Error(w, "invalid refresh token", http.StatusBadRequest)
+			return
+		}
+		delete(srv.refreshTokens, refreshToken)
+		srv.refreshes++
+	default:
+		http.Error(w, "unsupported grant type", http.StatusBadRequest)
+		return
+	}
+
+	srv.tokenSeq++
+	accessToken := fmt.Sprintf("access-%d", srv.tokenSeq)
+	refreshToken := fmt.Sprintf("refresh-%d", srv.tokenSeq)
+	srv.accessTokens[accessToken] = true
+	srv.refreshTokens[refreshToken] = true
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":       accessToken,
+		"expires_in":         srv.ExpiresIn,
+		"refresh_expires_in": srv.RefreshExpiresIn,
+		"refresh_token":      refreshToken,
+		"token_type":         "bearer",
+	})
+}
+
+// RevokeTokens invalidates all access and
+// refresh tokens issued so far.
+func (srv *Server) RevokeTokens() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.accessTokens = map[string]bool{}
+	srv.refreshTokens = map[string]bool{}
+}
+
+// Fail makes the next n authorized requests fail
+// with given HTTP status and response headers.
+func (srv *Server) Fail(n int, status int, header http.Header) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for i := 0; i < n; i++ {
+		srv.failures = append(srv.failures, failure{status, header})
+	}
+}
+
+func (srv *Server) authorized(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		srv.mu.Lock()
+		valid := srv.accessTokens[token]
+		var fail *failure
+		if valid {
+			srv.requests = append(srv.requests, r.URL.Path)
+			if len(srv.failures) > 0 {
+				fail = &srv.failures[0]
+				srv.failures = srv.failures[1:]
+			}
+		}
+		srv.mu.Unlock()
+
+		if !valid {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if fail != nil {
+			for name, values := range fail.header {
+				w.Header()[name] = values
+			}
+			http.Error(w, http.StatusText(fail.status), fail.status)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (srv *Server) serveFixture(w http.ResponseWriter, contentType string, names ...string) {
+	for _, name := range names {
+		content, err := fs.ReadFile(srv.fixtures, name)
+		if err != nil {
+			continue
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Write(content)
+		return
+	}
+	http.Error(w, "not found", http.StatusNotFound)
+}
+
+// pathArgs splits the path of r, removing given prefix.
+func pathArgs(r *http.Request, prefix string) []string {
+	return strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, prefix), "/"), "/")
+}
+
+// GET /sensors/list/<CLASS>?stationgroup=<GROUP>
+func (srv *Server) sensorsList(w http.ResponseWriter, r *http.Request) {
+	args := pathArgs(r, "/sensors/list/")
+	srv.serveFixture(w, "application/json", path.Join("sensors/list", args[0]+".json"))
+}
+
+// GET /sensors/data/<CLASS>/<GROUP>?from=<FROM>&to=<TO>&aggr=<AGGR>
+// POST /sensors/data/<CLASS>/?from=<FROM>&to=<TO>&aggr=<AGGR>
+func (srv *Server) sensorsData(w http.ResponseWriter, r *http.Request) {
+	args := pathArgs(r, "/sensors/data/")
+	fixture := path.Join("sensors/data", args[0]+".json")
+	if r.Method != http.MethodPost {
+		srv.serveFixture(w, "application/json", fixture)
+		return
+	}
+
+	var body struct {
+		Sensors []string `json:"sensors"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body.Sensors) > srv.MaxSensorsPerRequest {
+		http.Error(w, "too many sensors", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	content, err := fs.ReadFile(srv.fixtures, fixture)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	var series []struct {
+		SensorID string `json:"sensorId"`
+	}
+	var rawSeries []json.RawMessage
+	if err := json.Unmarshal(content, &series); err != nil {
+		http.Error(w, "invalid data fixture: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.Unmarshal(content, &rawSeries)
+
+	requested := map[string]bool{}
+	for _, id := range body.Sensors {
+		requested[id] = true
+	}
+
+	result := []json.RawMessage{}
+	for i, s := range series {
+		if requested[s.SensorID] {
+			result = append(result, rawSeries[i])
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// GET /sensors/map/<CLASS>/?from=<FROM>&to=<TO>&stationgroup=<GROUP>
+func (srv *Server) sensorsMap(w http.ResponseWriter, r *http.Request) {
+	args := pathArgs(r, "/sensors/map/")
+	srv.serveFixture(w, "application/octet-stream", path.Join("sensors/map", args[0]+".nc"))
+}
+
+// GET /coverages/<DATASET>/?from=<FROM>&to=<TO>
+// GET /coverages/<DATASET>/<DATE>/<VARNAME>/-/all
+func (srv *Server) coverages(w http.ResponseWriter, r *http.Request) {
+	args := pathArgs(r, "/coverages/")
+	dataset := args[0]
+
+	if len(args) == 1 {
+		srv.timeline(w, r, dataset)
+		return
+	}
+
+	if len(args) != 5 {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	varName := args[2]
+	srv.serveFixture(
+		w, "application/octet-stream",
+		path.Join("coverages", dataset, varName+".nc"),
+		"coverages/data.nc",
+	)
+}
+
+func (srv *Server) timeline(w http.ResponseWriter, r *http.Request, dataset string) {
+	from, err := time.Parse("200601021504", r.URL.Query().Get("from"))
+	if err != nil {
+		http.Error(w, "invalid from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := time.Parse("200601021504", r.URL.Query().Get("to"))
+	if err != nil {
+		http.Error(w, "invalid to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var content []byte
+	for _, name := range []string{path.Join("coverages", dataset, "timeline.json"), "coverages/timeline.json"} {
+		content, err = fs.ReadFile(srv.fixtures, name)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	var timeline []string
+	if err := json.Unmarshal(content, &timeline); err != nil {
+		http.Error(w, "invalid timeline fixture: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result := []string{}
+	for _, instantS := range timeline {
+		instant, err := time.Parse("200601021504", instantS)
+		if err != nil {
+			http.Error(w, "invalid timeline fixture: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if instant.Before(from) || instant.After(to) {
+			continue
+		}
+		result = append(result, instantS)
+	}
+	sort.Strings(result)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,187 @@
+package wps
+
+import (
+	"fmt"
+	"math"
+)
+
+// earthRadius is the radius of the earth used by WPS, in meters.
+const earthRadius = 6370000.0
+
+const (
+	rad = math.Pi / 180
+	deg = 180 / math.Pi
+)
+
+// projection converts grid coordinates of the
+// outermost domain, expressed as 1-based mass
+// point indices, to latitude and longitude.
+type projection interface {
+	latLon(i, j float64) (lat, lon float64)
+}
+
+// projectionParams are the &geogrid settings
+// that define the projection of a WPS grid.
+type projectionParams struct {
+	mapProj            string
+	refLat, refLon     float64
+	refX, refY         float64
+	truelat1, truelat2 float64
+	standLon           float64
+	dx, dy             float64
+}
+
+func newProjection(p projectionParams) (projection, error) {
+	switch p.mapProj {
+	case "lambert":
+		return newLambert(p), nil
+	case "mercator":
+		return newMercator(p), nil
+	case "polar":
+		return newPolar(p), nil
+	case "lat-lon":
+		return latLonProj{p}, nil
+	}
+	return nil, fmt.Errorf("unsupported map_proj `%s`", p.mapProj)
+}
+
+// normalizeLon returns lon in the [-180, 180] range.
+func normalizeLon(lon float64) float64 {
+	for lon > 180 {
+		lon -= 360
+	}
+	for lon < -180 {
+		lon += 360
+	}
+	return lon
+}
+
+// lambert is the Lambert conformal conic projection.
+type lambert struct {
+	projectionParams
+	hemi, cone   float64
+	rebydx       float64
+	polei, polej float64
+	chi1, chi2   float64
+}
+
+func newLambert(p projectionParams) *lambert {
+	l := &lambert{projectionParams: p, hemi: 1}
+	if p.truelat1 < 0 {
+		l.hemi = -1
+	}
+
+	if math.Abs(p.truelat1-p.truelat2) > 0.1 {
+		l.cone = (math.Log10(math.Cos(p.truelat1*rad)) - math.Log10(math.Cos(p.truelat2*rad))) /
+			(math.Log10(math.Tan((45-math.Abs(p.truelat1)/2)*rad)) - math.Log10(math.Tan((45-math.Abs(p.truelat2)/2)*rad)))
+	} else {
+		l.cone = math.Sin(math.Abs(p.truelat1) * rad)
+	}
+
+	deltaLon := normalizeLon(p.refLon - p.standLon)
+	l.rebydx = earthRadius / p.dx
+	rsw := l.rebydx * math.Cos(p.truelat1*rad) / l.cone *
+		math.Pow(math.Tan((90*l.hemi-p.refLat)*rad/2)/math.Tan((90*l.hemi-p.truelat1)*rad/2), l.cone)
+	arg := l.cone * deltaLon * rad
+	l.polei = l.hemi*p.refX - l.hemi*rsw*math.Sin(arg)
+	l.polej = l.hemi*p.refY + rsw*math.Cos(arg)
+
+	l.chi1 = (90 - l.hemi*p.truelat1) * rad
+	l.chi2 = (90 - l.hemi*p.truelat2) * rad
+	return l
+}
+
+func (l *lambert) latLon(i, j float64) (float64, float64) {
+	xx := l.hemi*i - l.polei
+	yy := l.polej - l.hemi*j
+	r2 := xx*xx + yy*yy
+	if r2 == 0 {
+		return l.hemi * 90, l.standLon
+	}
+
+	r := math.Sqrt(r2) / l.rebydx
+	lon := normalizeLon(l.standLon + deg*math.Atan2(l.hemi*xx, yy)/l.cone)
+
+	var chi float64
+	if l.chi1 == l.chi2 {
+		chi = 2 * math.Atan(math.Pow(r/math.Tan(l.chi1), 1/l.cone)*math.Tan(l.chi1*0.5))
+	} else {
+		chi = 2 * math.Atan(math.Pow(r*l.cone/math.Sin(l.chi1), 1/l.cone)*math.Tan(l.chi1*0.5))
+	}
+	return (90 - chi*deg) * l.hemi, lon
+}
+
+// mercator is the Mercator projection.
+type mercator struct {
+	projectionParams
+	dlon, rsw float64
+}
+
+func newMercator(p projectionParams) *mercator {
+	m := &mercator{projectionParams: p}
+	m.dlon = p.dx / (earthRadius * math.Cos(p.truelat1*rad))
+	if p.refLat != 0 {
+		m.rsw = math.Log(math.Tan(0.5*(p.refLat+90)*rad)) / m.dlon
+	}
+	return m
+}
+
+func (m *mercator) latLon(i, j float64) (float64, float64) {
+	lat := 2*math.Atan(math.Exp(m.dlon*(m.rsw+j-m.refY)))*deg - 90
+	lon := normalizeLon((i-m.refX)*m.dlon*deg + m.refLon)
+	return lat, lon
+}
+
+// polar is the polar stereographic projection.
+type polar struct {
+	projectionParams
+	hemi, reflon float64
+	rebydx       float64
+	scaleTop     float64
+	polei, polej float64
+}
+
+func newPolar(p projectionParams) *polar {
+	ps := &polar{projectionParams: p, hemi: 1}
+	if p.truelat1 < 0 {
+		ps.hemi = -1
+	}
+	ps.reflon = p.standLon + 90
+	ps.rebydx = earthRadius / p.dx
+	ps.scaleTop = 1 + ps.hemi*math.Sin(p.truelat1*rad)
+
+	ala1 := p.refLat * rad
+	rsw := ps.rebydx * math.Cos(ala1) * ps.scaleTop / (1 + ps.hemi*math.Sin(ala1))
+	alo1 := (p.refLon - ps.reflon) * rad
+	ps.polei = p.refX - rsw*math.Cos(alo1)
+	ps.polej = p.refY - ps.hemi*rsw*math.Sin(alo1)
+	return ps
+}
+
+func (ps *polar) latLon(i, j float64) (float64, float64) {
+	xx := i - ps.polei
+	yy := (j - ps.polej) * ps.hemi
+	r2 := xx*xx + yy*yy
+	if r2 == 0 {
+		return ps.hemi * 90, normalizeLon(ps.reflon)
+	}
+
+	gi2 := math.Pow(ps.rebydx*ps.scaleTop, 2)
+	lat := deg * ps.hemi * math.Asin((gi2-r2)/(gi2+r2))
+	arccos := math.Acos(xx / math.Sqrt(r2))
+	lon := ps.reflon - deg*arccos
+	if yy > 0 {
+		lon = ps.reflon + deg*arccos
+	}
+	return lat, normalizeLon(lon)
+}
+
+// latLonProj is the regular, not rotated, latitude
+// longitude projection. dx and dy are in degrees.
+type latLonProj struct {
+	projectionParams
+}
+
+func (p latLonProj) latLon(i, j float64) (float64, float64) {
+	return p.refLat + (j-p.refY)*p.dy, normalizeLon(p.refLon + (i-p.refX)*p.dx)
+}
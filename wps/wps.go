@@ -0,0 +1,212 @@
+// Package wps calculates the geographic extent of the
+// WRF domains defined in a WPS namelist.wps file.
+//
+// Lambert conformal, Mercator, polar stereographic and
+// regular (not rotated) latitude/longitude projections
+// are supported, using the same formulas of WPS geogrid.
+package wps
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/cima-lexis/lexisdn/namelist"
+	"github.com/cima-lexis/lexisdn/webdrops"
+)
+
+// Domain is a WRF domain defined in a namelist.wps.
+type Domain struct {
+	// ID is the 1-based number of the domain.
+	ID int
+	// ParentID is the ID of the parent domain,
+	// equal to ID for the outermost domain.
+	ParentID int
+	// Nx and Ny are the number of
+	// mass points in each direction.
+	Nx, Ny int
+	// Bounds is the lat/lon bounding
+	// box of all mass points of the domain.
+	Bounds webdrops.Domain
+}
+
+// Load reads the namelist.wps at path and
+// returns all the domains it defines.
+func Load(path string) ([]Domain, error) {
+	nl, err := namelist.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	domains, err := Domains(nl)
+	if err != nil {
+		return nil, fmt.Errorf("error reading domains from `%s`: %w", path, err)
+	}
+	return domains, nil
+}
+
+// Domains returns all domains defined by the namelist.wps nl.
+func Domains(nl namelist.Namelist) ([]Domain, error) {
+	maxDom, err := nl.Int("share", "max_dom")
+	if err != nil {
+		return nil, err
+	}
+
+	settings := map[string][]int{}
+	for _, name := range []string{"parent_id", "parent_grid_ratio", "i_parent_start", "j_parent_start", "e_we", "e_sn"} {
+		values, err := nl.Ints("geogrid", name)
+		if err != nil {
+			return nil, err
+		}
+		if len(values) < maxDom {
+			return nil, fmt.Errorf("%s has %d values, expected %d", name, len(values), maxDom)
+		}
+		settings[name] = values
+	}
+
+	proj, err := readProjection(nl, settings["e_we"][0], settings["e_sn"][0])
+	if err != nil {
+		return nil, err
+	}
+
+	// offset and scale of each domain mass grid
+	// coordinates in the outermost domain grid.
+	offsetX := make([]float64, maxDom)
+	offsetY := make([]float64, maxDom)
+	scale := make([]float64, maxDom)
+	domains := make([]Domain, maxDom)
+
+	for n := 0; n < maxDom; n++ {
+		d := Domain{
+			ID:       n + 1,
+			ParentID: settings["parent_id"][n],
+			Nx:       settings["e_we"][n] - 1,
+			Ny:       settings["e_sn"][n] - 1,
+		}
+		if d.Nx < 1 || d.Ny < 1 {
+			return nil, fmt.Errorf("domain %d: invalid e_we or e_sn", d.ID)
+		}
+
+		if n == 0 {
+			d.ParentID = d.ID
+			scale[n] = 1
+		} else {
+			parent := d.ParentID - 1
+			if parent < 0 || parent >= n {
+				return nil, fmt.Errorf("domain %d: invalid parent_id %d", d.ID, d.ParentID)
+			}
+			ratio := float64(settings["parent_grid_ratio"][n])
+			if ratio < 1 {
+				return nil, fmt.Errorf("domain %d: invalid parent_grid_ratio", d.ID)
+			}
+			// the lower left staggered corner of the nest lies on
+			// the staggered point i_parent_start of its parent.
+			// A nest mass point x is at (i_parent_start - 0.5) +
+			// (x - 0.5) / ratio in parent mass coordinates.
+			iStart := float64(settings["i_parent_start"][n])
+			jStart := float64(settings["j_parent_start"][n])
+			scale[n] = scale[parent] / ratio
+			offsetX[n] = offsetX[parent] + scale[parent]*(iStart-0.5-0.5/ratio)
+			offsetY[n] = offsetY[parent] + scale[parent]*(jStart-0.5-0.5/ratio)
+		}
+
+		d.Bounds = bounds(proj, d.Nx, d.Ny, func(i, j float64) (float64, float64) {
+			return offsetX[n] + scale[n]*i, offsetY[n] + scale[n]*j
+		})
+		domains[n] = d
+	}
+
+	return domains, nil
+}
+
+// bounds returns the bounding box of the lat/lon
+// coordinates of all points on the perimeter of a
+// nx * ny mass grid.
+func bounds(proj projection, nx, ny int, toOuter func(i, j float64) (float64, float64)) webdrops.Domain {
+	b := webdrops.Domain{
+		MinLat: math.Inf(1),
+		MinLon: math.Inf(1),
+		MaxLat: math.Inf(-1),
+		MaxLon: math.Inf(-1),
+	}
+
+	add := func(i, j int) {
+		lat, lon := proj.latLon(toOuter(float64(i), float64(j)))
+		b.MinLat = math.Min(b.MinLat, lat)
+		b.MaxLat = math.Max(b.MaxLat, lat)
+		b.MinLon = math.Min(b.MinLon, lon)
+		b.MaxLon = math.Max(b.MaxLon, lon)
+	}
+
+	for i := 1; i <= nx; i++ {
+		add(i, 1)
+		add(i, ny)
+	}
+	for j := 1; j <= ny; j++ {
+		add(1, j)
+		add(nx, j)
+	}
+	return b
+}
+
+func readProjection(nl namelist.Namelist, eWE, eSN int) (projection, error) {
+	mapProj, err := nl.String("geogrid", "map_proj")
+	if err != nil {
+		return nil, err
+	}
+
+	p := projectionParams{
+		mapProj: strings.ToLower(mapProj),
+		// the reference point defaults to
+		// the center of the outermost domain
+		refX: float64(eWE) / 2,
+		refY: float64(eSN) / 2,
+	}
+
+	floats := map[string]*float64{
+		"ref_lat": &p.refLat,
+		"ref_lon": &p.refLon,
+		"dx":      &p.dx,
+		"dy":      &p.dy,
+	}
+	for name, field := range floats {
+		if *field, err = nl.Float("geogrid", name); err != nil {
+			return nil, err
+		}
+	}
+
+	// optional settings, defaulting as in WPS
+	optional := map[string]*float64{
+		"ref_x":     &p.refX,
+		"ref_y":     &p.refY,
+		"truelat1":  &p.truelat1,
+		"truelat2":  &p.truelat2,
+		"stand_lon": &p.standLon,
+	}
+	p.standLon = p.refLon
+	for name, field := range optional {
+		if value, err := nl.Float("geogrid", name); err == nil {
+			*field = value
+		}
+	}
+	if _, err := nl.Float("geogrid", "truelat2"); err != nil {
+		p.truelat2 = p.truelat1
+	}
+
+	if p.mapProj == "lat-lon" {
+		for _, name := range []string{"pole_lat", "pole_lon"} {
+			value, err := nl.Float("geogrid", name)
+			if err != nil {
+				continue
+			}
+			if (name == "pole_lat" && value != 90) || (name == "pole_lon" && value != 0) {
+				return nil, fmt.Errorf("rotated lat-lon projections are not supported")
+			}
+		}
+	}
+
+	if p.dx <= 0 || p.dy <= 0 {
+		return nil, fmt.Errorf("invalid dx or dy")
+	}
+
+	return newProjection(p)
+}
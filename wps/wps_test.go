@@ -0,0 +1,233 @@
+package wps
+
+import (
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cima-lexis/lexisdn/namelist"
+	"github.com/cima-lexis/lexisdn/webdrops"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// distance returns the great circle distance
+// in meters between two points.
+func distance(lat1, lon1, lat2, lon2 float64) float64 {
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Pow(math.Sin(dLat/2), 2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Pow(math.Sin(dLon/2), 2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
+
+func TestProjectionsReferencePoint(t *testing.T) {
+	params := []projectionParams{
+		{mapProj: "lambert", refLat: 42, refLon: 12.5, refX: 50, refY: 50, truelat1: 30, truelat2: 60, standLon: 12.5, dx: 22500, dy: 22500},
+		{mapProj: "lambert", refLat: 42, refLon: 10, refX: 10, refY: 80, truelat1: 45, truelat2: 45, standLon: 12.5, dx: 3000, dy: 3000},
+		{mapProj: "lambert", refLat: -35, refLon: 150, refX: 40, refY: 40, truelat1: -30, truelat2: -60, standLon: 145, dx: 12000, dy: 12000},
+		{mapProj: "mercator", refLat: 10, refLon: -60, refX: 30, refY: 30, truelat1: 0, standLon: -60, dx: 10000, dy: 10000},
+		{mapProj: "polar", refLat: 70, refLon: 20, refX: 60, refY: 60, truelat1: 60, standLon: 0, dx: 20000, dy: 20000},
+		{mapProj: "polar", refLat: -75, refLon: 100, refX: 60, refY: 60, truelat1: -60, standLon: 90, dx: 20000, dy: 20000},
+		{mapProj: "lat-lon", refLat: 40, refLon: 10, refX: 50, refY: 30, dx: 0.25, dy: 0.25},
+	}
+
+	for _, p := range params {
+		proj, err := newProjection(p)
+		require.NoError(t, err)
+		lat, lon := proj.latLon(p.refX, p.refY)
+		assert.InDelta(t, p.refLat, lat, 1e-6, p.mapProj)
+		assert.InDelta(t, p.refLon, lon, 1e-6, p.mapProj)
+	}
+
+	_, err := newProjection(projectionParams{mapProj: "rotated_ll"})
+	assert.Error(t, err)
+}
+
+func TestProjectionsScaleAtTrueLatitude(t *testing.T) {
+	// at true latitudes the map scale factor is 1, so adjacent
+	// grid points are dx meters apart.
+	params := []projectionParams{
+		{mapProj: "lambert", refLat: 30, refLon: 12.5, refX: 50, refY: 50, truelat1: 30, truelat2: 60, standLon: 12.5, dx: 10000},
+		{mapProj: "mercator", refLat: 20, refLon: 0, refX: 50, refY: 50, truelat1: 20, standLon: 0, dx: 10000},
+		{mapProj: "polar", refLat: 60, refLon: 30, refX: 50, refY: 50, truelat1: 60, standLon: 0, dx: 10000},
+	}
+
+	for _, p := range params {
+		proj, err := newProjection(p)
+		require.NoError(t, err)
+
+		lat, lon := proj.latLon(p.refX+0.5, p.refY)
+		lat0, lon0 := proj.latLon(p.refX-0.5, p.refY)
+		assert.InEpsilon(t, p.dx, distance(lat0, lon0, lat, lon), 1e-3, p.mapProj+" along i")
+
+		lat, lon = proj.latLon(p.refX, p.refY+0.5)
+		lat0, lon0 = proj.latLon(p.refX, p.refY-0.5)
+		assert.InEpsilon(t, p.dx, distance(lat0, lon0, lat, lon), 1e-3, p.mapProj+" along j")
+	}
+}
+
+func TestLambertSnyderExample(t *testing.T) {
+	// numerical example of the spherical Lambert conformal conic
+	// projection in Snyder, Map Projections: A Working Manual,
+	// USGS Professional Paper 1395, p. 295: with R = 1, the point
+	// at 35N 75W lies at x = 0.2966785, y = 0.2462112 from the
+	// origin at 23N 96W. Grid units of earthRadius meters make
+	// R = 1 in grid coordinates.
+	proj, err := newProjection(projectionParams{
+		mapProj: "lambert", refLat: 23, refLon: -96, truelat1: 33, truelat2: 45, standLon: -96,
+		dx: earthRadius, dy: earthRadius,
+	})
+	require.NoError(t, err)
+
+	lat, lon := proj.latLon(0.2966785, 0.2462112)
+	assert.InDelta(t, 35, lat, 1e-5)
+	assert.InDelta(t, -75, lon, 1e-5)
+}
+
+// geoEmCorners reads the corner_lats and corner_lons global
+// attributes from the output of `ncdump -h geo_em.d01.nc`.
+func geoEmCorners(t *testing.T, path string) (lats, lons []float64) {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		t.Skipf("%s not found, write it with ncdump -h of a geo_em.d01.nc generated from the namelist.wps beside it", path)
+	}
+	require.NoError(t, err)
+
+	attribute := func(name string) []float64 {
+		for _, line := range strings.Split(string(content), "\n") {
+			line = strings.TrimSpace(line)
+			if !strings.HasPrefix(line, ":"+name+" =") {
+				continue
+			}
+			var values []float64
+			line = strings.TrimSuffix(strings.TrimPrefix(line, ":"+name+" ="), ";")
+			for _, field := range strings.Split(line, ",") {
+				v, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(field), "f"), 64)
+				require.NoError(t, err, name)
+				values = append(values, v)
+			}
+			return values
+		}
+		t.Fatalf("%s: attribute %s not found", path, name)
+		return nil
+	}
+	return attribute("corner_lats"), attribute("corner_lons")
+}
+
+func TestLambertGeoEmCorners(t *testing.T) {
+	lats, lons := geoEmCorners(t, "testdata/lambert/geo_em.d01.cdl")
+	require.GreaterOrEqual(t, len(lats), 4)
+	require.GreaterOrEqual(t, len(lons), 4)
+
+	nl, err := namelist.Load("testdata/lambert/namelist.wps")
+	require.NoError(t, err)
+	domains, err := Domains(nl)
+	require.NoError(t, err)
+	d := domains[0]
+	proj, err := readProjection(nl, d.Nx+1, d.Ny+1)
+	require.NoError(t, err)
+
+	// the first 4 corners are the mass points at the lower
+	// left, upper left, upper right and lower right.
+	corners := [][2]int{{1, 1}, {1, d.Ny}, {d.Nx, d.Ny}, {d.Nx, 1}}
+	for n, c := range corners {
+		lat, lon := proj.latLon(float64(c[0]), float64(c[1]))
+		// attributes are single precision
+		assert.InDelta(t, lats[n], lat, 1e-3, "corner %d", n+1)
+		assert.InDelta(t, lons[n], lon, 1e-3, "corner %d", n+1)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	domains, err := Load("testdata/namelist.wps")
+	require.NoError(t, err)
+	require.Len(t, domains, 3)
+
+	d1, d2, d3 := domains[0], domains[1], domains[2]
+	assert.Equal(t, 1, d1.ID)
+	assert.Equal(t, 1, d1.ParentID)
+	assert.Equal(t, 100, d1.Nx)
+	assert.Equal(t, 100, d1.Ny)
+	assert.Equal(t, 2, d3.ParentID)
+
+	// the outermost domain is centered on ref_lon == stand_lon
+	assert.InDelta(t, 12.5, (d1.Bounds.MinLon+d1.Bounds.MaxLon)/2, 1e-6)
+	// about 100 * 22.5 km high, plus the curvature of parallels
+	height := distance(d1.Bounds.MinLat, 12.5, d1.Bounds.MaxLat, 12.5)
+	assert.InEpsilon(t, 99*22500.0, height, 0.1)
+
+	inside := func(inner, outer webdrops.Domain) bool {
+		return inner.MinLat > outer.MinLat && inner.MaxLat < outer.MaxLat &&
+			inner.MinLon > outer.MinLon && inner.MaxLon < outer.MaxLon
+	}
+	assert.True(t, inside(d2.Bounds, d1.Bounds))
+	assert.True(t, inside(d3.Bounds, d2.Bounds))
+
+	// domain 2 covers parent cells 31 to 70, so it is
+	// centered on the parent, as domain 3 on domain 2.
+	assert.InDelta(t, 12.5, (d2.Bounds.MinLon+d2.Bounds.MaxLon)/2, 1e-6)
+	assert.InDelta(t, 12.5, (d3.Bounds.MinLon+d3.Bounds.MaxLon)/2, 1e-6)
+}
+
+func TestNestedLatLon(t *testing.T) {
+	nl, err := namelist.Parse(strings.NewReader(`
+&share
+ max_dom = 2,
+/
+&geogrid
+ parent_id = 1, 1,
+ parent_grid_ratio = 1, 4,
+ i_parent_start = 1, 11,
+ j_parent_start = 1, 21,
+ e_we = 41, 41,
+ e_sn = 21, 17,
+ map_proj = 'lat-lon',
+ ref_lat = 40,
+ ref_lon = 10,
+ dx = 0.5,
+ dy = 0.25,
+/
+`))
+	require.NoError(t, err)
+
+	domains, err := Domains(nl)
+	require.NoError(t, err)
+	require.Len(t, domains, 2)
+
+	// 40x20 mass points, centered on the reference point
+	// at mass coordinates (20.5, 10.5)
+	assert.InDelta(t, 10-19.5*0.5, domains[0].Bounds.MinLon, 1e-9)
+	assert.InDelta(t, 10+19.5*0.5, domains[0].Bounds.MaxLon, 1e-9)
+	assert.InDelta(t, 40-9.5*0.25, domains[0].Bounds.MinLat, 1e-9)
+	assert.InDelta(t, 40+9.5*0.25, domains[0].Bounds.MaxLat, 1e-9)
+
+	// the nest staggered corner lies on the parent staggered
+	// point (11, 21), its first mass point is 1/8 of a parent
+	// cell away from it.
+	westEdge := domains[0].Bounds.MinLon + (11-1.5)*0.5
+	southEdge := domains[0].Bounds.MinLat + (21-1.5)*0.25
+	assert.InDelta(t, westEdge+0.5/8, domains[1].Bounds.MinLon, 1e-9)
+	assert.InDelta(t, westEdge+0.5/8+39*0.5/4, domains[1].Bounds.MaxLon, 1e-9)
+	assert.InDelta(t, southEdge+0.25/8, domains[1].Bounds.MinLat, 1e-9)
+	assert.InDelta(t, southEdge+0.25/8+15*0.25/4, domains[1].Bounds.MaxLat, 1e-9)
+}
+
+func TestDomainsErrors(t *testing.T) {
+	invalid := map[string]string{
+		"missing max_dom": "&share\n/\n&geogrid\n/\n",
+		"too few values":  "&share\n max_dom = 2\n/\n&geogrid\n parent_id = 1\n parent_grid_ratio = 1\n i_parent_start = 1\n j_parent_start = 1\n e_we = 10\n e_sn = 10\n/\n",
+		"rotated": "&share\n max_dom = 1\n/\n&geogrid\n parent_id = 1\n parent_grid_ratio = 1\n i_parent_start = 1\n j_parent_start = 1\n e_we = 10\n e_sn = 10\n" +
+			" map_proj = 'lat-lon'\n ref_lat = 0\n ref_lon = 0\n dx = 1\n dy = 1\n pole_lat = 40\n/\n",
+		"unknown projection": "&share\n max_dom = 1\n/\n&geogrid\n parent_id = 1\n parent_grid_ratio = 1\n i_parent_start = 1\n j_parent_start = 1\n e_we = 10\n e_sn = 10\n" +
+			" map_proj = 'gnomonic'\n ref_lat = 0\n ref_lon = 0\n dx = 1\n dy = 1\n/\n",
+	}
+
+	for name, content := range invalid {
+		nl, err := namelist.Parse(strings.NewReader(content))
+		require.NoError(t, err, name)
+		_, err = Domains(nl)
+		assert.Error(t, err, name)
+	}
+}